@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Append-only log of actions that change bitrix data
+// All bitrix requests go from the webhook user so this is the only record of who did what
+
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	TgId        int64     `json:"tgId"`
+	Username    string    `json:"username,omitempty"`
+	Portal      string    `json:"portal,omitempty"` // Bitrix portal of the user, empty is the default one
+	BxId        int64     `json:"bxId"`
+	Action      Action    `json:"action"`
+	Entity      string    `json:"entity"`                // Like deal:12 or task:34
+	PayloadHash string    `json:"payloadHash,omitempty"` // sha256 of request data - data itself could be private
+	Result      string    `json:"result"`                // AuditResultOk, AuditResultDenied or error text
+}
+
+const (
+	AuditResultOk     = "ok"
+	AuditResultDenied = "denied"
+)
+
+// Entries filter - zero fields are ignored
+type AuditQuery struct {
+	TgId     int64
+	Username string // Without @, case insensitive
+	Portal   string // Is matched even if empty - admins see only their portal
+	BxId     int64
+	Entity   string
+	From     time.Time
+	To       time.Time // Exclusive
+	Limit    int       // The latest entries are returned
+}
+
+func (q AuditQuery) Match(e AuditEntry) bool {
+	return (q.TgId == 0 || e.TgId == q.TgId) &&
+		(q.Username == "" || strings.EqualFold(e.Username, q.Username)) &&
+		e.Portal == q.Portal &&
+		(q.BxId == 0 || e.BxId == q.BxId) &&
+		(q.Entity == "" || e.Entity == q.Entity) &&
+		(q.From.IsZero() || !e.Time.Before(q.From)) &&
+		(q.To.IsZero() || e.Time.Before(q.To))
+}
+
+// Audit log sink
+type AuditLog interface {
+	Append(e AuditEntry) error
+	Query(q AuditQuery) ([]AuditEntry, error) // Matching entries from newest to oldest
+	io.Closer
+}
+
+// Returns hex sha256 of payload json
+func PayloadHash(payload any) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns result text of the action
+func AuditResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return AuditResultOk
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditQueryMatch(t *testing.T) {
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	e := AuditEntry{
+		Time:     day.Add(12 * time.Hour),
+		TgId:     1,
+		Username: "Ivan",
+		Portal:   "north",
+		BxId:     12,
+		Action:   ActionComment,
+		Entity:   "deal:5",
+	}
+	tests := []struct {
+		name string
+		q    AuditQuery
+		want bool
+	}{
+		{"portal only", AuditQuery{Portal: "north"}, true},
+		{"other portal", AuditQuery{Portal: "south"}, false},
+		{"default portal does not match named one", AuditQuery{}, false},
+		{"tg id", AuditQuery{Portal: "north", TgId: 1}, true},
+		{"other tg id", AuditQuery{Portal: "north", TgId: 2}, false},
+		{"username is case insensitive", AuditQuery{Portal: "north", Username: "ivan"}, true},
+		{"other username", AuditQuery{Portal: "north", Username: "petr"}, false},
+		{"bx id", AuditQuery{Portal: "north", BxId: 12}, true},
+		{"other bx id", AuditQuery{Portal: "north", BxId: 13}, false},
+		{"entity", AuditQuery{Portal: "north", Entity: "deal:5"}, true},
+		{"other entity", AuditQuery{Portal: "north", Entity: "deal:6"}, false},
+		{"inside range", AuditQuery{Portal: "north", From: day, To: day.AddDate(0, 0, 1)}, true},
+		{"from is inclusive", AuditQuery{Portal: "north", From: e.Time}, true},
+		{"to is exclusive", AuditQuery{Portal: "north", To: e.Time}, false},
+		{"before range", AuditQuery{Portal: "north", From: day.AddDate(0, 0, 1)}, false},
+		{"all fields", AuditQuery{Portal: "north", TgId: 1, Username: "IVAN", BxId: 12, Entity: "deal:5", From: day, To: day.AddDate(0, 0, 1)}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Match(e); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
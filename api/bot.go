@@ -1,18 +1,32 @@
 package api
 
 import (
+	"time"
+
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/log"
 	tele "gopkg.in/telebot.v4"
 )
 
 type Bot interface {
-	Start() error
+	Start() error // Blocks until Stop
+	Stop()        // Stops polling and background servers - stores could be closed after Start returns
 
 	GetLogsOutput() log.Output // For tg logging
 }
 
 type Session interface {
 	OnStart(c tele.Context) error
+	OnCancel(c tele.Context) error // Cancels current dialog(e.g. writing comment)
+	SetLocalizer(tr Localizer)     // Changes language of the session
+	Role() Role                    // Role of the user - is resolved on auth
+	Status() SessionStatus         // Is shown to admins
+}
+
+// Snapshot of session state
+type SessionStatus struct {
+	Started time.Time
+	State   string // Current dialog state
+	Screen  string // Id of the current screen, empty if nothing is shown
 }
 
 type SessionManager interface {
@@ -20,6 +34,7 @@ type SessionManager interface {
 	Get(tgId int64) Session
 	Exist(tgId int64) bool
 
-	Start(tgId int64, u BxUser) Session
+	Start(tgId int64, portal string, u BxUser, tr Localizer, role Role) Session // Portal of the user - session works only with it
 	Stop(tgId int64)
+	Count() int // Active sessions
 }
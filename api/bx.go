@@ -2,21 +2,79 @@ package api
 
 import (
 	"io"
+	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
 )
 
 type BxUser interface {
-	ListDeals() ([]bxtypes.Deal, error)                                     // Deals that are accessable for this user. Later add stage as filter
-	AddCommentToDeal(dealId bxtypes.Id, comment string) (bxtypes.Id, error) // Add comment to this deal
-	ListDealTasks(dealId bxtypes.Id) ([]bxtypes.Task, error)                // List tasks that are attached to this deal and are not complete
-	CompleteTask(taskId bxtypes.Id) error                                   // Compete the task
-	Get() bxtypes.User                                                      // Returns user info
+	ListDeals(query DealQuery) ([]bxtypes.Deal, error)                                             // Deals that are accessable for this user and match the query
+	GetDeal(dealId bxtypes.Id) (bxtypes.Deal, error)                                               // Deal with all detail fields
+	ListDealContacts(dealId bxtypes.Id) ([]bxtypes.Contact, error)                                 // Contacts linked to the deal, primary goes first
+	GetCompany(companyId bxtypes.Id) (bxtypes.Company, error)                                      // Company info
+	AddCommentToDeal(dealId bxtypes.Id, comment string, files ...bxtypes.File) (bxtypes.Id, error) // Add comment with attached files to this deal
+	ListDealTasks(dealId, responsibleId bxtypes.Id) ([]bxtypes.Task, error)                        // List tasks of responsible(this user if zero) that are attached to this deal and are not complete
+	GetTask(taskId bxtypes.Id) (bxtypes.Task, error)                                               // Task with responsible, deadline and linked deal
+	ListTasksDueBy(deadline time.Time) ([]bxtypes.Task, error)                                     // Incomplete tasks of this user with deadline before the time, the earliest first
+	ListDealTimeline(dealId bxtypes.Id) ([]bxtypes.TimelineEntry, error)                           // Comments and activities of the deal from newest to oldest
+	ListSubordinates() ([]bxtypes.User, error)                                                     // Employees of departments that this user heads(including nested ones)
+	GetUser(userId bxtypes.Id) (bxtypes.User, error)                                               // Any user of the portal
+	DeleteDealComment(dealId, commentId bxtypes.Id) error                                          // Deletes comment of this deal
+	CompleteTask(taskId bxtypes.Id) error                                                          // Compete the task
+	RenewTask(taskId bxtypes.Id) error                                                             // Returns completed task to work
+	Get() bxtypes.User                                                                             // Returns user info
 	io.Closer
 }
 
+// Deals filter and sort order
+// Zero value means all user's deals in default order
+type DealQuery struct {
+	AssignedId  bxtypes.Id // Whose deals - this user if zero, is not a filter but a scope
+	StageId     string     // Exact stage
+	CategoryId  string     // Exact category(pipeline)
+	Title       string     // Title substring
+	CreatedFrom time.Time  // Creation date range - zero values are ignored
+	CreatedTo   time.Time
+	Sort        DealSort
+	SortDesc    bool
+}
+
+// Deal sort field
+type DealSort string
+
+const (
+	DealSortDefault   = DealSort("")
+	DealSortTitle     = DealSort("TITLE")
+	DealSortCreated   = DealSort("DATE_CREATE")
+	DealSortCloseDate = DealSort("CLOSEDATE")
+)
+
+// Checks if there is any filter(not sort) set
+func (q DealQuery) IsFiltered() bool {
+	return q.StageId != "" || q.CategoryId != "" || q.Title != "" || !q.CreatedFrom.IsZero() || !q.CreatedTo.IsZero()
+}
+
 type BxWrapper interface {
-	AuthUserByPhone(phone string) (BxUser, error) // Check if there is a user with this number and creates BxUser if it is. Nil if auth is successful, error if not
-	AuthUserById(id bxtypes.Id) (BxUser, error)   // The same thing but not we know id
+	AuthUserByPhone(phone string) (BxUser, error)                                                        // Check if there is a user with this number and creates BxUser if it is. Nil if auth is successful, error if not
+	AuthUserById(id bxtypes.Id) (BxUser, error)                                                          // The same thing but not we know id
+	FindUserByLogin(login string) (bxtypes.User, error)                                                  // Finds user by login or email - it does not prove identity, so it is not auth
+	NotifyUser(userId bxtypes.Id, message string) error                                                  // Sends system notification(im.notify) to the user
+	GetUsers(ids []bxtypes.Id) ([]bxtypes.User, error)                                                   // Users by ids, missing ones are skipped
+	GetDepartments(ids []bxtypes.Id) ([]bxtypes.Department, error)                                       // Departments by ids, missing ones are skipped
+	ListDepartments() ([]bxtypes.Department, error)                                                      // All departments of the portal
+	ListTasksDueBetween(userIds []bxtypes.Id, from, to time.Time) (map[bxtypes.Id][]bxtypes.Task, error) // Incomplete tasks of several users with deadline in range by batches, only the first page of every user
+
+	// Entities of incoming events - are requested without user
+	GetDeal(dealId bxtypes.Id) (bxtypes.Deal, error)
+	GetTask(taskId bxtypes.Id) (bxtypes.Task, error)
+	GetTimelineComment(commentId bxtypes.Id) (bxtypes.TimelineComment, error)
+	HookUserId() bxtypes.Id // Bitrix user of the webhook - changes made through the bot are made by him
+	io.Closer
+}
+
+// Registry of bitrix portals - one bot serves several tenants, each of them has its own portal
+type BxPortals interface {
+	Get(portal string) (BxWrapper, bool) // Empty name is the default portal
+	Names() []string                     // All portals, the default one goes first
 	io.Closer
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"os"
 
@@ -20,9 +21,13 @@ const (
 
 	// Session
 	ErrorInvalidBtnPayload // Invalid payload len for ex
+	ErrorFileTooBig        // Telegram does not allow bots to download big files
 
 	// Tag
 	ErrorInvalidTag
+
+	// Auth
+	ErrorRevoked // Admin revoked access to all portals of the login
 )
 
 func ErrorInternalText(err ErrorInternal) string {
@@ -39,6 +44,10 @@ func ErrorInternalText(err ErrorInternal) string {
 		return "ErrorInvalidPhoneNumber"
 	case ErrorInvalidTag:
 		return "ErrorInvalidTag"
+	case ErrorFileTooBig:
+		return "ErrorFileTooBig"
+	case ErrorRevoked:
+		return "ErrorRevoked"
 	}
 	return "unknown"
 }
@@ -64,27 +73,33 @@ func ErrorResponseText(err bxtypes.ErrorResponse) string {
 // Returns:
 //   - do add help footer
 //   - styled error
-func ErrorText(err error) (bool, string) {
+//
+// Error args are escaped because messages are sent in html mode
+func ErrorText(tr Localizer, err error) (bool, string) {
 	if err, ok := err.(bxtypes.ErrorResty); ok { // Resty
-		return true, fmt.Sprintf("ERROR:\n<code>resty level: %s</code>", err.Error())
+		return true, tr.Tr("error.resty", "err", html.EscapeString(err.Error()))
 	}
 	if err, ok := err.(bxtypes.ErrorStatusCode); ok { // HTTP status code
-		return true, fmt.Sprintf("ERROR:\n<code>http status: %s</code>", http.StatusText(int(err)))
+		return true, tr.Tr("error.status", "err", http.StatusText(int(err)))
 	}
 	if err, ok := err.(bxtypes.ErrorResponse); ok { // HTTP status code
-		return true, fmt.Sprintf("ERROR:\n<code>with response: %s</code>", ErrorResponseText(err))
+		return true, tr.Tr("error.response", "err", html.EscapeString(ErrorResponseText(err)))
 	}
 	if err, ok := err.(ErrorInternal); ok { // HTTP status code
 		switch err { // Special errors
 		case ErrorUserNotFound:
-			return false, "Пользователь с таким номером не найден."
+			return false, tr.Tr("error.userNotFound")
 		case ErrorSeveralUsersFound:
-			return false, "Ошибка: в системе зарегистрировано несколько пользователей с таким номером, обратитесь к администрации."
+			return false, tr.Tr("error.severalUsersFound")
+		case ErrorFileTooBig:
+			return false, tr.Tr("error.fileTooBig")
+		case ErrorRevoked:
+			return false, tr.Tr("error.revoked")
 		}
-		return true, fmt.Sprintf("ERROR:\n<code>internal level: %s</code>", ErrorInternalText(err))
+		return true, tr.Tr("error.internal", "err", html.EscapeString(ErrorInternalText(err)))
 	}
 
-	return true, fmt.Sprintf("ERROR:\n<code>unknown level: %s</code>", err.Error())
+	return true, tr.Tr("error.unknown", "err", html.EscapeString(err.Error()))
 }
 
 // Global flags
@@ -0,0 +1,9 @@
+package api
+
+// Translates user-facing messages to user's language
+type Localizer interface {
+	Lang() string                                 // Language code e.g. ru
+	Tr(key string, args ...any) string            // Message by key, args are key-value pairs for template like in slog
+	Plural(key string, n int, args ...any) string // Message in plural form for n, n is available in template as .n
+	Has(key string) bool                          // Checks if there is message for the key - for optional keys like stages
+}
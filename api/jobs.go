@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Durable queue of delayed jobs - survives restarts
+type JobQueue interface {
+	Schedule(job Job) (bool, error)              // Adds job or replaces not yet taken one with the same id, false if job with this id was already taken
+	Take(now time.Time) ([]Job, error)           // Removes due jobs and saves queue before return, so a job is never run twice
+	Drop(tgId int64, portal string) (int, error) // Removes not yet taken jobs of the user's account of the portal, returns their number
+	io.Closer
+}
+
+type Job struct {
+	Id      string          `json:"id"`   // Unique key
+	Kind    string          `json:"kind"` // What to do
+	RunAt   time.Time       `json:"runAt"`
+	TgId    int64           `json:"tgId"`
+	Portal  string          `json:"portal,omitempty"`  // Tenant of the job - jobs of unlinked account are dropped
+	Attempt int             `json:"attempt,omitempty"` // Number of failed runs before this one
+	Payload json.RawMessage `json:"payload,omitempty"` // Kind specific data
+}
@@ -0,0 +1,58 @@
+package api
+
+import "slices"
+
+// Access level of the user
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"     // Only looks at deals
+	RoleRep        Role = "rep"        // Works with own deals
+	RoleSupervisor Role = "supervisor" // Rep who also manages a department
+	RoleAdmin      Role = "admin"      // Manages the bot
+)
+
+// Ordered from the lowest role - is used to choose the highest of several roles
+var Roles = []Role{RoleViewer, RoleRep, RoleSupervisor, RoleAdmin}
+
+// Actions that are checked against user's role
+type Action string
+
+const (
+	ActionComment      Action = "comment"
+	ActionCompleteTask Action = "completeTask"
+	ActionViewTeam     Action = "viewTeam"  // Deals of subordinates
+	ActionAdmin        Action = "admin"     // Admin commands
+	ActionRevoke       Action = "revoke"    // Unlinking of user by admin
+	ActionUndo         Action = "undo"      // Undo of comment or task completion - is allowed to those who made the change
+	ActionBroadcast    Action = "broadcast" // Message to linked users by admin
+)
+
+var rolesActions = map[Role][]Action{
+	RoleViewer:     {},
+	RoleRep:        {ActionComment, ActionCompleteTask, ActionUndo},
+	RoleSupervisor: {ActionComment, ActionCompleteTask, ActionUndo, ActionViewTeam},
+	RoleAdmin:      {ActionComment, ActionCompleteTask, ActionUndo, ActionViewTeam, ActionAdmin, ActionRevoke, ActionBroadcast},
+}
+
+func (r Role) Valid() bool {
+	return slices.Contains(Roles, r)
+}
+
+// Checks if role allows the action
+func (r Role) Can(a Action) bool {
+	return slices.Contains(rolesActions[r], a)
+}
+
+// Returns the highest of two roles
+func (r Role) Max(o Role) Role {
+	if slices.Index(Roles, o) > slices.Index(Roles, r) {
+		return o
+	}
+	return r
+}
+
+// Returns localized role name
+func RoleText(tr Localizer, r Role) string {
+	return tr.Tr("role." + string(r))
+}
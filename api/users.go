@@ -1,11 +1,72 @@
 package api
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // Connects telegram id with BX id so I do not have to request contact every time
+// One telegram user could link several bitrix accounts(users or portals), the bot works with the active one
 type UsersIdStore interface {
-	Set(tgId int64, bxId int64)   // Stores bxId for tgId
-	Get(tgId int64) (int64, bool) // Similar to map field existance check: first - value, second - does the value exists
-	Save() error                  // Temp function because I do not catch interupt signal yet...
+	Set(tgId int64, acc BxAccount)         // Links account(if it is not linked yet) and makes it active
+	Get(tgId int64) (BxAccount, bool)      // Active account of the user, second - does the user exist
+	Accounts(tgId int64) []BxAccount       // All linked accounts of the user
+	GetByBxId(acc BxAccount) []int64       // Reverse lookup - telegram users that have linked the account(not only as active one)
+	Switch(tgId int64, acc BxAccount) bool // Makes linked account active, false if it is not linked
+	Unlink(tgId int64, acc BxAccount) bool // Unlinks one account, user is deleted with the last one. False if it was not linked
+	Touch(tgId int64, username string)     // Updates last seen time and username of linked user, clears blocked mark
+	MarkBlocked(tgId int64)                // Marks that user blocked the bot - messages are not sent to him until his next visit
+	Delete(tgId int64) bool                // Unlinks all accounts of user, returns false if user was not linked
+	List() []LinkedUser                    // All linked users - recently seen first
+	Each(fn func(u LinkedUser) bool)       // Iterates users in no particular order until fn returns false, fn could use the store
+	Save() error                           // Temp function because I do not catch interupt signal yet...
+	io.Closer
+}
+
+// Bitrix account - user of a portal
+type BxAccount struct {
+	Portal string `json:"portal,omitempty"` // Empty is the default portal
+	BxId   int64  `json:"bxId"`
+}
+
+// Linked telegram and bitrix accounts
+type LinkedUser struct {
+	TgId      int64       `json:"-"` // Is the key of the store
+	BxAccount             // Active account - fields are inline so old files with only bxId are read as is
+	Accounts  []BxAccount `json:"accounts,omitempty"` // All linked accounts including active one
+	Username  string      `json:"username,omitempty"` // Telegram username at the last visit
+	LastSeen  time.Time   `json:"lastSeen"`           // Zero if user was not seen since linking
+	Blocked   bool        `json:"blocked,omitempty"`  // User blocked the bot - is found out on sending
+}
+
+// Per user preferences - they belong to telegram user and apply to the active account of any portal
+// Data of one tenant is keyed by portal, so it does not leak to accounts of other portals
+type UserSettings struct {
+	Lang     string               `json:"lang"`               // Empty means language of telegram client
+	TimeZone string               `json:"timeZone,omitempty"` // IANA name or UTC offset like +03:00, empty means bot's default zone
+	Digest   DigestSettings       `json:"digest"`
+	Remind   RemindSettings       `json:"remind"`
+	Revoked  map[string]time.Time `json:"revoked,omitempty"` // Portal -> until when its accounts could not be linked again, is set by admin's revoke
+}
+
+// Daily digest of tasks - zero value means digest at default time
+type DigestSettings struct {
+	Off      bool   `json:"off,omitempty"`
+	Time     string `json:"time,omitempty"`     // HH:MM in user's time zone, empty means default time
+	LastSent string `json:"lastSent,omitempty"` // Date(YYYY-MM-DD) of the last digest in user's time zone - digest is sent once a day even after restart
+}
+
+// Reminders before task deadlines - zero value means reminders with default lead time
+type RemindSettings struct {
+	Off    bool   `json:"off,omitempty"`
+	Before string `json:"before,omitempty"` // Go duration like 30m, empty means default lead time
+}
+
+// Stores users preferences by telegram id
+type UserSettingsStore interface {
+	Set(tgId int64, settings UserSettings)                           // Stores settings for tgId
+	Get(tgId int64) UserSettings                                     // Returns zero value if user has no settings
+	Update(tgId int64, fn func(settings *UserSettings)) UserSettings // Changes settings under store lock so concurrent changes are not lost, returns new settings
+	Save() error
 	io.Closer
 }
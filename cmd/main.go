@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"strings"
 
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Users' time zones do not depend on the system
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/audit"
 	"github.com/CGSG-2021-AE4/tomestobot/internal/bot"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
 	"github.com/CGSG-2021-AE4/tomestobot/internal/bx"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/i18n"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/jobs"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/log"
 )
 
@@ -24,9 +34,71 @@ func main() {
 
 func mainRun() error {
 	// Parse env variable
-	userId, err := strconv.Atoi(os.Getenv("BX_USER_ID"))
-	if err != nil {
-		return fmt.Errorf("invalid user id env variable: %w", err)
+	// Default portal is configured if BX_DOMAIN is set or there are no named portals
+	portalNames := strings.Fields(os.Getenv("BX_PORTALS"))
+	if os.Getenv("BX_DOMAIN") != "" || len(portalNames) == 0 {
+		portalNames = append([]string{""}, portalNames...)
+	}
+	phoneRegion := os.Getenv("PHONE_REGION")
+	if phoneRegion == "" {
+		phoneRegion = "RU"
+	}
+	portalDescrs := []bx.PortalDescriptor{}
+	eventsTokens := map[string]string{}
+	adminBxIds := map[string][]int64{}
+	for _, name := range portalNames {
+		userId, err := strconv.Atoi(portalEnv(name, "USER_ID"))
+		if err != nil {
+			return fmt.Errorf("invalid user id env variable of portal %q: %w", name, err)
+		}
+		region := phoneRegion
+		if name != "" && portalEnv(name, "PHONE_REGION") != "" {
+			region = portalEnv(name, "PHONE_REGION")
+		}
+		portalDescrs = append(portalDescrs, bx.PortalDescriptor{
+			Name: name,
+			BxDescriptor: bx.BxDescriptor{
+				BxDomain: portalEnv(name, "DOMAIN"),
+				BxUserId: userId,
+				BxHook:   portalEnv(name, "HOOK"),
+
+				PhoneRegion: region,
+			},
+		})
+		eventsTokens[name] = portalEnv(name, "APP_TOKEN")
+		for _, str := range strings.Fields(portalEnv(name, "ADMIN_IDS")) {
+			id, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid admin bx id env variable of portal %q: %w", name, err)
+			}
+			adminBxIds[name] = append(adminBxIds[name], id)
+		}
+	}
+	digestTime := os.Getenv("DIGEST_TIME")
+	switch digestTime {
+	case "":
+		digestTime = "09:00"
+	case "off":
+		digestTime = ""
+	}
+	var err error
+	defaultZone := time.Local
+	if name := os.Getenv("DEFAULT_TZ"); name != "" {
+		if defaultZone, err = time.LoadLocation(name); err != nil {
+			return fmt.Errorf("invalid default time zone env variable: %w", err)
+		}
+	}
+	remindBefore := time.Hour
+	if str := os.Getenv("REMIND_BEFORE"); str != "" {
+		if remindBefore, err = time.ParseDuration(str); err != nil {
+			return fmt.Errorf("invalid remind before env variable: %w", err)
+		}
+	}
+	undoWindow := 30 * time.Second
+	if str := os.Getenv("UNDO_WINDOW"); str != "" {
+		if undoWindow, err = time.ParseDuration(str); err != nil {
+			return fmt.Errorf("invalid undo window env variable: %w", err)
+		}
 	}
 
 	// Setup logger
@@ -39,24 +111,84 @@ func mainRun() error {
 	logger := slog.New(log.NewHandler(output, logsLevel))
 	slog.SetDefault(logger)
 
-	// Create bx wrapper
+	// Admins are needed for logs and users management
+	if os.Getenv("ADMIN_WHITELIST") != "" {
+		logger.Warn("ADMIN_WHITELIST is not supported anymore - put bitrix ids of admins to ADMIN_BX_IDS")
+	}
+	for _, name := range portalNames {
+		if len(adminBxIds[name]) == 0 && os.Getenv("ROLES_FILE") == "" { // Admins could be set in roles file too
+			logger.Warn("portal has no admins - admin commands are unavailable", "portal", name, "env", portalEnvName(name, "ADMIN_IDS"))
+		}
+	}
+
+	// Load messages - broken or incomplete catalogs must stop the bot
+	catalog, err := i18n.Load(logger.WithGroup("I18N"))
+	if err != nil {
+		return fmt.Errorf("load message catalogs: %w", err)
+	}
+	views, err := screens.Load(os.Getenv("TEMPLATES_DIR"))
+	if err != nil {
+		return fmt.Errorf("load screen templates: %w", err)
+	}
 
-	bxDescr := bx.BxDescriptor{
-		BxDomain: os.Getenv("BX_DOMAIN"),
-		BxUserId: userId,
-		BxHook:   os.Getenv("BX_HOOK"),
+	// Open audit log
+	auditSink := os.Getenv("AUDIT_SINK")
+	if auditSink == "" {
+		auditSink = "jsonl"
+	}
+	auditFile := os.Getenv("AUDIT_FILE")
+	switch {
+	case auditFile != "":
+	case auditSink == "daily":
+		auditFile = "audit"
+	case auditSink == "db":
+		auditFile = "audit.db"
+	default:
+		auditFile = "audit.jsonl"
 	}
-	bx, err := bx.New(logger.WithGroup("BX"), bxDescr)
+	auditLog, err := audit.Open(logger.WithGroup("AUDIT"), auditSink, auditFile)
 	if err != nil {
-		return fmt.Errorf("bx creation")
+		return fmt.Errorf("open audit log: %w", err)
 	}
+	defer auditLog.Close()
+
+	// Open jobs queue
+	jobsFile := os.Getenv("JOBS_FILE")
+	if jobsFile == "" {
+		jobsFile = "jobs.json"
+	}
+	jobs, err := jobs.NewJsonQueue(logger.WithGroup("JOBS"), jobsFile)
+	if err != nil {
+		return fmt.Errorf("open jobs queue: %w", err)
+	}
+	defer jobs.Close()
+
+	// Create bx wrappers
+
+	portals, err := bx.NewPortals(logger.WithGroup("BX"), portalDescrs)
+	if err != nil {
+		return fmt.Errorf("bx creation: %w", err)
+	}
+	defer portals.Close()
 
 	// Create bot
 
 	botDescr := bot.BotDescriptor{
-		TgBotToken:     os.Getenv("TG_TOKEN"),
-		Bx:             bx,
-		AdminWhitelist: strings.Split(os.Getenv("ADMIN_WHITELIST"), " "),
+		TgBotToken: os.Getenv("TG_TOKEN"),
+		Portals:    portals,
+		Catalog:    catalog,
+		Screens:    views,
+		Audit:      auditLog,
+		AdminBxIds: adminBxIds,
+		UndoWindow: undoWindow,
+
+		DigestTime:   digestTime,
+		DefaultZone:  defaultZone,
+		Jobs:         jobs,
+		RemindBefore: remindBefore,
+
+		EventsAddr:   os.Getenv("EVENTS_ADDR"),
+		EventsTokens: eventsTokens,
 	}
 	bot, err := bot.New(logger.WithGroup("TG"), botDescr)
 	if err != nil {
@@ -65,9 +197,45 @@ func mainRun() error {
 
 	// Setup tg logging
 	defferedOutput.Output = bot.GetLogsOutput()
+
+	// Metrics are served after bot creation - it registers sessions gauge
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		srv := metrics.Serve(logger.WithGroup("METRICS"), addr)
+		defer func() { // After bot is stopped
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Warn("shutdown metrics server: " + err.Error())
+			}
+		}()
+	}
+
+	// Bot is stopped on interrupt so deferred closes save the stores
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Info("stopping bot", "signal", sig.String())
+		bot.Stop()
+	}()
 	return bot.Start()
 }
 
+// Reads setting of the portal - BX_<NAME>_<KEY>, the default portal uses BX_<KEY> and ADMIN_BX_IDS
+func portalEnv(name, key string) string {
+	return os.Getenv(portalEnvName(name, key))
+}
+
+func portalEnvName(name, key string) string {
+	if name != "" {
+		return "BX_" + strings.ToUpper(name) + "_" + key
+	}
+	if key == "ADMIN_IDS" {
+		return "ADMIN_BX_IDS"
+	}
+	return "BX_" + key
+}
+
 func bxTest() error {
 	// Creating bitrix wrapper
 	//	userId, err := strconv.Atoi(os.Getenv("BX_USER_ID"))
@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Audit log sinks by name - AUDIT_SINK chooses one of them, path is a file or a dir depending on sink
+// New storages are added here by implementing api.AuditLog
+var sinks = map[string]func(logger *slog.Logger, path string) (api.AuditLog, error){
+	"jsonl": NewJsonlLog,
+	"daily": NewDailyLog,
+	"db":    NewDbLog,
+}
+
+// Opens sink by name
+func Open(logger *slog.Logger, sink, path string) (api.AuditLog, error) {
+	open, ok := sinks[sink]
+	if !ok {
+		names := []string{}
+		for name := range sinks {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown audit sink %q, supported: %s", sink, strings.Join(names, ", "))
+	}
+	return open(logger, path)
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Entries of three days, two per day, with tg id as the order number
+func testEntries() []api.AuditEntry {
+	start := time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC)
+	entries := []api.AuditEntry{}
+	for i := 0; i < 6; i++ {
+		entries = append(entries, api.AuditEntry{
+			Time:   start.Add(time.Duration(i/2)*24*time.Hour + time.Duration(i%2)*time.Hour),
+			TgId:   int64(i + 1),
+			Action: api.ActionComment,
+			Result: api.AuditResultOk,
+		})
+	}
+	return entries
+}
+
+func tgIds(entries []api.AuditEntry) []int64 {
+	ids := []int64{}
+	for _, e := range entries {
+		ids = append(ids, e.TgId)
+	}
+	return ids
+}
+
+func TestSinks(t *testing.T) {
+	day := time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		q    api.AuditQuery
+		want []int64
+	}{
+		{"all from newest", api.AuditQuery{}, []int64{6, 5, 4, 3, 2, 1}},
+		{"limit", api.AuditQuery{Limit: 3}, []int64{6, 5, 4}},
+		{"one day", api.AuditQuery{From: day, To: day.AddDate(0, 0, 1)}, []int64{4, 3}},
+		{"from", api.AuditQuery{From: day}, []int64{6, 5, 4, 3}},
+		{"to with limit", api.AuditQuery{To: day.AddDate(0, 0, 1), Limit: 3}, []int64{4, 3, 2}},
+		{"tg id", api.AuditQuery{TgId: 2}, []int64{2}},
+		{"other portal", api.AuditQuery{Portal: "north"}, []int64{}},
+	}
+	for _, sink := range []string{"jsonl", "daily", "db"} {
+		log, err := Open(slog.Default(), sink, filepath.Join(t.TempDir(), "audit"))
+		if err != nil {
+			t.Fatalf("%s: open: %s", sink, err)
+		}
+		for _, e := range testEntries() {
+			if err := log.Append(e); err != nil {
+				t.Fatalf("%s: append: %s", sink, err)
+			}
+		}
+		for _, tt := range tests {
+			entries, err := log.Query(tt.q)
+			if err != nil {
+				t.Fatalf("%s %s: query: %s", sink, tt.name, err)
+			}
+			if got := tgIds(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("%s %s: got %v, want %v", sink, tt.name, got, tt.want)
+			}
+		}
+		if err := log.Close(); err != nil {
+			t.Errorf("%s: close: %s", sink, err)
+		}
+	}
+}
+
+func TestOpenUnknownSink(t *testing.T) {
+	if _, err := Open(slog.Default(), "sqlite", t.TempDir()); err == nil {
+		t.Error("unknown sink is opened")
+	}
+}
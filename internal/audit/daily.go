@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Audit log partitioned by days - every UTC day is a jsonl file in the dir
+// Query reads days from the newest one and stops at the limit or at the start of the range, so old entries do not slow down admin requests
+
+const dayLayout = "2006-01-02"
+
+type dailyLog struct {
+	logger *slog.Logger
+
+	mutex sync.Mutex
+	dir   string
+	day   string   // Day of the open file
+	file  *os.File // Is opened on append
+}
+
+func NewDailyLog(logger *slog.Logger, dir string) (api.AuditLog, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("create audit dir: %w", err)
+	}
+	return &dailyLog{
+		logger: logger,
+		dir:    dir,
+	}, nil
+}
+
+func (l *dailyLog) Append(e api.AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if day := e.Time.UTC().Format(dayLayout); l.file == nil || day != l.day {
+		if l.file != nil {
+			l.file.Close()
+		}
+		file, err := os.OpenFile(filepath.Join(l.dir, day+".jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			l.file = nil
+			return fmt.Errorf("open audit file: %w", err)
+		}
+		l.file, l.day = file, day
+	}
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	return nil
+}
+
+func (l *dailyLog) Query(q api.AuditQuery) ([]api.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(l.dir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("list audit files: %w", err)
+	}
+	slices.Sort(files) // Names are dates so the order is chronological
+	slices.Reverse(files)
+
+	entries := []api.AuditEntry{}
+	for _, f := range files {
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(filepath.Base(f), ".jsonl"))
+		if err != nil { // Not a day file
+			continue
+		}
+		if !q.To.IsZero() && !day.Before(q.To) { // The whole day is after the range
+			continue
+		}
+		if !q.From.IsZero() && !day.AddDate(0, 0, 1).After(q.From) { // This and older days are before the range
+			break
+		}
+		dayEntries, err := readEntries(l.logger, f, q)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(dayEntries)
+		entries = append(entries, dayEntries...)
+		if q.Limit > 0 && len(entries) >= q.Limit {
+			return entries[:q.Limit], nil
+		}
+	}
+	return entries, nil
+}
+
+func (l *dailyLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
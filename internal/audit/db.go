@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Embedded audit database - one file with checksummed records and indexes
+// Record is payload length and its crc32(4 bytes each, little endian) followed by json of the entry, records are only appended
+// Indexes by tg id, bx id, username, entity and day are built on open and updated on append,
+// so query reads from disk only entries of the most selective index instead of scanning the whole log
+// Torn record at the end(crash during write) is cut off on open
+
+const dbMagic = "TOMESTOBOT-AUDIT-1\n" // File header - other files are not opened as database
+
+const (
+	dbHeaderSize    = 8
+	dbMaxRecordSize = 1024 * 1024
+	dbIndexDays     = 31 // Longer ranges are filtered by time of records without day index
+)
+
+// Entry position in the file, time and portal are kept to filter without reading
+type dbRecord struct {
+	offset int64 // Of json
+	size   int
+	time   time.Time
+	portal string
+}
+
+type dbLog struct {
+	logger *slog.Logger
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64 // End of the last record
+
+	records []dbRecord // In append order, indexes contain their numbers ascending
+	tgIds   map[int64][]int
+	bxIds   map[int64][]int
+	users   map[string][]int // Usernames in lower case
+	entity  map[string][]int
+	days    map[string][]int // UTC days
+}
+
+func NewDbLog(logger *slog.Logger, filename string) (api.AuditLog, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open audit database: %w", err)
+	}
+	l := &dbLog{
+		logger: logger,
+		file:   file,
+		tgIds:  map[int64][]int{},
+		bxIds:  map[int64][]int{},
+		users:  map[string][]int{},
+		entity: map[string][]int{},
+		days:   map[string][]int{},
+	}
+	if err := l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reads all records to build indexes, cuts torn tail
+func (l *dbLog) load() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat audit database: %w", err)
+	}
+	if info.Size() == 0 {
+		if _, err := l.file.WriteAt([]byte(dbMagic), 0); err != nil {
+			return fmt.Errorf("write audit database header: %w", err)
+		}
+		l.size = int64(len(dbMagic))
+		return nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(l.file, 0, info.Size()))
+	magic := make([]byte, len(dbMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dbMagic {
+		return fmt.Errorf("%s is not an audit database", l.file.Name())
+	}
+	l.size = int64(len(dbMagic))
+	header := make([]byte, dbHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) { // Clean end
+				return nil
+			}
+			return l.cut(info.Size(), err)
+		}
+		size := binary.LittleEndian.Uint32(header)
+		if size > dbMaxRecordSize {
+			return l.cut(info.Size(), fmt.Errorf("record size %d", size))
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return l.cut(info.Size(), err)
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+			return l.cut(info.Size(), errors.New("checksum mismatch"))
+		}
+
+		offset := l.size + dbHeaderSize
+		l.size = offset + int64(size)
+		e := api.AuditEntry{}
+		if err := json.Unmarshal(data, &e); err != nil { // Record is whole, so only this entry is lost
+			l.logger.Warn("skip broken audit entry", "offset", offset, "err", err.Error())
+			continue
+		}
+		l.index(offset, len(data), e)
+	}
+}
+
+// Drops records after the last whole one - they were not written completely
+func (l *dbLog) cut(fileSize int64, reason error) error {
+	l.logger.Warn("cut torn audit database tail", "offset", l.size, "bytes", fileSize-l.size, "reason", reason.Error())
+	if err := l.file.Truncate(l.size); err != nil {
+		return fmt.Errorf("cut audit database tail: %w", err)
+	}
+	return nil
+}
+
+// Adds record to indexes, mutex must be locked
+func (l *dbLog) index(offset int64, size int, e api.AuditEntry) {
+	n := len(l.records)
+	l.records = append(l.records, dbRecord{offset: offset, size: size, time: e.Time, portal: e.Portal})
+	l.tgIds[e.TgId] = append(l.tgIds[e.TgId], n)
+	l.bxIds[e.BxId] = append(l.bxIds[e.BxId], n)
+	if e.Username != "" {
+		user := strings.ToLower(e.Username)
+		l.users[user] = append(l.users[user], n)
+	}
+	l.entity[e.Entity] = append(l.entity[e.Entity], n)
+	day := e.Time.UTC().Format(dayLayout)
+	l.days[day] = append(l.days[day], n)
+}
+
+func (l *dbLog) Append(e api.AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	record := make([]byte, dbHeaderSize, dbHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	record = append(record, data...)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// Failed write is overwritten by the next one because size is not moved
+	if _, err := l.file.WriteAt(record, l.size); err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	l.index(l.size+dbHeaderSize, len(data), e)
+	l.size += int64(len(record))
+	return nil
+}
+
+func (l *dbLog) Query(q api.AuditQuery) ([]api.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	candidates, all := l.candidates(q)
+	count := len(candidates)
+	if all {
+		count = len(l.records)
+	}
+	entries := []api.AuditEntry{}
+	for i := count - 1; i >= 0 && (q.Limit <= 0 || len(entries) < q.Limit); i-- {
+		n := i
+		if !all {
+			n = candidates[i]
+		}
+		r := l.records[n]
+		if r.portal != q.Portal || (!q.From.IsZero() && r.time.Before(q.From)) || (!q.To.IsZero() && !r.time.Before(q.To)) {
+			continue
+		}
+		e, err := l.read(r)
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Returns record numbers of the smallest index that matches the query, all is true if no index fits
+func (l *dbLog) candidates(q api.AuditQuery) (candidates []int, all bool) {
+	lists := [][]int{}
+	if q.TgId != 0 {
+		lists = append(lists, l.tgIds[q.TgId])
+	}
+	if q.BxId != 0 {
+		lists = append(lists, l.bxIds[q.BxId])
+	}
+	if q.Username != "" {
+		lists = append(lists, l.users[strings.ToLower(q.Username)])
+	}
+	if q.Entity != "" {
+		lists = append(lists, l.entity[q.Entity])
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Sub(q.From) <= dbIndexDays*24*time.Hour {
+		days := []int{}
+		for day := q.From.UTC().Truncate(24 * time.Hour); day.Before(q.To); day = day.AddDate(0, 0, 1) {
+			days = append(days, l.days[day.Format(dayLayout)]...)
+		}
+		slices.Sort(days) // Entries of one day could be appended after entries of the next one
+		lists = append(lists, days)
+	}
+	if len(lists) == 0 {
+		return nil, true
+	}
+	return slices.MinFunc(lists, func(a, b []int) int { return len(a) - len(b) }), false
+}
+
+// Reads entry of the record, mutex must be locked
+func (l *dbLog) read(r dbRecord) (api.AuditEntry, error) {
+	data := make([]byte, r.size)
+	if _, err := l.file.ReadAt(data, r.offset); err != nil {
+		return api.AuditEntry{}, fmt.Errorf("read audit entry: %w", err)
+	}
+	e := api.AuditEntry{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return api.AuditEntry{}, fmt.Errorf("parse audit entry: %w", err)
+	}
+	return e, nil
+}
+
+func (l *dbLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
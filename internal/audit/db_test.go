@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Entries of two users about two deals during 40 days, tg id is the order number
+func dbTestEntries() []api.AuditEntry {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	entries := []api.AuditEntry{}
+	for i := 0; i < 40; i++ {
+		e := api.AuditEntry{
+			Time:     start.AddDate(0, 0, i),
+			TgId:     int64(i + 1),
+			Username: "Ivan",
+			BxId:     7,
+			Action:   api.ActionComment,
+			Entity:   "deal:1",
+			Result:   api.AuditResultOk,
+		}
+		if i%2 == 1 {
+			e.Username, e.BxId, e.Entity = "Petr", 8, "deal:2"
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func openDb(t *testing.T, filename string) api.AuditLog {
+	log, err := NewDbLog(slog.Default(), filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestDbIndexes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.db")
+	log := openDb(t, filename)
+	for _, e := range dbTestEntries() {
+		if err := log.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name string
+		q    api.AuditQuery
+		want []int64
+	}{
+		{"entity", api.AuditQuery{Entity: "deal:2", Limit: 3}, []int64{40, 38, 36}},
+		{"bx id", api.AuditQuery{BxId: 7, Limit: 2}, []int64{39, 37}},
+		{"username", api.AuditQuery{Username: "petr", Limit: 1}, []int64{40}},
+		{"indexed days", api.AuditQuery{From: day(3), To: day(6)}, []int64{5, 4, 3}},
+		{"days and entity", api.AuditQuery{Entity: "deal:1", From: day(3), To: day(6)}, []int64{5, 3}},
+		{"long range", api.AuditQuery{From: day(2), To: day(2).AddDate(0, 2, 0), Limit: 2}, []int64{40, 39}},
+		{"from only", api.AuditQuery{From: time.Date(2024, 6, 7, 0, 0, 0, 0, time.UTC)}, []int64{40, 39, 38}},
+		{"tg id", api.AuditQuery{TgId: 12}, []int64{12}},
+		{"unknown entity", api.AuditQuery{Entity: "task:1"}, []int64{}},
+		{"other portal", api.AuditQuery{Portal: "north", BxId: 7}, []int64{}},
+	}
+	check := func(log api.AuditLog, stage string) {
+		for _, tt := range tests {
+			entries, err := log.Query(tt.q)
+			if err != nil {
+				t.Fatalf("%s %s: %s", stage, tt.name, err)
+			}
+			if got := tgIds(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("%s %s: got %v, want %v", stage, tt.name, got, tt.want)
+			}
+		}
+	}
+	check(log, "appended")
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Indexes are rebuilt from the file
+	log = openDb(t, filename)
+	defer log.Close()
+	check(log, "reopened")
+}
+
+func TestDbTornTail(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.db")
+	log := openDb(t, filename)
+	entries := dbTestEntries()[:3]
+	for _, e := range entries {
+		if err := log.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// Crash in the middle of the last record
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filename, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	log = openDb(t, filename)
+	defer log.Close()
+	if err := log.Append(dbTestEntries()[3]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := log.Query(api.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := tgIds(got); !slices.Equal(ids, []int64{4, 2, 1}) {
+		t.Errorf("after torn record got %v, want [4 2 1]", ids)
+	}
+}
+
+func TestDbForeignFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(filename, []byte(`{"tgId":1}`+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDbLog(slog.Default(), filename); err == nil {
+		t.Error("jsonl file is opened as database")
+	}
+}
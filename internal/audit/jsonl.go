@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// JSONL implementation of audit log
+// Every entry is a json line appended to the file, the file is never rewritten
+// Query scans the whole file - it is fine for admin requests, long logs should use daily sink
+
+type jsonlLog struct {
+	logger *slog.Logger
+
+	mutex    sync.Mutex
+	filename string
+	file     *os.File
+}
+
+func NewJsonlLog(logger *slog.Logger, filename string) (api.AuditLog, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &jsonlLog{
+		logger:   logger,
+		filename: filename,
+		file:     file,
+	}, nil
+}
+
+func (l *jsonlLog) Append(e api.AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	return nil
+}
+
+func (l *jsonlLog) Query(q api.AuditQuery) ([]api.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries, err := readEntries(l.logger, l.filename, q)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// Reads matching entries of jsonl file from oldest to newest, only the latest q.Limit ones are kept
+func readEntries(logger *slog.Logger, filename string, q api.AuditQuery) ([]api.AuditEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	defer file.Close()
+
+	entries := []api.AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := api.AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logger.Warn("skip broken audit entry", "err", err.Error())
+			continue
+		}
+		if !q.Match(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if q.Limit > 0 && len(entries) > q.Limit { // Keep only the latest ones
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit file: %w", err)
+	}
+	return entries, nil
+}
+
+func (l *jsonlLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Switcher of linked bitrix accounts
+// Another account is linked by sharing contact once more(it finds accounts only in portals without linked ones)
+// or by login code(/login) - it is the only way to link another account of the same portal, the new account becomes active
+// Deep link /start <portal> switches to account of the portal or links it
+// Switching restarts the session because it is bound to one bitrix user
+
+// Account buttons - payload is "<portal>:<bx id>"
+var (
+	switchAccountBtn = tele.Btn{Unique: "switchAccount"}
+	unlinkAccountBtn = tele.Btn{Unique: "unlinkAccount"}
+	linkAccountBtn   = tele.Btn{Unique: "linkAccount"}
+)
+
+func accountPayload(acc api.BxAccount) string {
+	return acc.Portal + ":" + strconv.FormatInt(acc.BxId, 10)
+}
+
+func parseAccountPayload(data string) (api.BxAccount, bool) {
+	portal, id, ok := strings.Cut(data, ":")
+	if !ok {
+		return api.BxAccount{}, false
+	}
+	bxId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return api.BxAccount{}, false
+	}
+	return api.BxAccount{Portal: portal, BxId: bxId}, true
+}
+
+// Resets session, deep link switches to account of its portal
+func (b *bot) onStart(c tele.Context) error {
+	// If user reached this endpoint - session exists
+	tgId := c.Sender().ID
+	portal, ok := b.deepLinkPortal(c)
+	if active, _ := b.idStore.Get(tgId); !ok || active.Portal == portal {
+		return b.sessions.Get(tgId).OnStart(c)
+	}
+	for _, acc := range b.idStore.Accounts(tgId) {
+		if acc.Portal == portal && b.idStore.Switch(tgId, acc) {
+			if err := b.idStore.Save(); err != nil {
+				b.logger.Warn(err.Error())
+			}
+			return b.restartSession(c, b.tr(c).Tr("accounts.switched", "name", html.EscapeString(b.accountName(acc))))
+		}
+	}
+	// Account of the portal is not linked yet
+	b.setLinking(tgId, true)
+	b.setStartPortal(tgId, portal)
+	return b.reqContact(c)
+}
+
+// Portal from /start parameter
+func (b *bot) deepLinkPortal(c tele.Context) (string, bool) {
+	msg := c.Message()
+	if msg == nil || msg.Payload == "" || !strings.HasPrefix(msg.Text, "/start") {
+		return "", false
+	}
+	if _, ok := b.portals.Get(msg.Payload); !ok {
+		b.logger.Debug("deep link to unknown portal", "portal", msg.Payload)
+		return "", false
+	}
+	return msg.Payload, true
+}
+
+// Lists linked accounts
+func (b *bot) onAccounts(c tele.Context) error {
+	text, menu := b.accountsScreen(c)
+	return c.Send(text, menu)
+}
+
+// Makes the account active and restarts session with it
+func (b *bot) onSwitchAccount(c tele.Context) error {
+	tr := b.tr(c)
+	acc, ok := parseAccountPayload(c.Data())
+	if !ok || !b.idStore.Switch(c.Sender().ID, acc) {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	if err := b.idStore.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	b.logger.Info("account switched", "tgId", c.Sender().ID, "bxId", acc.BxId, "portal", acc.Portal)
+	return b.restartSession(c, tr.Tr("accounts.switched", "name", html.EscapeString(b.accountName(acc))))
+}
+
+// Unlinks the account, the first remaining one becomes active
+func (b *bot) onUnlinkAccount(c tele.Context) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	acc, ok := parseAccountPayload(c.Data())
+	if !ok {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	active, _ := b.idStore.Get(tgId)
+	if !b.idStore.Unlink(tgId, acc) {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	if err := b.idStore.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	b.logger.Info("account unlinked", "tgId", tgId, "bxId", acc.BxId, "portal", acc.Portal)
+	if !slices.ContainsFunc(b.idStore.Accounts(tgId), func(left api.BxAccount) bool { return left.Portal == acc.Portal }) {
+		b.dropJobs(tgId, acc.Portal)
+	}
+
+	if _, linked := b.idStore.Get(tgId); !linked { // It was the last one - user has to auth again
+		b.sessions.Stop(tgId)
+		return c.Edit(tr.Tr("accounts.unlinkedLast"), &tele.ReplyMarkup{})
+	}
+	if acc == active {
+		return b.restartSession(c, tr.Tr("accounts.unlinked"))
+	}
+	text, menu := b.accountsScreen(c)
+	return c.Edit(text, menu)
+}
+
+// Requests contact or login of another account
+func (b *bot) onLinkAccount(c tele.Context) error {
+	b.setLinking(c.Sender().ID, true)
+	return b.reqContact(c)
+}
+
+// Starts code login of another account
+func (b *bot) onLinkByCode(c tele.Context) error {
+	b.setLinking(c.Sender().ID, true)
+	_, err := b.handleCodeLogin(c)
+	return err
+}
+
+// Links account by shared contact - is called by OnContact when user has session
+func (b *bot) linkAccount(c tele.Context) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	b.setLinking(tgId, false)
+	b.clearContactRequest(c)
+
+	// Contact of somebody else would give access to his account
+	if contact := c.Message().Contact; contact != nil && contact.UserID != tgId {
+		return c.Send(tr.Tr("accounts.foreignContact"))
+	}
+
+	// Found accounts are new ones - portals of linked accounts are skipped
+	found, err := b.tryAuthByPhone(c)
+	if err != nil {
+		footer, str := api.ErrorText(tr, err)
+		b.logger.Warn(str, "username", c.Sender().Username)
+		if errors.Is(err, api.ErrorUserNotFound) {
+			str += "\n" + tr.Tr("accounts.linkByCode")
+		} else if footer {
+			str += tr.Tr("common.restartFooter")
+		}
+		return c.Send(str, &tele.ReplyMarkup{RemoveKeyboard: true})
+	}
+
+	// Phone could be found in several portals - the first account becomes active
+	names := b.accountNames(found)
+	list := make([]string, 0, len(found))
+	for _, acc := range found {
+		list = append(list, html.EscapeString(names[acc]))
+	}
+	return b.restartSession(c, tr.Tr("accounts.linked", "names", strings.Join(list, ", "), "active", list[0]))
+}
+
+// Links account whose login code is entered - the account becomes active
+func (b *bot) linkAccountByCode(c tele.Context, acc api.BxAccount) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	b.setLinking(tgId, false)
+	if slices.Contains(b.idStore.Accounts(tgId), acc) {
+		b.idStore.Switch(tgId, acc)
+		return b.restartSession(c, tr.Tr("accounts.alreadyLinked"))
+	}
+	b.idStore.Set(tgId, acc)
+	if err := b.idStore.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	b.logger.Info("account linked by code", "tgId", tgId, "portal", acc.Portal, "bxId", acc.BxId)
+	name := html.EscapeString(b.accountName(acc))
+	return b.restartSession(c, tr.Tr("accounts.linked", "names", name, "active", name))
+}
+
+func (b *bot) setLinking(tgId int64, linking bool) {
+	b.linkMutex.Lock()
+	defer b.linkMutex.Unlock()
+	if linking {
+		b.linking[tgId] = true
+	} else {
+		delete(b.linking, tgId)
+	}
+}
+
+func (b *bot) isLinking(tgId int64) bool {
+	b.linkMutex.Lock()
+	defer b.linkMutex.Unlock()
+	return b.linking[tgId]
+}
+
+// Empty portal clears it - the default portal is not set by deep link
+func (b *bot) setStartPortal(tgId int64, portal string) {
+	b.linkMutex.Lock()
+	defer b.linkMutex.Unlock()
+	if portal != "" {
+		b.startPortals[tgId] = portal
+	} else {
+		delete(b.startPortals, tgId)
+	}
+}
+
+func (b *bot) startPortal(tgId int64) (string, bool) {
+	b.linkMutex.Lock()
+	defer b.linkMutex.Unlock()
+	portal, ok := b.startPortals[tgId]
+	return portal, ok
+}
+
+// Stops session of the user and starts a new one with the active account
+// Notice replaces accounts screen if it is called by button
+func (b *bot) restartSession(c tele.Context, notice string) error {
+	tgId := c.Sender().ID
+	if b.sessions.Exist(tgId) {
+		b.sessions.Stop(tgId)
+	}
+	if _, err := b.tryAuthById(c, metrics.AuthById); err != nil {
+		return b.sendError(c, fmt.Errorf("auth by id: %w", err))
+	}
+	var err error
+	if c.Callback() != nil {
+		err = c.Edit(notice, &tele.ReplyMarkup{})
+	} else { // Contact keyboard could be left after linking
+		err = c.Send(notice, &tele.ReplyMarkup{RemoveKeyboard: true})
+	}
+	if err != nil {
+		return err
+	}
+	return b.sessions.Get(tgId).OnStart(c)
+}
+
+func (b *bot) accountsScreen(c tele.Context) (string, *tele.ReplyMarkup) {
+	tr := b.tr(c)
+	active, _ := b.idStore.Get(c.Sender().ID)
+	accounts := b.idStore.Accounts(c.Sender().ID)
+	names := b.accountNames(accounts)
+
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{}
+	for _, acc := range accounts {
+		name := names[acc]
+		if acc == active {
+			name = "✓ " + name
+		}
+		row := menu.Row(menu.Data(name, switchAccountBtn.Unique, accountPayload(acc)))
+		if len(accounts) > 1 { // The only account is unlinked by /revoke of admin
+			row = append(row, menu.Data("✕", unlinkAccountBtn.Unique, accountPayload(acc)))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, menu.Row(menu.Data(tr.Tr("accounts.linkBtn"), linkAccountBtn.Unique)))
+	menu.Inline(rows...)
+	return tr.Tr("accounts.title"), menu
+}
+
+// Name of the account user with portal, bitrix id if user could not be loaded
+func (b *bot) accountName(acc api.BxAccount) string {
+	return b.accountNames([]api.BxAccount{acc})[acc]
+}
+
+// Names of the accounts, users are requested by one batch per portal
+func (b *bot) accountNames(accounts []api.BxAccount) map[api.BxAccount]string {
+	ids := map[string][]bxtypes.Id{}
+	for _, acc := range accounts {
+		ids[acc.Portal] = append(ids[acc.Portal], bxtypes.Id(acc.BxId))
+	}
+	users := map[api.BxAccount]string{}
+	for portal, portalIds := range ids {
+		bx, ok := b.portals.Get(portal)
+		if !ok { // Portal was removed from config
+			continue
+		}
+		found, err := bx.GetUsers(portalIds)
+		if err != nil {
+			b.logger.Warn("get account users", "portal", portal, "err", err.Error())
+			continue
+		}
+		for _, u := range found {
+			users[api.BxAccount{Portal: portal, BxId: int64(u.Id)}] = strings.Join(strings.Fields(u.Name+" "+u.LastName), " ")
+		}
+	}
+
+	names := map[api.BxAccount]string{}
+	for _, acc := range accounts {
+		name := users[acc]
+		if name == "" {
+			name = strconv.FormatInt(acc.BxId, 10)
+		}
+		if acc.Portal != "" {
+			name += " (" + acc.Portal + ")"
+		}
+		names[acc] = name
+	}
+	return names
+}
@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Admin commands for linked users management
+// Admin manages only users of his portal(portal of his active account) and sees only their accounts of this portal
+
+const usersPageSize = 10
+
+// Revoked user could not link accounts of the portal again for this time
+const revokeBan = 30 * 24 * time.Hour
+
+// Page switch button of users list - payload is page index
+var usersPageBtn = tele.Btn{Unique: "usersPage"}
+
+// Lists linked users
+func (b *bot) onUsers(c tele.Context) error {
+	text, menu, err := b.usersPage(c, 0)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Send(text, menu)
+}
+
+// Handles page switch buttons
+func (b *bot) onUsersPage(c tele.Context) error {
+	page, err := strconv.Atoi(c.Data())
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: b.tr(c).Tr("common.btnExpired")})
+	}
+	text, menu, err := b.usersPage(c, page)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Edit(text, menu)
+}
+
+// Renders page of users list
+func (b *bot) usersPage(c tele.Context, page int) (string, *tele.ReplyMarkup, error) {
+	tr := b.tr(c)
+	portal := b.adminPortal(c)
+	users := []api.LinkedUser{}
+	for _, u := range b.idStore.List() {
+		if u, ok := scopeUser(u, portal); ok {
+			users = append(users, u)
+		}
+	}
+	menu := &tele.ReplyMarkup{}
+	if len(users) == 0 {
+		return tr.Tr("admin.usersEmpty"), menu, nil
+	}
+
+	pages := (len(users) + usersPageSize - 1) / usersPageSize
+	page = min(max(page, 0), pages-1) // List could shrink since the buttons were sent
+	from := page * usersPageSize
+	to := min(from+usersPageSize, len(users))
+
+	text, err := b.views.Render(tr, screens.Users, screens.UsersView{
+		Count: len(users),
+		Page:  page + 1,
+		Pages: pages,
+		Users: users[from:to],
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	row := tele.Row{}
+	if page > 0 {
+		row = append(row, menu.Data(tr.Tr("admin.prevBtn"), usersPageBtn.Unique, strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		row = append(row, menu.Data(tr.Tr("admin.nextBtn"), usersPageBtn.Unique, strconv.Itoa(page+1)))
+	}
+	if len(row) > 0 {
+		menu.Inline(row)
+	}
+	return text, menu, nil
+}
+
+// Unlinks user's accounts of admin's portal and stops his session if it works with one of them
+func (b *bot) onRevoke(c tele.Context) error {
+	tr := b.tr(c)
+	who := c.Message().Payload
+	if who == "" {
+		return c.Send(tr.Tr("admin.revokeUsage"))
+	}
+	portal := b.adminPortal(c)
+	u, ok := b.findLinkedUser(who, portal)
+	if !ok {
+		return c.Send(tr.Tr("admin.userNotFound", "who", html.EscapeString(who)))
+	}
+
+	active, _ := b.idStore.Get(u.TgId)
+	for _, acc := range u.Accounts {
+		b.idStore.Unlink(u.TgId, acc)
+	}
+	err := b.idStore.Save()
+	b.record(c, api.ActionRevoke, tgUserEntity(u.TgId), api.AuditResult(err))
+	if err != nil {
+		b.logger.Warn(err.Error())
+	}
+	b.banRevoked(u.TgId, portal, time.Now())
+	b.dropJobs(u.TgId, portal)
+	if active.Portal == portal && b.sessions.Exist(u.TgId) { // Next message starts session with other account if it is left
+		b.sessions.Stop(u.TgId)
+	}
+	b.logger.Info("user revoked", "tgId", u.TgId, "bxId", u.BxId, "portal", portal, "by", c.Sender().ID)
+
+	// Notify the user - he could have blocked the bot so error is not critical
+	userTr := b.catalog.Localizer(b.settings.Get(u.TgId).Lang)
+	if _, err := b.bot.Send(&tele.User{ID: u.TgId}, userTr.Tr("admin.revokedNotice")); err != nil {
+		b.logger.Debug("send revoke notice", "tgId", u.TgId, "err", err.Error())
+	}
+	return c.Send(tr.Tr("admin.revoked", "who", html.EscapeString(who)))
+}
+
+// Forbids linking accounts of the portal again - otherwise user would share contact right after revoke
+func (b *bot) banRevoked(tgId int64, portal string, now time.Time) {
+	b.settings.Update(tgId, func(settings *api.UserSettings) {
+		if settings.Revoked == nil {
+			settings.Revoked = map[string]time.Time{}
+		}
+		settings.Revoked[portal] = now.Add(revokeBan)
+	})
+	b.settings.Save()
+}
+
+// Drops portals that user was revoked from
+func (b *bot) notRevoked(tgId int64, portals []string, now time.Time) []string {
+	revoked := b.settings.Get(tgId).Revoked
+	allowed := []string{}
+	for _, portal := range portals {
+		if until, ok := revoked[portal]; !ok || now.After(until) {
+			allowed = append(allowed, portal)
+		}
+	}
+	return allowed
+}
+
+// Shows bitrix profile and session of the user
+func (b *bot) onWhois(c tele.Context) error {
+	tr := b.tr(c)
+	who := c.Message().Payload
+	if who == "" {
+		return c.Send(tr.Tr("admin.whoisUsage"))
+	}
+	portal := b.adminPortal(c)
+	u, ok := b.findLinkedUser(who, portal)
+	if !ok {
+		return c.Send(tr.Tr("admin.userNotFound", "who", html.EscapeString(who)))
+	}
+
+	view := screens.WhoisView{Linked: u}
+	role := api.RoleViewer // Unknown role is the lowest one
+	if bxUser, err := b.authAccount(u.BxAccount); err != nil {
+		b.logger.Warn("whois load bx user", "bxId", u.BxId, "err", err.Error())
+	} else {
+		user := bxUser.Get()
+		view.User = &user
+		role = b.roles.of(portal).resolve(user)
+		bxUser.Close()
+	}
+	// Session works with active account - it is shown only if the account is of admin's portal
+	if active, _ := b.idStore.Get(u.TgId); active.Portal == portal && b.sessions.Exist(u.TgId) { // Session keeps role that was resolved on auth
+		status := b.sessions.Get(u.TgId).Status()
+		view.Session = &status
+		role = b.sessions.Get(u.TgId).Role()
+	}
+	view.Role = api.RoleText(tr, role)
+
+	text, err := b.views.Render(tr, screens.Whois, view)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Send(text)
+}
+
+// Finds user of the portal by telegram id or username(with or without @)
+func (b *bot) findLinkedUser(who, portal string) (api.LinkedUser, bool) {
+	tgId, idErr := strconv.ParseInt(who, 10, 64)
+	username := strings.TrimPrefix(who, "@")
+	for _, u := range b.idStore.List() {
+		if (idErr == nil && u.TgId == tgId) || (u.Username != "" && strings.EqualFold(u.Username, username)) {
+			return scopeUser(u, portal)
+		}
+	}
+	return api.LinkedUser{}, false
+}
+
+// Leaves only accounts of the portal, the active one is replaced with account of the portal
+// False if user has no accounts of the portal
+func scopeUser(u api.LinkedUser, portal string) (api.LinkedUser, bool) {
+	accounts := []api.BxAccount{}
+	for _, acc := range u.Accounts {
+		if acc.Portal == portal {
+			accounts = append(accounts, acc)
+		}
+	}
+	if len(accounts) == 0 {
+		return u, false
+	}
+	if u.Portal != portal {
+		u.BxAccount = accounts[0]
+	}
+	u.Accounts = accounts
+	return u, true
+}
+
+// Portal of the admin - portal of his active account
+func (b *bot) adminPortal(c tele.Context) string {
+	acc, _ := b.idStore.Get(c.Sender().ID)
+	return acc.Portal
+}
+
+// Sends error to admin
+func (b *bot) sendError(c tele.Context, err error) error {
+	_, str := api.ErrorText(b.tr(c), err)
+	b.logger.Warn(str, "username", c.Sender().Username)
+	return c.Send(str)
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Audit log of bot actions and its admin command
+
+const (
+	auditLimit        = 20   // Entries per /audit request
+	auditMessageLimit = 3500 // Telegram allows 4096 chars per message - entries are split to several messages
+	auditResultLimit  = 300  // Longer results(error texts) are cut
+)
+
+// Writes bot level action to audit log
+func (b *bot) record(c tele.Context, action api.Action, entity string, result string) {
+	acc, _ := b.idStore.Get(c.Sender().ID)
+	err := b.audit.Append(api.AuditEntry{
+		Time:     time.Now(),
+		TgId:     c.Sender().ID,
+		Username: c.Sender().Username,
+		Portal:   acc.Portal,
+		BxId:     acc.BxId,
+		Action:   action,
+		Entity:   entity,
+		Result:   result,
+	})
+	if err != nil {
+		b.logger.Warn("audit append", "err", err.Error(), "action", action, "entity", entity)
+	}
+}
+
+func tgUserEntity(tgId int64) string {
+	return "tgUser:" + strconv.FormatInt(tgId, 10)
+}
+
+// Shows the latest audit entries that match the query
+// Query is a list of key=value args: tg, bx, user, entity, date, from, to(dates are YYYY-MM-DD)
+func (b *bot) onAudit(c tele.Context) error {
+	tr := b.tr(c)
+	q, ok := parseAuditQuery(c.Message().Payload)
+	if !ok {
+		return c.Send(tr.Tr("admin.auditUsage"))
+	}
+	q.Limit = auditLimit
+	q.Portal = b.adminPortal(c)
+
+	entries, err := b.audit.Query(q)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	texts, err := b.auditMessages(tr, entries)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	for _, text := range texts {
+		if err := c.Send(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renders entries to messages that fit telegram limit - every message gets as many entries as fit
+func (b *bot) auditMessages(tr api.Localizer, entries []api.AuditEntry) ([]string, error) {
+	for i, e := range entries {
+		if result := []rune(e.Result); len(result) > auditResultLimit {
+			entries[i].Result = string(append(result[:auditResultLimit], '…'))
+		}
+	}
+	render := func(part []api.AuditEntry) (string, error) {
+		return b.views.Render(tr, screens.Audit, screens.AuditView{Entries: part})
+	}
+	if len(entries) == 0 {
+		text, err := render(nil)
+		return []string{text}, err
+	}
+
+	texts := []string{}
+	for start := 0; start < len(entries); {
+		end := start + 1
+		text, err := render(entries[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for ; end < len(entries); end++ {
+			next, err := render(entries[start : end+1])
+			if err != nil {
+				return nil, err
+			}
+			if len(next) > auditMessageLimit {
+				break
+			}
+			text = next
+		}
+		texts = append(texts, text)
+		start = end
+	}
+	return texts, nil
+}
+
+func parseAuditQuery(args string) (api.AuditQuery, bool) {
+	q := api.AuditQuery{}
+	for _, arg := range strings.Fields(args) {
+		key, value, found := strings.Cut(arg, "=")
+		if !found || value == "" {
+			return q, false
+		}
+		var err error
+		switch key {
+		case "tg":
+			q.TgId, err = strconv.ParseInt(value, 10, 64)
+		case "bx":
+			q.BxId, err = strconv.ParseInt(value, 10, 64)
+		case "user":
+			q.Username = strings.TrimPrefix(value, "@")
+		case "entity":
+			q.Entity = value
+		case "date": // The whole day
+			q.From, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+			q.To = q.From.AddDate(0, 0, 1)
+		case "from":
+			q.From, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+		case "to": // Inclusive
+			q.To, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+			q.To = q.To.AddDate(0, 0, 1)
+		default:
+			return q, false
+		}
+		if err != nil {
+			return q, false
+		}
+	}
+	return q, true
+}
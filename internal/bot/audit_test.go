@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/i18n"
+)
+
+func TestParseAuditQuery(t *testing.T) {
+	q, ok := parseAuditQuery("tg=1 bx=2 user=@Ivan entity=deal:5 date=2024-05-10")
+	if !ok {
+		t.Fatal("valid query is rejected")
+	}
+	from := time.Date(2024, 5, 10, 0, 0, 0, 0, time.Local)
+	want := api.AuditQuery{TgId: 1, BxId: 2, Username: "Ivan", Entity: "deal:5", From: from, To: from.AddDate(0, 0, 1)}
+	if q != want {
+		t.Errorf("got %+v, want %+v", q, want)
+	}
+	q, _ = parseAuditQuery("to=2024-05-10")
+	if !q.To.Equal(from.AddDate(0, 0, 1)) {
+		t.Errorf("to is not inclusive: %s", q.To)
+	}
+	for _, args := range []string{"tg", "tg=", "tg=x", "date=10.05.2024", "who=1"} {
+		if _, ok := parseAuditQuery(args); ok {
+			t.Errorf("invalid query %q is accepted", args)
+		}
+	}
+}
+
+func TestAuditMessages(t *testing.T) {
+	views, err := screens.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := i18n.Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bot{views: views}
+	tr := catalog.Localizer("en")
+
+	texts, err := b.auditMessages(tr, nil)
+	if err != nil || len(texts) != 1 {
+		t.Fatalf("empty log: %d messages, err %v", len(texts), err)
+	}
+
+	entries := []api.AuditEntry{}
+	for i := 0; i < auditLimit; i++ {
+		entries = append(entries, api.AuditEntry{
+			Time:   time.Now(),
+			TgId:   int64(i + 1),
+			Action: api.ActionComment,
+			Entity: "deal:1",
+			Result: strings.Repeat("e", 1000), // Is cut to auditResultLimit
+		})
+	}
+	texts, err = b.auditMessages(tr, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) < 2 {
+		t.Errorf("long output is not split: %d messages", len(texts))
+	}
+	count := 0
+	for _, text := range texts {
+		if len(text) > auditMessageLimit {
+			t.Errorf("message is longer than limit: %d", len(text))
+		}
+		count += strings.Count(text, "deal:1")
+	}
+	if count != len(entries) {
+		t.Errorf("%d entries are shown, want %d", count, len(entries))
+	}
+}
@@ -1,15 +1,23 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"log/slog"
+	"net/http"
 	"os"
 	"slices"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
 	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/session"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/i18n"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/scheduler"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/log"
 
@@ -21,10 +29,22 @@ import (
 var validate = validator.New(validator.WithRequiredStructEnabled())
 
 type BotDescriptor struct {
-	TgBotToken string        `validate:"required"`
-	Bx         api.BxWrapper `validate:"required"`
-
-	AdminWhitelist []string `validate:"required"`
+	TgBotToken string            `validate:"required"`
+	Portals    api.BxPortals     `validate:"required"` // Bitrix portals of tenants
+	Catalog    *i18n.Catalog     `validate:"required"` // Messages of all languages
+	Screens    *screens.Renderer `validate:"required"` // Templates of screens
+	Audit      api.AuditLog      `validate:"required"` // Log of bitrix changes
+	UndoWindow time.Duration     // How long comments and task completions could be undone, zero disables undo
+
+	DigestTime   string         // Default time(HH:MM) of daily tasks digest, empty disables digests
+	DefaultZone  *time.Location `validate:"required"` // Time zone of users that did not set their own
+	Jobs         api.JobQueue   `validate:"required"` // Delayed jobs like reminders
+	RemindBefore time.Duration  // Default lead time of deadline reminders, zero disables reminders
+
+	EventsAddr   string            // Address of http server for bitrix events, empty disables notifications
+	EventsTokens map[string]string `validate:"required_with=EventsAddr"` // application_token of bitrix outbound webhook by portal
+
+	AdminBxIds map[string][]int64 `validate:"required"` // Bitrix ids of users with admin role by portal
 }
 
 type bot struct {
@@ -33,19 +53,56 @@ type bot struct {
 	// Base
 	bot       *tele.Bot     // Telegram bot API wrapper
 	mainGroup *tele.Group   // Group for main handlers - is neede because I do not need to apply session middle for OnContact endpoint
-	bx        api.BxWrapper // Bitrix wrapper
+	portals   api.BxPortals // Bitrix wrappers of tenants' portals
 
 	// User/session managing
-	idStore  api.UsersIdStore   // Store of familiar users' IDs, so they do not have to share their contact every time
-	sessions api.SessionManager // Manages sessions
+	idStore  api.UsersIdStore      // Store of familiar users' IDs, so they do not have to share their contact every time
+	settings api.UserSettingsStore // Users' preferences like language
+	sessions api.SessionManager    // Manages sessions
+	lastSave atomic.Int64          // Unix time of the last id store saving after touch
+	roles    portalRoles           // Rules to resolve users' roles
+	audit    api.AuditLog          // Who changed what through the bot
+
+	// Localization
+	catalog *i18n.Catalog
+	views   *screens.Renderer
 
 	// Dynamic data
 	contactRequestMsgs map[int64]tele.Editable // Map of contact request messages for deletion and this way hiding inline keyboard
 	// Is needed because somehow telegram replyTo value is nil on phones... why...
+	linkMutex    sync.Mutex       // Guards linking and startPortals - handlers of different users run concurrently
+	linking      map[int64]bool   // Users that requested to link another account - their next contact or login code is linked to the session
+	startPortals map[int64]string // Portal from deep link(/start <portal>) - contact is looked up only there
+	codeMutex    sync.Mutex
+	codeLogins   map[int64]codeLogin           // Users that log in by one-time code
+	codeTargets  map[api.BxAccount][]time.Time // When codes were sent to accounts
+	guard        *authGuard                    // Rate limits and bans of auth attempts
+	flood        *floodControl                 // Rate limits and serialization of users' handlers
+
+	// Broadcasts
+	broadcastMutex  sync.Mutex
+	broadcastDrafts map[int64]*broadcastDraft // Previews by admin
+	broadcastSeq    atomic.Int64              // Id of the last draft
+	broadcasts      chan *broadcastJob        // Sending queue
+
+	// Background senders
+	done chan struct{} // Is closed on stop - broadcasts, digests and reminders quit
+	bulk *throttle     // Rate of their messages
+
+	// Scheduled jobs
+	scheduler    *scheduler.Scheduler
+	digestTime   string         // Default digest time
+	defaultZone  *time.Location // Default users' time zone
+	jobs         api.JobQueue
+	remindBefore time.Duration // Default reminders lead time
+
+	// Bitrix events
+	eventsAddr   string
+	eventsTokens map[string]string
+	eventsWg     sync.WaitGroup // Events that are handled after response
 
 	// Tg logging
-	output         *log.TgOutput // For tg logging
-	adminWhitelist []string      // Contains list of users that will get these logs
+	output *log.TgOutput // For tg logging - admins get logs of their portal
 }
 
 func New(logger *slog.Logger, descr BotDescriptor) (api.Bot, error) {
@@ -59,6 +116,7 @@ func New(logger *slog.Logger, descr BotDescriptor) (api.Bot, error) {
 		Token:     descr.TgBotToken,
 		Poller:    &tele.LongPoller{Timeout: 10 * time.Second},
 		ParseMode: tele.ModeHTML,
+		Client:    &http.Client{Timeout: 2 * time.Minute, Transport: newRetryTransport(logger.WithGroup("TELEGRAM"))}, // Timeout includes retry waits
 	}
 	telebot, err := tele.NewBot(pref)
 	if err != nil {
@@ -68,22 +126,85 @@ func New(logger *slog.Logger, descr BotDescriptor) (api.Bot, error) {
 	// Setup session group
 	mainGroup := telebot.Group()
 
+	roles, err := loadRolesConfig(os.Getenv("ROLES_FILE"), descr.Portals.Names(), descr.AdminBxIds)
+	if err != nil {
+		return nil, err
+	}
+	confirmRoles := map[string][]api.Role{}
+	for portal, config := range roles {
+		bx, _ := descr.Portals.Get(portal)
+		config.heads = newDepartmentHeads(logger.With("portal", portal), bx)
+		roles[portal] = config
+		confirmRoles[portal] = config.ConfirmComplete
+	}
+
 	b := &bot{
 		logger: logger,
 
 		bot:       telebot,
 		mainGroup: mainGroup,
-		bx:        descr.Bx,
+		portals:   descr.Portals,
 
 		idStore:  NewJsonUsersIdStore(logger, os.Getenv("ID_STORE_FILE")),
-		sessions: session.NewManager(logger, telebot, mainGroup),
+		settings: NewJsonUserSettingsStore(logger, os.Getenv("SETTINGS_STORE_FILE")),
+		roles:    roles,
+		audit:    descr.Audit,
+
+		catalog: descr.Catalog,
+		views:   descr.Screens,
 
 		contactRequestMsgs: map[int64]tele.Editable{},
+		linking:            map[int64]bool{},
+		startPortals:       map[int64]string{},
+		codeLogins:         map[int64]codeLogin{},
+		codeTargets:        map[api.BxAccount][]time.Time{},
+		guard:              newAuthGuard(),
+		flood:              newFloodControl(),
+
+		broadcastDrafts: map[int64]*broadcastDraft{},
+		broadcasts:      make(chan *broadcastJob, broadcastQueueSize),
+
+		done: make(chan struct{}),
+		bulk: newThrottle(bulkRate),
 
-		output:         log.NewTgOutput(telebot),
-		adminWhitelist: descr.AdminWhitelist,
+		scheduler:    scheduler.New(logger.WithGroup("SCHEDULER")),
+		digestTime:   descr.DigestTime,
+		defaultZone:  descr.DefaultZone,
+		jobs:         descr.Jobs,
+		remindBefore: descr.RemindBefore,
+
+		eventsAddr:   descr.EventsAddr,
+		eventsTokens: descr.EventsTokens,
+
+		output: log.NewTgOutput(telebot),
 	}
 
+	if descr.DigestTime != "" {
+		at, err := time.Parse(clockLayout, descr.DigestTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest time: %w", err)
+		}
+		b.digestTime = at.Format(clockLayout) // Times are compared as strings so they have to be padded
+		b.scheduler.Every("digest", digestCheckInterval, b.sendDigests)
+	}
+	if descr.RemindBefore > 0 {
+		b.scheduler.Every("remindersSync", remindSyncInterval, b.syncReminders)
+	}
+	b.scheduler.Every("jobs", remindCheckInterval, b.runJobs) // Snoozed reminders are run even if reminders are disabled now
+	b.scheduler.Every("codeLogins", codeSendWindow, b.cleanupCodeLogins)
+	b.scheduler.Every("authGuard", guardCleanup, b.guard.cleanup)
+	b.scheduler.Every("flood", floodCleanup, b.flood.cleanup)
+
+	// Telebot applies middleware on handler registration and the manager registers session input handlers
+	b.setupMiddleware()
+	b.sessions = session.NewManager(logger, telebot, mainGroup, session.ManagerDescriptor{
+		Views:        descr.Screens,
+		Audit:        descr.Audit,
+		UndoWindow:   descr.UndoWindow,
+		ConfirmRoles: confirmRoles,
+		Serialize:    b.flood.serialize,
+	})
+	metrics.RegisterSessions(b.sessions.Count)
 	if err := b.setupEndpoints(); err != nil {
 		return nil, fmt.Errorf("bot setup endpoints: %w", err)
 	}
@@ -108,42 +229,108 @@ func (b *bot) Start() error {
 	// }
 	// Does not work...
 
+	if b.eventsAddr != "" {
+		events := b.startEvents()
+		defer func() {
+			shutdownServer(b.logger, events)
+			b.eventsWg.Wait()
+		}()
+	}
+	b.scheduler.Start()
+	broadcasts := make(chan struct{})
+	go func() {
+		b.runBroadcasts()
+		close(broadcasts)
+	}()
+	defer func() {
+		close(b.done) // Senders waiting for throttle quit at once
+		b.scheduler.Stop()
+		<-broadcasts
+		b.bulk.close()
+	}()
+
 	b.logger.Debug("bot started")
 	b.bot.Start()
 	b.logger.Debug("bot ended")
 	return nil
 }
 
+// Checks if the bot is stopping
+func (b *bot) stopped() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *bot) Stop() {
+	b.bot.Stop()
+}
+
 func (b *bot) GetLogsOutput() log.Output {
 	return b.output
 }
 
-func (b *bot) setupEndpoints() error {
-	// Setup middle
-	b.mainGroup.Use(b.sessionMiddle) // For authorization
+// Must be called before any handler registration
+func (b *bot) setupMiddleware() {
+	// Metrics are the first so flood drops and panics are counted too
+	b.bot.Use(b.metricsMiddle)
+	b.mainGroup.Use(b.floodMiddle, b.sessionMiddle) // For flood control and authorization
 	b.bot.Use(middleware.AutoRespond())
 	b.bot.Use(middleware.Recover(func(err error, c tele.Context) {
-		str := fmt.Sprintf("ERROR:\n<code>panic: %s</code>\n\nПерезапутите бот прописав <code>/start</code>}", err.Error())
+		str := b.tr(c).Tr("error.panic", "err", html.EscapeString(err.Error()))
 		b.logger.Warn(str, "username", c.Sender().Username)
 		c.Send(str)
 	}))
+}
 
+func (b *bot) setupEndpoints() error {
 	// Contact for auth
-	b.bot.Handle(tele.OnContact, b.onContact)  // The method is not in auth group!!!
-	b.bot.Handle("/start_logs", b.onStartLogs) // The method is not in auth group!!!
+	b.bot.Handle(tele.OnContact, b.onContact, b.floodMiddle) // The method is not in auth group!!!
+	b.bot.Handle("/lang", b.onLang, b.floodMiddle)           // The method is not in auth group!!! - language could be chosen before auth
+
+	// Admin commands - role is known only after auth
+	b.mainGroup.Handle("/start_logs", b.onStartLogs, b.require(api.ActionAdmin))
+	b.mainGroup.Handle("/users", b.onUsers, b.require(api.ActionAdmin))
+	b.mainGroup.Handle(&usersPageBtn, b.onUsersPage, b.require(api.ActionAdmin))
+	b.mainGroup.Handle("/revoke", b.onRevoke, b.require(api.ActionRevoke))
+	b.mainGroup.Handle("/whois", b.onWhois, b.require(api.ActionAdmin))
+	b.mainGroup.Handle("/audit", b.onAudit, b.require(api.ActionAdmin))
+	b.mainGroup.Handle("/broadcast", b.onBroadcast, b.require(api.ActionBroadcast))
+	b.mainGroup.Handle(&broadcastAudienceBtn, b.onBroadcastAudience, b.require(api.ActionBroadcast))
+	b.mainGroup.Handle(&broadcastSendBtn, b.onBroadcastSend, b.require(api.ActionBroadcast))
+	b.mainGroup.Handle(&broadcastCancelBtn, b.onBroadcastCancel, b.require(api.ActionBroadcast))
+
+	// Digest settings
+	b.mainGroup.Handle("/digest", b.onDigest)
+	b.mainGroup.Handle(&digestToggleBtn, b.onDigestToggle)
+	b.mainGroup.Handle(&digestNowBtn, b.onDigestNow)
+	if b.remindBefore > 0 {
+		b.mainGroup.Handle("/remind", b.onRemind)
+	}
+	b.mainGroup.Handle(&snoozeBtn, b.onSnooze)
+
+	// Linked accounts
+	b.mainGroup.Handle("/accounts", b.onAccounts)
+	b.mainGroup.Handle(&switchAccountBtn, b.onSwitchAccount)
+	b.mainGroup.Handle(&unlinkAccountBtn, b.onUnlinkAccount)
+	b.mainGroup.Handle(&linkAccountBtn, b.onLinkAccount)
+	b.mainGroup.Handle("/login", b.onLinkByCode) // Users without session are handled by code login in session middleware
+
+	b.mainGroup.Handle("/start", b.onStart)
 
-	b.mainGroup.Handle("/start", func(c tele.Context) error {
-		// If user reached this endpoint - session exists
-		// Reset session
-		return b.sessions.Get(c.Sender().ID).OnStart(c)
+	b.mainGroup.Handle("/cancel", func(c tele.Context) error {
+		return b.sessions.Get(c.Sender().ID).OnCancel(c)
 	})
 
 	b.mainGroup.Handle("/stop", func(c tele.Context) error { // For debug purposes - ends user's session
 		if b.sessions.Exist(c.Sender().ID) {
 			b.sessions.Stop(c.Sender().ID)
-			return c.Send("Сессия остановлена")
+			return c.Send(b.tr(c).Tr("session.stopped"))
 		}
-		return c.Send("Не найдено активных сессий")
+		return c.Send(b.tr(c).Tr("session.notFound"))
 	})
 	return nil
 }
@@ -156,20 +343,34 @@ func (b *bot) sessionMiddle(next tele.HandlerFunc) tele.HandlerFunc {
 			b.logger.Debug("auth user", "id", c.Sender().ID)
 			// Check if chat is suitable for conversation
 			if c.Sender().IsBot {
-				return c.Send("Сообщения от ботов не разрешены")
+				return c.Send(b.tr(c).Tr("auth.botsNotAllowed"))
+			}
+			if !b.guardAuth(c) {
+				return nil
 			}
 
 			// Check by id else request contact info
-			know, err := b.tryAuthById(c)
+			know, err := b.tryAuthById(c, metrics.AuthById)
 			if err != nil {
 				return fmt.Errorf("try auth by id: %w", err)
 			}
-			if !know { // We do not know the id - need to auth by phone
+			if !know { // We do not know the id - need to auth by phone or by code
+				if handled, err := b.handleCodeLogin(c); handled {
+					return err
+				}
+				if portal, ok := b.deepLinkPortal(c); ok {
+					b.setStartPortal(c.Sender().ID, portal)
+				}
 				return b.reqContact(c)
 			}
 			// We know user and there was know errors with auth => we authed!
 			// Continue with request
+		} else if b.isLinking(c.Sender().ID) { // Login and code of another account
+			if handled, err := b.handleCodeLogin(c); handled {
+				return err
+			}
 		}
+		b.touchUser(c)
 		return next(c)
 	}
 }
@@ -178,10 +379,14 @@ func (b *bot) sessionMiddle(next tele.HandlerFunc) tele.HandlerFunc {
 func (b *bot) reqContact(c tele.Context) error {
 	// Setup reply markup
 
+	tr := b.tr(c)
 	r := &tele.ReplyMarkup{ResizeKeyboard: true}
-	r.Reply(r.Row(r.Contact("Предоставить номер")))
+	r.Reply(
+		r.Row(r.Contact(tr.Tr("auth.shareContactBtn"))),
+		r.Row(r.Text(tr.Tr("auth.codeBtn"))),
+	)
 
-	msg, err := b.bot.Send(c.Sender(), `Для авторизыации предоставьте номер телефона.(кнопка "Предоставить номер")`, r)
+	msg, err := b.bot.Send(c.Sender(), tr.Tr("auth.requestContact"), r)
 	if err != nil {
 		return err
 	}
@@ -194,69 +399,111 @@ func (b *bot) onContact(c tele.Context) error {
 	b.logger.Debug("on contact")
 	if !b.sessions.Exist(c.Sender().ID) {
 		// Session does not exist so we auth
-
-		// Clear messages anyway - differs on desk and mobile versions (ReplyTo would be nil on mobile)
-		if c.Message().ReplyTo != nil {
-			b.bot.Delete(c.Message().ReplyTo)
-		} else if msg := b.contactRequestMsgs[c.Sender().ID]; msg != nil {
-			b.bot.Delete(msg)
-			delete(b.contactRequestMsgs, c.Sender().ID)
+		if !b.guardAuth(c) {
+			return nil
 		}
-		if c.Message() != nil {
-			b.bot.Delete(c.Message())
+		b.clearContactRequest(c)
+
+		// Contact of somebody else would give access to his account
+		if contact := c.Message().Contact; contact.UserID != c.Sender().ID {
+			b.logger.Warn("foreign contact", "tgId", c.Sender().ID, "username", c.Sender().Username)
+			b.authFailed(c, metrics.AuthByPhone)
+			return c.Send(b.tr(c).Tr("accounts.foreignContact"))
 		}
 
 		// b.logger.Debug(c.Message().Contact)
 		// Try to auth
-		if err := b.tryAuthByPhone(c); err != nil {
+		found, err := b.tryAuthByPhone(c)
+		if err != nil {
 			// Other error
 			b.logger.Debug("send authe rror")
-			footer, str := api.ErrorText(err)
+			tr := b.tr(c)
+			footer, str := api.ErrorText(tr, err)
 			b.logger.Warn(str, "username", c.Sender().Username)
+			if errors.Is(err, api.ErrorUserNotFound) || errors.Is(err, api.ErrorInvalidPhoneNumber) || errors.Is(err, api.ErrorRevoked) {
+				b.authFailed(c, metrics.AuthByPhone)
+			}
 			if footer {
-				str += "\n\nДля перезапуска отправьте команду <code>/start</code>"
+				str += tr.Tr("common.restartFooter")
 			}
 			return c.Send(str)
 		}
 
-		if err := c.Send("Авторизация прошла успешно."); err != nil {
+		text := b.tr(c).Tr("auth.success")
+		if len(found) > 1 {
+			text += "\n" + b.tr(c).Tr("auth.severalPortals", "count", len(found))
+		}
+		if err := c.Send(text); err != nil {
 			return fmt.Errorf("success authed msg send: %w", err)
 		}
 		return b.bot.Trigger("/start", c)
 	}
 
+	if b.isLinking(c.Sender().ID) { // User links another account
+		return b.linkAccount(c)
+	}
+
 	b.logger.Warn("got on contact message but user is authorised")
 	return nil
 }
 
-// Checks if this user is allowed to receive logs he will
+// Deletes contact request and the contact itself
+func (b *bot) clearContactRequest(c tele.Context) {
+	// Clear messages anyway - differs on desk and mobile versions (ReplyTo would be nil on mobile)
+	if c.Message().ReplyTo != nil {
+		b.bot.Delete(c.Message().ReplyTo)
+	} else if msg := b.contactRequestMsgs[c.Sender().ID]; msg != nil {
+		b.bot.Delete(msg)
+		delete(b.contactRequestMsgs, c.Sender().ID)
+	}
+	if c.Message() != nil {
+		b.bot.Delete(c.Message())
+	}
+}
+
+// Adds admin to log receivers
 // Should be call every time after bot restart
 func (b *bot) onStartLogs(c tele.Context) error {
-	// Check if is admin add to tg output
-	if slices.Contains(b.adminWhitelist, c.Sender().Username) {
-		b.logger.Debug("add admin", "username", c.Sender().Username)
+	b.logger.Debug("add admin", "username", c.Sender().Username)
+	if portal := b.adminPortal(c); portal != "" {
+		b.output.AddScoped(c.Chat(), "portal", portal)
+	} else { // Admins of the default portal run the bot - they get records of the whole bot too
 		b.output.Add(c.Chat())
-		return c.Send("Авторизация успешна.")
 	}
-	return c.Send("Отказ в доступе: вас нет в списке.")
+	return c.Send(b.tr(c).Tr("logs.granted"))
+}
+
+// Updates last seen time of the user
+// Store is saved not more often than once a minute because it is called on every update
+func (b *bot) touchUser(c tele.Context) {
+	b.idStore.Touch(c.Sender().ID, c.Sender().Username)
+	now := time.Now().Unix()
+	if last := b.lastSave.Load(); now-last >= 60 && b.lastSave.CompareAndSwap(last, now) {
+		if err := b.idStore.Save(); err != nil { // Error is logged by the store
+			b.lastSave.Store(last) // Retry on the next update
+		}
+	}
 }
 
 // Checks if user is familiar(we know his vx id) and if session does not exist it creates it
 // Returns true if we know the user, false if not
-func (b *bot) tryAuthById(c tele.Context) (bool, error) {
+// Method is counted in auth metrics - id is linked by code right before auth
+func (b *bot) tryAuthById(c tele.Context, method string) (bool, error) {
 	// Assume session does not exist
 	tgId := c.Sender().ID
-	bxId, wok := b.idStore.Get(tgId)
+	acc, wok := b.idStore.Get(tgId)
 
 	if wok { // id exists in the list of familiar users and session does not exist
-		u, err := b.bx.AuthUserById(bxtypes.Id(bxId))
+		u, err := b.authAccount(acc)
 		if err != nil {
+			metrics.Auth(method, metrics.AuthFailure)
 			return true, err
 		}
 		// Auth is successful
-		b.onUserAuth(c)
+		role := b.roles.of(acc.Portal).resolve(u.Get())
+		b.onUserAuth(c, acc, role, method)
 		// Create session
-		b.sessions.Start(tgId, u)
+		b.sessions.Start(tgId, acc.Portal, u, b.tr(c), role)
 
 		return true, nil
 	}
@@ -264,62 +511,100 @@ func (b *bot) tryAuthById(c tele.Context) (bool, error) {
 }
 
 // Checks if session exists and if not - auth user by phone, add it to the list of familiar users and create session
-func (b *bot) tryAuthByPhone(c tele.Context) error {
+// Phone is looked up in portal of deep link or in all portals - all found accounts are linked, the first one becomes active
+// Portals of already linked accounts are skipped - the phone would find the same account there
+// Returns found accounts
+func (b *bot) tryAuthByPhone(c tele.Context) ([]api.BxAccount, error) {
 	// Assume session does not exist
 	tgId := c.Sender().ID
 
 	// Validate message
 	if c.Message().Contact == nil {
-		return api.ErrorNoContactInMsg
+		return nil, api.ErrorNoContactInMsg
+	}
+	portals := b.portals.Names()
+	if portal, ok := b.startPortal(tgId); ok {
+		portals = []string{portal}
+		b.setStartPortal(tgId, "")
+	}
+	if portals = b.notRevoked(tgId, portals, time.Now()); len(portals) == 0 {
+		return nil, api.ErrorRevoked
+	}
+	linked := b.idStore.Accounts(tgId)
+	portals = slices.DeleteFunc(portals, func(portal string) bool {
+		return slices.ContainsFunc(linked, func(acc api.BxAccount) bool { return acc.Portal == portal })
+	})
+	if len(portals) == 0 {
+		return nil, api.ErrorUserNotFound
 	}
+
 	// Do auth
 	b.logger.Debug("bx log by phone")
 
-	phoneNumber := fixPhoneNumber(c.Message().Contact.PhoneNumber)
+	phoneNumber := c.Message().Contact.PhoneNumber // Is normalized by every portal with its default region
 	b.logger.Debug(phoneNumber)
-	u, err := b.bx.AuthUserByPhone(phoneNumber)
-	if err != nil {
-		return err
+	unknownKey := unknownPhoneKey(phoneNumber, portals)
+	if b.guard.isUnknownPhone(unknownKey, time.Now()) {
+		b.logger.Debug("phone is cached as unknown")
+		return nil, api.ErrorUserNotFound
+	}
+	found := []api.BxAccount{}
+	users := []api.BxUser{}
+	var lookupErr error = api.ErrorUserNotFound // Other errors are more informative than not found
+	for _, portal := range portals {
+		bx, _ := b.portals.Get(portal)
+		u, err := bx.AuthUserByPhone(phoneNumber)
+		if err != nil {
+			b.logger.Debug("phone lookup", "portal", portal, "err", err.Error())
+			if !errors.Is(err, api.ErrorUserNotFound) {
+				lookupErr = err
+			}
+			continue
+		}
+		found = append(found, api.BxAccount{Portal: portal, BxId: int64(u.Get().Id)})
+		users = append(users, u)
+	}
+	if len(found) == 0 {
+		if errors.Is(lookupErr, api.ErrorUserNotFound) || errors.Is(lookupErr, api.ErrorInvalidPhoneNumber) {
+			b.guard.rememberUnknownPhone(unknownKey, time.Now())
+		}
+		return nil, lookupErr
 	}
 	b.logger.Debug("ok")
+	for _, u := range users[1:] { // Session needs only the active one
+		u.Close()
+	}
 
 	// Auth is successful
-	b.onUserAuth(c)
-	// Save user
-	b.idStore.Set(tgId, int64(u.Get().Id))
+	acc, u := found[0], users[0]
+	role := b.roles.of(acc.Portal).resolve(u.Get())
+	b.onUserAuth(c, acc, role, metrics.AuthByPhone)
+	// Save user - the first account is set the last so it is active
+	for i := len(found) - 1; i >= 0; i-- {
+		b.idStore.Set(tgId, found[i])
+	}
 	if err := b.idStore.Save(); err != nil {
 		b.logger.Warn(err.Error())
 	}
 	// Create session
-	b.sessions.Start(tgId, u)
+	b.sessions.Start(tgId, acc.Portal, u, b.tr(c), role)
 
-	return nil
+	return found, nil
 }
 
 // Is called when user was successfully authorised
-func (b *bot) onUserAuth(c tele.Context) {
+func (b *bot) onUserAuth(c tele.Context, acc api.BxAccount, role api.Role, method string) {
+	b.guard.success(c.Sender().ID)
+	metrics.Auth(method, metrics.AuthSuccess)
 	// Logs of course
-	b.logger.Debug("user authed", "username", c.Sender().Username, "tgId", c.Sender().ID)
+	b.logger.Debug("user authed", "username", c.Sender().Username, "tgId", c.Sender().ID, "portal", acc.Portal, "role", role)
 }
 
-// Fixes phone number because telegram provide it in different style
-// Even on mobile it differes
-func fixPhoneNumber(in string) string {
-	str := in
-
-	// Remove all symbols but numbers
-	str = strings.ReplaceAll(str, "(", "")
-	str = strings.ReplaceAll(str, ")", "")
-	str = strings.ReplaceAll(str, "-", "")
-	str = strings.ReplaceAll(str, "+", "")
-	str = strings.ReplaceAll(str, " ", "")
-
-	// Add plus if it is not 8
-	if len(str) < 10 { // Definetely is not a phone number
-		return str
-	}
-	if str[0] != '8' {
-		return "+" + str
+// Creates bitrix user of the account
+func (b *bot) authAccount(acc api.BxAccount) (api.BxUser, error) {
+	bx, ok := b.portals.Get(acc.Portal)
+	if !ok { // Portal was removed from config
+		return nil, fmt.Errorf("unknown bitrix portal %q", acc.Portal)
 	}
-	return str
+	return bx.AuthUserById(bxtypes.Id(acc.BxId))
 }
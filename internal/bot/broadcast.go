@@ -0,0 +1,427 @@
+package bot
+
+import (
+	"cmp"
+	"errors"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Broadcast of admin's message to linked users of his portal
+// /broadcast <text> shows preview with audience buttons(all, by role, by department), the message is sent by "Send" button
+// Messages are sent by one queue through throttle of bulk messages, so telegram limit of 30 messages per second is not hit
+// Broadcast that is interrupted by stop is not resumed - its report shows pending recipients
+// Users that blocked the bot are marked in users id store and are skipped until their next visit
+
+const (
+	broadcastQueueSize      = 16 // Broadcasts that could wait for sending
+	broadcastReportInterval = 3 * time.Second
+	broadcastDepartments    = 20 // Department buttons of preview
+	broadcastUndelivered    = 20 // Undelivered users listed in report
+)
+
+// Audience is "all", "role:<role>" or "dept:<department id>"
+const audienceAll = "all"
+
+// Broadcast buttons - payload is "<draft id>|<audience>" or draft id
+var (
+	broadcastAudienceBtn = tele.Btn{Unique: "broadcastAudience"}
+	broadcastSendBtn     = tele.Btn{Unique: "broadcastSend"}
+	broadcastCancelBtn   = tele.Btn{Unique: "broadcastCancel"}
+)
+
+type broadcastRecipient struct {
+	api.LinkedUser
+	role        api.Role // Empty if bitrix user could not be loaded
+	departments bxtypes.IdList
+}
+
+// Broadcast that is being previewed by admin
+type broadcastDraft struct {
+	id          int64
+	portal      string
+	text        string // Plain text - is escaped on sending
+	recipients  []broadcastRecipient
+	departments map[bxtypes.Id]string // Names of recipients' departments
+	audience    string
+}
+
+type deliveryStatus int
+
+const (
+	deliveryPending = deliveryStatus(iota)
+	deliverySent
+	deliveryBlocked
+	deliveryFailed
+)
+
+// Broadcast in sending queue
+type broadcastJob struct {
+	id         int64
+	text       string
+	audience   string // Localized
+	recipients []broadcastRecipient
+	statuses   []deliveryStatus // Of recipients
+	tr         api.Localizer    // Admin's language
+	report     tele.Editable    // Message with delivery progress
+}
+
+// Shows preview of the broadcast
+func (b *bot) onBroadcast(c tele.Context) error {
+	tr := b.tr(c)
+	text := strings.TrimSpace(c.Message().Payload)
+	if text == "" {
+		return c.Send(tr.Tr("broadcast.usage"))
+	}
+	portal := b.adminPortal(c)
+	draft := &broadcastDraft{
+		id:          b.broadcastSeq.Add(1),
+		portal:      portal,
+		text:        text,
+		departments: map[bxtypes.Id]string{},
+		audience:    audienceAll,
+	}
+	for _, u := range b.idStore.List() {
+		if u, ok := scopeUser(u, portal); ok && !u.Blocked {
+			draft.recipients = append(draft.recipients, broadcastRecipient{LinkedUser: u})
+		}
+	}
+	if len(draft.recipients) == 0 {
+		return c.Send(tr.Tr("broadcast.noRecipients"))
+	}
+	b.loadRecipients(draft)
+
+	b.broadcastMutex.Lock()
+	b.broadcastDrafts[c.Sender().ID] = draft // Previous draft of the admin is dropped
+	b.broadcastMutex.Unlock()
+
+	text, menu, err := b.broadcastPreview(tr, draft)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Send(text, menu)
+}
+
+// Resolves roles and departments of recipients
+// Without them broadcast is still possible to all users
+func (b *bot) loadRecipients(draft *broadcastDraft) {
+	bx, ok := b.portals.Get(draft.portal)
+	if !ok { // Portal of admin's account was removed from config
+		b.logger.Warn("broadcast load users: unknown portal", "portal", draft.portal)
+		return
+	}
+	ids := []bxtypes.Id{}
+	for _, r := range draft.recipients {
+		ids = append(ids, bxtypes.Id(r.BxId))
+	}
+	users, err := bx.GetUsers(ids)
+	if err != nil {
+		b.logger.Warn("broadcast load users", "portal", draft.portal, "err", err.Error())
+		return
+	}
+	byId := map[bxtypes.Id]bxtypes.User{}
+	for _, u := range users {
+		byId[u.Id] = u
+	}
+	departments := []bxtypes.Id{}
+	for i, r := range draft.recipients {
+		u, ok := byId[bxtypes.Id(r.BxId)]
+		if !ok { // Deleted user
+			continue
+		}
+		draft.recipients[i].role = b.roles.of(draft.portal).resolve(u)
+		draft.recipients[i].departments = u.Departments
+		for _, d := range u.Departments {
+			if !slices.Contains(departments, d) {
+				departments = append(departments, d)
+			}
+		}
+	}
+	found, err := bx.GetDepartments(departments)
+	if err != nil {
+		b.logger.Warn("broadcast load departments", "portal", draft.portal, "err", err.Error())
+	}
+	for _, d := range departments { // Department is shown by id if it is not loaded
+		draft.departments[d] = d.String()
+	}
+	for _, d := range found {
+		draft.departments[d.Id] = d.Name
+	}
+}
+
+func (r broadcastRecipient) in(audience string) bool {
+	kind, value, _ := strings.Cut(audience, ":")
+	switch kind {
+	case "role":
+		return string(r.role) == value
+	case "dept":
+		return slices.ContainsFunc(r.departments, func(d bxtypes.Id) bool { return d.String() == value })
+	}
+	return audience == audienceAll
+}
+
+func (draft *broadcastDraft) audienceRecipients(audience string) []broadcastRecipient {
+	recipients := []broadcastRecipient{}
+	for _, r := range draft.recipients {
+		if r.in(audience) {
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients
+}
+
+func (draft *broadcastDraft) audienceName(tr api.Localizer, audience string) string {
+	kind, value, _ := strings.Cut(audience, ":")
+	switch kind {
+	case "role":
+		return api.RoleText(tr, api.Role(value))
+	case "dept":
+		id, _ := strconv.Atoi(value)
+		return draft.departments[bxtypes.Id(id)]
+	}
+	return tr.Tr("broadcast.all")
+}
+
+// Renders preview with audience buttons
+func (b *bot) broadcastPreview(tr api.Localizer, draft *broadcastDraft) (string, *tele.ReplyMarkup, error) {
+	text, err := b.views.Render(tr, screens.BroadcastPreview, screens.BroadcastPreviewView{
+		Text:       draft.text,
+		Audience:   draft.audienceName(tr, draft.audience),
+		Recipients: len(draft.audienceRecipients(draft.audience)),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only audiences with recipients are offered
+	audiences := []string{audienceAll}
+	for _, role := range api.Roles {
+		audiences = append(audiences, "role:"+string(role))
+	}
+	departments := []bxtypes.Id{}
+	for d := range draft.departments {
+		departments = append(departments, d)
+	}
+	slices.SortFunc(departments, func(a, b bxtypes.Id) int {
+		return cmp.Compare(draft.departments[a], draft.departments[b])
+	})
+	for _, d := range departments[:min(len(departments), broadcastDepartments)] {
+		audiences = append(audiences, "dept:"+d.String())
+	}
+
+	menu := &tele.ReplyMarkup{}
+	id := strconv.FormatInt(draft.id, 10)
+	btns := []tele.Btn{}
+	for _, audience := range audiences {
+		count := len(draft.audienceRecipients(audience))
+		if count == 0 {
+			continue
+		}
+		name := draft.audienceName(tr, audience) + " (" + strconv.Itoa(count) + ")"
+		if audience == draft.audience {
+			name = "✓ " + name
+		}
+		btns = append(btns, menu.Data(name, broadcastAudienceBtn.Unique, id+"|"+audience))
+	}
+	rows := menu.Split(2, btns)
+	rows = append(rows, menu.Row(
+		menu.Data(tr.Tr("broadcast.sendBtn"), broadcastSendBtn.Unique, id),
+		menu.Data(tr.Tr("broadcast.cancelBtn"), broadcastCancelBtn.Unique, id),
+	))
+	menu.Inline(rows...)
+	return text, menu, nil
+}
+
+// Returns draft of the admin if button is of it
+func (b *bot) broadcastDraft(c tele.Context, id string) *broadcastDraft {
+	b.broadcastMutex.Lock()
+	defer b.broadcastMutex.Unlock()
+	draft := b.broadcastDrafts[c.Sender().ID]
+	if draft == nil || strconv.FormatInt(draft.id, 10) != id {
+		return nil
+	}
+	return draft
+}
+
+// Changes audience of the draft
+func (b *bot) onBroadcastAudience(c tele.Context) error {
+	tr := b.tr(c)
+	id, audience, _ := strings.Cut(c.Data(), "|")
+	draft := b.broadcastDraft(c, id)
+	if draft == nil {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	draft.audience = audience // Draft is changed only by handlers of its admin - they run one by one
+
+	text, menu, err := b.broadcastPreview(tr, draft)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Edit(text, menu)
+}
+
+// Puts the draft to sending queue
+func (b *bot) onBroadcastSend(c tele.Context) error {
+	tr := b.tr(c)
+	draft := b.broadcastDraft(c, c.Data())
+	if draft == nil {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	recipients := draft.audienceRecipients(draft.audience)
+	job := &broadcastJob{
+		id:         draft.id,
+		text:       draft.text,
+		audience:   draft.audienceName(tr, draft.audience),
+		recipients: recipients,
+		statuses:   make([]deliveryStatus, len(recipients)),
+		tr:         tr,
+		report:     c.Callback().Message,
+	}
+	// Report is shown before the job is queued - after that only the worker reads statuses and edits the report
+	text, err := b.broadcastReport(job, true)
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	if err := c.Edit(text, &tele.ReplyMarkup{}); err != nil {
+		return err
+	}
+	select {
+	case b.broadcasts <- job:
+	default:
+		// Preview is returned so admin could send it later
+		text, menu, err := b.broadcastPreview(tr, draft)
+		if err != nil {
+			return b.sendError(c, err)
+		}
+		if err := c.Edit(text, menu); err != nil {
+			return err
+		}
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("broadcast.queueFull")})
+	}
+	b.broadcastMutex.Lock()
+	delete(b.broadcastDrafts, c.Sender().ID)
+	b.broadcastMutex.Unlock()
+
+	b.record(c, api.ActionBroadcast, "broadcast:"+strconv.FormatInt(job.id, 10), api.AuditResultOk)
+	b.logger.Info("broadcast queued", "id", job.id, "portal", draft.portal, "audience", draft.audience, "recipients", len(recipients), "by", c.Sender().ID)
+	return nil
+}
+
+func (b *bot) onBroadcastCancel(c tele.Context) error {
+	tr := b.tr(c)
+	if b.broadcastDraft(c, c.Data()) == nil {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	b.broadcastMutex.Lock()
+	delete(b.broadcastDrafts, c.Sender().ID)
+	b.broadcastMutex.Unlock()
+	return c.Edit(tr.Tr("broadcast.cancelled"), &tele.ReplyMarkup{})
+}
+
+// Sends queued broadcasts one by one until the bot is stopped
+func (b *bot) runBroadcasts() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case job := <-b.broadcasts:
+			b.runBroadcast(job)
+		}
+	}
+}
+
+func (b *bot) runBroadcast(job *broadcastJob) {
+	b.updateBroadcastReport(job)
+	reported := time.Now()
+	for i, r := range job.recipients {
+		if !b.bulk.wait(b.done) {
+			b.logger.Info("broadcast interrupted by stop", "id", job.id, "sent", i, "recipients", len(job.recipients))
+			break
+		}
+		job.statuses[i] = b.deliver(job, r)
+		if time.Since(reported) >= broadcastReportInterval {
+			b.updateBroadcastReport(job)
+			reported = time.Now()
+		}
+	}
+	if err := b.idStore.Save(); err != nil { // Blocked marks
+		b.logger.Warn(err.Error())
+	}
+	b.updateBroadcastReport(job)
+	b.logger.Info("broadcast finished", "id", job.id, "recipients", len(job.recipients))
+}
+
+// Sends broadcast message to the recipient, user that blocked the bot is marked
+func (b *bot) deliver(job *broadcastJob, r broadcastRecipient) deliveryStatus {
+	_, err := b.bot.Send(&tele.User{ID: r.TgId}, html.EscapeString(job.text))
+	switch {
+	case err == nil:
+		return deliverySent
+	case unreachable(err):
+		b.idStore.MarkBlocked(r.TgId)
+		return deliveryBlocked
+	}
+	b.logger.Debug("broadcast delivery", "id", job.id, "tgId", r.TgId, "err", err.Error())
+	return deliveryFailed
+}
+
+// Checks if send error means that the user could not get messages from the bot at all
+func unreachable(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) || errors.Is(err, tele.ErrUserIsDeactivated) || errors.Is(err, tele.ErrNotStartedByUser)
+}
+
+// Renders delivery progress of the broadcast
+func (b *bot) broadcastReport(job *broadcastJob, queued bool) (string, error) {
+	view := screens.BroadcastReportView{
+		Audience: job.audience,
+		Queued:   queued,
+		Done:     true,
+		Total:    len(job.recipients),
+	}
+	for i, status := range job.statuses {
+		r := job.recipients[i]
+		var text string
+		switch status {
+		case deliveryPending:
+			view.Done = false
+			continue
+		case deliverySent:
+			view.Sent++
+			continue
+		case deliveryBlocked:
+			view.Blocked++
+			text = job.tr.Tr("broadcast.statusBlocked")
+		case deliveryFailed:
+			view.Failed++
+			text = job.tr.Tr("broadcast.statusFailed")
+		}
+		if len(view.Undelivered) == broadcastUndelivered {
+			view.More++
+			continue
+		}
+		view.Undelivered = append(view.Undelivered, screens.BroadcastDelivery{TgId: r.TgId, Username: r.Username, Status: text})
+	}
+	view.Done = view.Done && !queued
+	return b.views.Render(job.tr, screens.BroadcastReport, view)
+}
+
+// Edits report message of the broadcast
+func (b *bot) updateBroadcastReport(job *broadcastJob) {
+	text, err := b.broadcastReport(job, false)
+	if err != nil {
+		b.logger.Warn("broadcast report", "id", job.id, "err", err.Error())
+		return
+	}
+	if _, err := b.bot.Edit(job.report, text); err != nil {
+		b.logger.Debug("broadcast report edit", "id", job.id, "err", err.Error())
+	}
+}
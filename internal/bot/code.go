@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Alternative auth by one-time code - for users whose telegram phone differs from bitrix profile
+// User enters bitrix login or email, the bot sends code to bitrix notifications(im.notify) of the found user
+// Entering the code links the account like shared contact, user with session links another account this way(/login)
+// Code expires after codeTTL, wrong codes, entered logins and codes sent to one account are limited
+// Reply does not tell if the login is found - otherwise logins could be checked by the bot
+
+const (
+	codeLen         = 6
+	codeTTL         = 10 * time.Minute
+	codeAttempts    = 5         // Wrong codes before the code is dropped
+	codeSendLimit   = 3         // Logins that could be entered per codeSendWindow
+	codeTargetLimit = 3         // Codes sent to one account per codeSendWindow by all telegram users
+	codeSendWindow  = time.Hour // Is also the time of state cleanup
+)
+
+type codeStage int
+
+const (
+	codeStageIdle  = codeStage(iota)
+	codeStageLogin // Login is expected
+	codeStageCode  // Code is expected
+)
+
+// Code of one found account - login could be found in several portals
+type codeCandidate struct {
+	acc  api.BxAccount
+	code string
+}
+
+// Code login state of telegram user
+// It is kept after failure so limits of sent codes work
+type codeLogin struct {
+	stage      codeStage
+	candidates []codeCandidate
+	expires    time.Time
+	attempts   int         // Wrong codes entered for current candidates
+	sent       []time.Time // When logins were entered
+}
+
+// Changes code login state of the user under lock - state is read and written at once
+// Empty state is dropped
+func (b *bot) updateCodeLogin(tgId int64, fn func(state *codeLogin)) {
+	b.codeMutex.Lock()
+	defer b.codeMutex.Unlock()
+	state := b.codeLogins[tgId]
+	fn(&state)
+	if state.stage == codeStageIdle && len(state.sent) == 0 {
+		delete(b.codeLogins, tgId)
+		return
+	}
+	b.codeLogins[tgId] = state
+}
+
+// Handles code login input, returns false if the message is not a part of it
+// Is called for users without session and for users that link another account
+func (b *bot) handleCodeLogin(c tele.Context) (bool, error) {
+	if c.Message() == nil || c.Message().Contact != nil {
+		return false, nil
+	}
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	text := strings.TrimSpace(c.Text())
+
+	restart := text == "/login" || text == tr.Tr("auth.codeBtn")
+	stage := codeStageIdle
+	b.updateCodeLogin(tgId, func(state *codeLogin) {
+		stage = state.stage
+		switch {
+		case restart:
+			state.stage = codeStageLogin
+		case stage == codeStageIdle:
+		case text == "/cancel" || strings.HasPrefix(text, "/start"):
+			state.stage = codeStageIdle
+		}
+	})
+
+	switch {
+	case restart:
+		return true, c.Send(tr.Tr("auth.codeEnterLogin"), &tele.ReplyMarkup{RemoveKeyboard: true})
+	case stage == codeStageIdle:
+		return false, nil
+	case text == "/cancel":
+		if b.sessions.Exist(tgId) { // Linking is cancelled
+			b.setLinking(tgId, false)
+			return true, c.Send(tr.Tr("accounts.linkCancelled"), &tele.ReplyMarkup{RemoveKeyboard: true})
+		}
+		return true, b.reqContact(c)
+	case strings.HasPrefix(text, "/start"): // Starts over, deep link is handled as usual
+		return false, nil
+	case stage == codeStageLogin:
+		return true, b.onCodeLoginEntered(c, text)
+	}
+	return true, b.onCodeEntered(c, text)
+}
+
+// Sends codes to accounts found by login
+func (b *bot) onCodeLoginEntered(c tele.Context, login string) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	now := time.Now()
+
+	// Every entered login is counted - otherwise logins could be guessed
+	limited := false
+	b.updateCodeLogin(tgId, func(state *codeLogin) {
+		state.sent = recent(state.sent, now, codeSendWindow)
+		if limited = len(state.sent) >= codeSendLimit; !limited {
+			state.sent = append(state.sent, now)
+		}
+	})
+	if limited {
+		return c.Send(tr.Tr("auth.codeTooMany"))
+	}
+
+	portals := b.portals.Names()
+	if portal, ok := b.startPortal(tgId); ok {
+		portals = []string{portal}
+	}
+	if portals = b.notRevoked(tgId, portals, now); len(portals) == 0 {
+		_, text := api.ErrorText(tr, api.ErrorRevoked)
+		return c.Send(text)
+	}
+	candidates := []codeCandidate{}
+	for _, portal := range portals {
+		bx, _ := b.portals.Get(portal)
+		u, err := bx.FindUserByLogin(login)
+		if err != nil {
+			if !errors.Is(err, api.ErrorUserNotFound) {
+				b.logger.Warn("login lookup", "portal", portal, "err", err.Error())
+			}
+			continue
+		}
+		acc := api.BxAccount{Portal: portal, BxId: int64(u.Id)}
+		if !b.allowCodeTarget(acc, now) { // Notifications of one user could not be flooded by several telegram users
+			b.logger.Warn("login code limit of account", "tgId", tgId, "portal", portal, "bxId", u.Id)
+			continue
+		}
+		code, err := generateCode()
+		if err != nil {
+			return b.sendError(c, err)
+		}
+		if err := bx.NotifyUser(u.Id, tr.Tr("auth.codeNotify", "code", code, "minutes", int(codeTTL.Minutes()))); err != nil {
+			b.logger.Warn("send login code", "portal", portal, "bxId", u.Id, "err", err.Error())
+			continue
+		}
+		candidates = append(candidates, codeCandidate{acc, code})
+	}
+	if len(candidates) == 0 {
+		b.authFailed(c, metrics.AuthByCode)
+	}
+
+	// Without candidates every code is wrong - the reply is the same
+	b.updateCodeLogin(tgId, func(state *codeLogin) {
+		state.stage = codeStageCode
+		state.candidates = candidates
+		state.expires = now.Add(codeTTL)
+		state.attempts = 0
+	})
+	b.logger.Info("login code requested", "tgId", tgId, "accounts", len(candidates))
+	return c.Send(tr.Tr("auth.codeSent", "minutes", int(codeTTL.Minutes())))
+}
+
+// Links account whose code is entered
+func (b *bot) onCodeEntered(c tele.Context, code string) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+	now := time.Now()
+
+	var (
+		acc     api.BxAccount
+		matched bool
+		expired bool
+		left    int
+	)
+	b.updateCodeLogin(tgId, func(state *codeLogin) {
+		if now.After(state.expires) {
+			state.stage = codeStageLogin
+			expired = true
+			return
+		}
+		for _, cand := range state.candidates {
+			if subtle.ConstantTimeCompare([]byte(code), []byte(cand.code)) == 1 {
+				acc, matched = cand.acc, true
+				*state = codeLogin{}
+				return
+			}
+		}
+		state.attempts++
+		if left = codeAttempts - state.attempts; left <= 0 {
+			state.stage = codeStageLogin
+			state.candidates = nil
+		}
+	})
+
+	switch {
+	case expired:
+		return c.Send(tr.Tr("auth.codeExpired"))
+	case !matched:
+		b.authFailed(c, metrics.AuthByCode)
+		if left <= 0 {
+			return c.Send(tr.Tr("auth.codeAttemptsOver"))
+		}
+		return c.Send(tr.Tr("auth.codeWrong", "left", left))
+	}
+
+	b.setStartPortal(tgId, "")
+	if b.sessions.Exist(tgId) { // Another account is linked
+		return b.linkAccountByCode(c, acc)
+	}
+	b.idStore.Set(tgId, acc)
+	if err := b.idStore.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	b.logger.Info("user linked by code", "tgId", tgId, "portal", acc.Portal, "bxId", acc.BxId)
+	if _, err := b.tryAuthById(c, metrics.AuthByCode); err != nil {
+		return b.sendError(c, fmt.Errorf("auth by id: %w", err))
+	}
+	if err := c.Send(tr.Tr("auth.success")); err != nil {
+		return fmt.Errorf("success authed msg send: %w", err)
+	}
+	return b.sessions.Get(tgId).OnStart(c)
+}
+
+// Counts code sent to the account, false if too many codes were sent to it recently
+func (b *bot) allowCodeTarget(acc api.BxAccount, now time.Time) bool {
+	b.codeMutex.Lock()
+	defer b.codeMutex.Unlock()
+	sent := recent(b.codeTargets[acc], now, codeSendWindow)
+	if len(sent) >= codeTargetLimit {
+		b.codeTargets[acc] = sent
+		return false
+	}
+	b.codeTargets[acc] = append(sent, now)
+	return true
+}
+
+// Drops states without recent codes - is called by scheduler
+func (b *bot) cleanupCodeLogins(now time.Time) {
+	b.codeMutex.Lock()
+	defer b.codeMutex.Unlock()
+	for tgId, state := range b.codeLogins {
+		if len(state.sent) == 0 || now.Sub(state.sent[len(state.sent)-1]) >= codeSendWindow { // Code has expired long ago
+			delete(b.codeLogins, tgId)
+		}
+	}
+	for acc, sent := range b.codeTargets {
+		if len(recent(sent, now, codeSendWindow)) == 0 {
+			delete(b.codeTargets, acc)
+		}
+	}
+}
+
+// Random decimal code
+func generateCode() (string, error) {
+	max := big.NewInt(1)
+	for range codeLen {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("generate code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", codeLen, n), nil
+}
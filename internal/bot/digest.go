@@ -0,0 +1,278 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/session"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Daily digest of overdue tasks and tasks due today and this week
+// Digest is built for the active account of the user
+// Scheduler checks every minute if digest time has come in user's time zone
+// Digest is sent once a day - date of the last one is kept in settings so restart does not send it again
+// Scheduled digests go through throttle of bulk messages, digest that is interrupted by stop is sent after restart
+// Empty digest is not sent
+
+const (
+	digestCheckInterval = time.Minute
+	digestBtnLimit      = 10        // Tasks that get complete buttons, the most urgent first
+	clockLayout         = "15:04"   // Digest time format
+	digestLate          = time.Hour // The first digest of user is skipped if it is later - it would come in the middle of the day after deploy or linking
+)
+
+// Buttons of /digest screen
+var (
+	digestToggleBtn = tele.Btn{Unique: "digestToggle"}
+	digestNowBtn    = tele.Btn{Unique: "digestNow"}
+)
+
+// Sends digests to users whose digest time has come - is called by scheduler
+func (b *bot) sendDigests(now time.Time) {
+	changed := false
+	b.idStore.Each(func(u api.LinkedUser) bool {
+		settings := b.settings.Get(u.TgId)
+		if settings.Digest.Off {
+			return true
+		}
+		local := now.In(b.zoneOf(settings))
+		today := local.Format(time.DateOnly)
+		if settings.Digest.LastSent == today || local.Format(clockLayout) < b.digestTimeOf(settings) {
+			return true
+		}
+
+		// Digest is marked as sent even on error - otherwise it would be retried every minute
+		if settings.Digest.LastSent != "" || !digestMissed(local, b.digestTimeOf(settings)) {
+			err := b.sendDigest(u.TgId, u.BxAccount, settings, local, false)
+			if errors.Is(err, errStopped) { // Is sent after restart
+				return false
+			}
+			if err != nil {
+				b.logger.Warn("send digest", "tgId", u.TgId, "err", err.Error())
+			}
+		}
+		b.settings.Update(u.TgId, func(settings *api.UserSettings) { // Settings could be changed while digest was sent
+			settings.Digest.LastSent = today
+		})
+		changed = true
+		return true
+	})
+	if changed {
+		b.settings.Save()
+	}
+}
+
+// Checks if digest time of the day passed more than digestLate ago
+func digestMissed(local time.Time, at string) bool {
+	late := local.Add(-digestLate)
+	return late.YearDay() == local.YearDay() && late.Format(clockLayout) >= at
+}
+
+// Sends digest to the user, empty digest is sent only on request
+func (b *bot) sendDigest(tgId int64, acc api.BxAccount, settings api.UserSettings, local time.Time, requested bool) error {
+	bxUser, err := b.authAccount(acc)
+	if err != nil {
+		return err
+	}
+	defer bxUser.Close()
+
+	// Bounds in user's time zone - week ends on sunday
+	y, m, d := local.Date()
+	todayEnd := time.Date(y, m, d+1, 0, 0, 0, 0, local.Location())
+	weekEnd := todayEnd.AddDate(0, 0, (7-int(local.Weekday()))%7)
+
+	tasks, err := bxUser.ListTasksDueBy(weekEnd)
+	if err != nil {
+		return err
+	}
+	view := screens.DigestView{}
+	for _, t := range tasks {
+		deadline := t.Deadline.In(local.Location())
+		task := screens.DigestTask{Title: t.Title, Deadline: deadline}
+		switch {
+		case deadline.Before(local):
+			view.Overdue = append(view.Overdue, task)
+		case deadline.Before(todayEnd):
+			view.Today = append(view.Today, task)
+		default:
+			view.Week = append(view.Week, task)
+		}
+	}
+	tr := b.catalog.Localizer(settings.Lang)
+	if len(tasks) == 0 {
+		if requested {
+			_, err := b.bot.Send(&tele.User{ID: tgId}, tr.Tr("digest.empty"))
+			return err
+		}
+		return nil
+	}
+
+	text, err := b.views.Render(tr, screens.Digest, view)
+	if err != nil {
+		return err
+	}
+	// Tasks are listed by deadline so overdue ones get buttons first
+	menu := &tele.ReplyMarkup{}
+	if b.roles.of(acc.Portal).resolve(bxUser.Get()).Can(api.ActionCompleteTask) {
+		rows := []tele.Row{}
+		for _, t := range tasks[:min(len(tasks), digestBtnLimit)] {
+			rows = append(rows, menu.Row(menu.Data("✅ "+t.Title, session.CompleteTaskBtn.Unique, t.Id.String())))
+		}
+		menu.Inline(rows...)
+	}
+	if !requested && !b.bulk.wait(b.done) { // Scheduled digests are bulk messages
+		return errStopped
+	}
+	_, err = b.bot.Send(&tele.User{ID: tgId}, text, menu)
+	return err
+}
+
+// Shows or changes digest settings
+// /digest [on|off|now|HH:MM|tz <zone>]
+func (b *bot) onDigest(c tele.Context) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+
+	var change func(settings *api.UserSettings)
+	args := strings.Fields(c.Message().Payload)
+	switch {
+	case len(args) == 0:
+		return b.showDigestSettings(c, b.settings.Get(tgId))
+	case args[0] == "on" || args[0] == "off":
+		change = func(settings *api.UserSettings) { settings.Digest.Off = args[0] == "off" }
+	case args[0] == "now":
+		return b.sendRequestedDigest(c, b.settings.Get(tgId))
+	case args[0] == "tz" && len(args) == 2:
+		if _, err := loadZone(args[1]); err != nil {
+			return c.Send(tr.Tr("digest.invalidZone", "zone", html.EscapeString(args[1])))
+		}
+		change = func(settings *api.UserSettings) { settings.TimeZone = args[1] }
+	default:
+		at, err := time.Parse(clockLayout, args[0])
+		if err != nil {
+			return c.Send(tr.Tr("digest.usage"))
+		}
+		change = func(settings *api.UserSettings) {
+			settings.Digest.Time = at.Format(clockLayout)
+			settings.Digest.Off = false
+			// Digest should come today if the new time has not passed yet
+			if local := time.Now().In(b.zoneOf(*settings)); local.Format(clockLayout) < settings.Digest.Time {
+				settings.Digest.LastSent = ""
+			}
+		}
+	}
+
+	settings := b.settings.Update(tgId, change)
+	if err := b.settings.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	return b.showDigestSettings(c, settings)
+}
+
+// Handles enable/disable button
+func (b *bot) onDigestToggle(c tele.Context) error {
+	settings := b.settings.Update(c.Sender().ID, func(settings *api.UserSettings) {
+		settings.Digest.Off = !settings.Digest.Off
+	})
+	if err := b.settings.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+	text, menu := b.digestSettingsScreen(b.tr(c), settings)
+	return c.Edit(text, menu)
+}
+
+// Handles send now button
+func (b *bot) onDigestNow(c tele.Context) error {
+	return b.sendRequestedDigest(c, b.settings.Get(c.Sender().ID))
+}
+
+func (b *bot) sendRequestedDigest(c tele.Context, settings api.UserSettings) error {
+	acc, ok := b.idStore.Get(c.Sender().ID)
+	if !ok { // Is not possible after auth, just to be sure
+		return b.sendError(c, api.ErrorUserNotFound)
+	}
+	if err := b.sendDigest(c.Sender().ID, acc, settings, time.Now().In(b.zoneOf(settings)), true); err != nil {
+		return b.sendError(c, err)
+	}
+	return nil
+}
+
+func (b *bot) showDigestSettings(c tele.Context, settings api.UserSettings) error {
+	text, menu := b.digestSettingsScreen(b.tr(c), settings)
+	return c.Send(text, menu)
+}
+
+func (b *bot) digestSettingsScreen(tr api.Localizer, settings api.UserSettings) (string, *tele.ReplyMarkup) {
+	zone := settings.TimeZone
+	if zone == "" {
+		zone = b.defaultZone.String()
+	}
+	text := tr.Tr("digest.on", "time", b.digestTimeOf(settings), "zone", html.EscapeString(zone))
+	toggleText := tr.Tr("digest.offBtn")
+	if settings.Digest.Off {
+		text = tr.Tr("digest.off", "zone", html.EscapeString(zone))
+		toggleText = tr.Tr("digest.onBtn")
+	}
+	text += "\n\n" + tr.Tr("digest.usage")
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data(toggleText, digestToggleBtn.Unique),
+		menu.Data(tr.Tr("digest.nowBtn"), digestNowBtn.Unique),
+	))
+	return text, menu
+}
+
+// Returns digest time of the user
+func (b *bot) digestTimeOf(settings api.UserSettings) string {
+	if settings.Digest.Time != "" {
+		return settings.Digest.Time
+	}
+	return b.digestTime
+}
+
+// Returns time zone of the user, default one if it is not set or is broken
+func (b *bot) zoneOf(settings api.UserSettings) *time.Location {
+	if settings.TimeZone == "" {
+		return b.defaultZone
+	}
+	loc, err := loadZone(settings.TimeZone)
+	if err != nil {
+		return b.defaultZone
+	}
+	return loc
+}
+
+// Loads time zone by IANA name(Europe/Moscow) or UTC offset(+3, +03:00, -05:30)
+func loadZone(name string) (*time.Location, error) {
+	if name == "" || (name[0] != '+' && name[0] != '-') {
+		if name == "Local" { // Server zone is not a user's one
+			return nil, fmt.Errorf("unknown time zone %s", name)
+		}
+		return time.LoadLocation(name)
+	}
+	hours, minutes, _ := strings.Cut(name[1:], ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || h > 14 {
+		return nil, fmt.Errorf("invalid utc offset %s", name)
+	}
+	m := 0
+	if minutes != "" {
+		if m, err = strconv.Atoi(minutes); err != nil || m >= 60 {
+			return nil, fmt.Errorf("invalid utc offset %s", name)
+		}
+	}
+	offset := h*3600 + m*60
+	if name[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("UTC"+name, offset), nil
+}
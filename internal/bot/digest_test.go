@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+func TestDigestMissed(t *testing.T) {
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		local time.Time
+		at    string
+		want  bool
+	}{
+		{"on time", day.Add(9 * time.Hour), "09:00", false},
+		{"less than hour late", day.Add(9*time.Hour + 59*time.Minute), "09:00", false},
+		{"hour late", day.Add(10 * time.Hour), "09:00", true},
+		{"evening", day.Add(20 * time.Hour), "09:00", true},
+		{"late digest after midnight", day.Add(30 * time.Minute), "00:00", false},
+		{"before digest time", day.Add(8 * time.Hour), "09:00", false},
+	}
+	for _, tt := range tests {
+		if got := digestMissed(tt.local, tt.at); got != tt.want {
+			t.Errorf("%s: digestMissed(%s, %s) = %v, want %v", tt.name, tt.local.Format(clockLayout), tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestSettingsUpdateKeepsOtherFields(t *testing.T) {
+	s := NewJsonUserSettingsStore(slog.Default(), filepath.Join(t.TempDir(), "settings.json"))
+	s.Set(1, api.UserSettings{Lang: "en"})
+	// Stale copy must not be written back
+	s.Update(1, func(settings *api.UserSettings) { settings.Digest.Off = true })
+	got := s.Update(1, func(settings *api.UserSettings) { settings.Digest.LastSent = "2024-05-10" })
+	if got.Lang != "en" || !got.Digest.Off || got.Digest.LastSent != "2024-05-10" {
+		t.Errorf("got %+v", got)
+	}
+	if stored := s.Get(1); stored.Digest != got.Digest {
+		t.Errorf("Get() = %+v, want %+v", stored, got)
+	}
+}
@@ -0,0 +1,286 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/session"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Notifications from bitrix outbound events
+// Bitrix posts form with event name, data[FIELDS_AFTER][ID] or data[FIELDS][ID] and auth[application_token]
+// Event contains only id so the entity is requested by webhook user, affected bitrix user is mapped to telegram ids by id store
+// Changes made by the user himself or through the bot(by webhook user) are not notified
+// Every portal posts to its own path(/bitrix/events/<portal>, the default one - /bitrix/events) with its own token
+
+const eventsPath = "/bitrix/events"
+
+// Handled events
+const (
+	eventTaskAdd    = "ONTASKADD"
+	eventTaskUpdate = "ONTASKUPDATE"
+	eventDealUpdate = "ONCRMDEALUPDATE"
+	eventCommentAdd = "ONCRMTIMELINECOMMENTADD"
+)
+
+const eventCommentLimit = 500 // Comment is cut in notification if it is longer
+
+const shutdownTimeout = 10 * time.Second
+
+// Starts http server for bitrix events in background
+func (b *bot) startEvents() *http.Server {
+	srv := &http.Server{
+		Addr:              b.eventsAddr,
+		Handler:           b.eventsHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		b.logger.Info("events server started", "addr", b.eventsAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.logger.Error("events server: " + err.Error())
+		}
+	}()
+	return srv
+}
+
+// Routes of portals' events
+func (b *bot) eventsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(eventsPath, b.onBxEvent)
+	mux.HandleFunc(eventsPath+"/{portal}", b.onBxEvent)
+	return mux
+}
+
+// Stops http server and waits for running requests
+func shutdownServer(logger *slog.Logger, srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("shutdown http server", "addr", srv.Addr, "err", err.Error())
+	}
+}
+
+// Checks event and handles it after response - bitrix does not wait long
+func (b *bot) onBxEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	portal := r.PathValue("portal")
+	bx, ok := b.portals.Get(portal)
+	expected := b.eventsTokens[portal]
+	if !ok || expected == "" {
+		http.Error(w, "unknown portal", http.StatusNotFound)
+		return
+	}
+	token := r.PostForm.Get("auth[application_token]")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		b.logger.Warn("bitrix event with invalid application token", "portal", portal, "remote", r.RemoteAddr)
+		http.Error(w, "invalid application token", http.StatusForbidden)
+		return
+	}
+
+	event := strings.ToUpper(r.PostForm.Get("event"))
+	id, err := eventEntityId(r.PostForm)
+	if err != nil {
+		b.logger.Warn("bitrix event without entity id", "portal", portal, "event", event)
+		http.Error(w, "invalid entity id", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	b.eventsWg.Add(1)
+	go func() {
+		defer b.eventsWg.Done()
+		if err := b.handleBxEvent(eventSource{portal, bx}, event, id); err != nil {
+			b.logger.Warn("handle bitrix event", "portal", portal, "event", event, "id", id, "err", err.Error())
+		}
+	}()
+}
+
+// Id of the event entity - tasks events send it in FIELDS_AFTER, crm ones in FIELDS
+func eventEntityId(form url.Values) (bxtypes.Id, error) {
+	str := form.Get("data[FIELDS_AFTER][ID]")
+	if str == "" {
+		str = form.Get("data[FIELDS][ID]")
+	}
+	id, err := strconv.Atoi(str)
+	return bxtypes.Id(id), err
+}
+
+// Portal the event came from
+type eventSource struct {
+	portal string
+	bx     api.BxWrapper
+}
+
+// Account of the portal user
+func (src eventSource) account(id bxtypes.Id) api.BxAccount {
+	return api.BxAccount{Portal: src.portal, BxId: int64(id)}
+}
+
+func (b *bot) handleBxEvent(src eventSource, event string, id bxtypes.Id) error {
+	b.logger.Debug("bitrix event", "portal", src.portal, "event", event, "id", id)
+	switch event {
+	case eventTaskAdd:
+		return b.notifyTask(src, id, true)
+	case eventTaskUpdate:
+		return b.notifyTask(src, id, false)
+	case eventDealUpdate:
+		return b.notifyDeal(src, id)
+	case eventCommentAdd:
+		return b.notifyComment(src, id)
+	}
+	b.logger.Debug("unknown bitrix event", "event", event)
+	return nil
+}
+
+// Notifies task responsible about added or changed task
+func (b *bot) notifyTask(src eventSource, taskId bxtypes.Id, added bool) error {
+	task, err := src.bx.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	author := task.ChangedBy
+	if added {
+		author = task.CreatedBy
+	}
+	if author == task.ResponsibleId || author == src.bx.HookUserId() {
+		return nil
+	}
+
+	view := screens.TaskEventView{
+		New:       added,
+		Completed: task.Status == bxtypes.TaskStateCompleted,
+		Task:      task,
+		Author:    b.bxUserName(src.portal, author),
+	}
+	if dealId := task.DealId(); dealId != 0 {
+		// Task is notified even if its deal could not be loaded - just without deal button
+		if deal, err := src.bx.GetDeal(dealId); err != nil {
+			b.logger.Warn("task event load deal", "portal", src.portal, "taskId", task.Id, "dealId", dealId, "err", err.Error())
+		} else {
+			view.Deal = &deal
+		}
+	}
+
+	return b.notify(src.account(task.ResponsibleId), func(tr api.Localizer) (string, *tele.ReplyMarkup, error) {
+		text, err := b.views.Render(tr, screens.TaskEvent, view)
+		menu := &tele.ReplyMarkup{}
+		row := tele.Row{}
+		if !view.Completed {
+			row = append(row, menu.Data(tr.Tr("notify.completeBtn"), session.CompleteTaskBtn.Unique, task.Id.String()))
+		}
+		if view.Deal != nil {
+			row = append(row, menu.Data(tr.Tr("notify.openDealBtn"), session.OpenDealBtn.Unique, view.Deal.Id.String()))
+		}
+		if len(row) > 0 {
+			menu.Inline(row)
+		}
+		return text, menu, err
+	})
+}
+
+// Notifies deal assignee about changes made by others
+func (b *bot) notifyDeal(src eventSource, dealId bxtypes.Id) error {
+	deal, err := src.bx.GetDeal(dealId)
+	if err != nil {
+		return err
+	}
+	if deal.ModifiedBy == deal.AssignedId || deal.ModifiedBy == src.bx.HookUserId() {
+		return nil
+	}
+	author := b.bxUserName(src.portal, deal.ModifiedBy)
+
+	return b.notify(src.account(deal.AssignedId), func(tr api.Localizer) (string, *tele.ReplyMarkup, error) {
+		text, err := b.views.Render(tr, screens.DealEvent, screens.DealEventView{
+			Deal:   deal,
+			Stage:  session.StageText(tr, deal.StageId),
+			Author: author,
+		})
+		return text, openDealMenu(tr, deal.Id), err
+	})
+}
+
+// Notifies deal assignee about comments of others
+func (b *bot) notifyComment(src eventSource, commentId bxtypes.Id) error {
+	comment, err := src.bx.GetTimelineComment(commentId)
+	if err != nil {
+		return err
+	}
+	if comment.EntityType != "deal" {
+		return nil
+	}
+	deal, err := src.bx.GetDeal(comment.EntityId)
+	if err != nil {
+		return err
+	}
+	if comment.AuthorId == deal.AssignedId || comment.AuthorId == src.bx.HookUserId() { // Comments of the bot are made by webhook user
+		return nil
+	}
+	text := []rune(comment.Comment)
+	if len(text) > eventCommentLimit {
+		text = append(text[:eventCommentLimit], '…')
+	}
+	author := b.bxUserName(src.portal, comment.AuthorId)
+
+	return b.notify(src.account(deal.AssignedId), func(tr api.Localizer) (string, *tele.ReplyMarkup, error) {
+		msg, err := b.views.Render(tr, screens.CommentEvent, screens.CommentEventView{
+			Deal:    deal,
+			Author:  author,
+			Comment: string(text),
+		})
+		return msg, openDealMenu(tr, deal.Id), err
+	})
+}
+
+// Sends notification to all telegram accounts of bitrix user in their languages
+// Users that have switched to other bitrix account are skipped - buttons work with the active one
+func (b *bot) notify(acc api.BxAccount, render func(tr api.Localizer) (string, *tele.ReplyMarkup, error)) error {
+	for _, tgId := range b.idStore.GetByBxId(acc) {
+		if active, _ := b.idStore.Get(tgId); active != acc {
+			continue
+		}
+		text, menu, err := render(b.catalog.Localizer(b.settings.Get(tgId).Lang))
+		if err != nil {
+			return err
+		}
+		// User could have blocked the bot so error is not critical
+		if _, err := b.bot.Send(&tele.User{ID: tgId}, text, menu); err != nil {
+			b.logger.Debug("send notification", "tgId", tgId, "err", err.Error())
+		}
+	}
+	return nil
+}
+
+func openDealMenu(tr api.Localizer, dealId bxtypes.Id) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data(tr.Tr("notify.openDealBtn"), session.OpenDealBtn.Unique, dealId.String())))
+	return menu
+}
+
+// Full name of bitrix user, id if user could not be loaded
+func (b *bot) bxUserName(portal string, id bxtypes.Id) string {
+	u, err := b.authAccount(api.BxAccount{Portal: portal, BxId: int64(id)})
+	if err != nil {
+		return id.String()
+	}
+	defer u.Close()
+	return strings.Join(strings.Fields(u.Get().Name+" "+u.Get().LastName), " ")
+}
@@ -0,0 +1,273 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/i18n"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	testEventsToken = "secret"
+	testHookUser    = bxtypes.Id(1)
+)
+
+// Portal with tasks and deals of events, other methods are not used by events
+type fakeEventsBx struct {
+	api.BxWrapper
+	tasks map[bxtypes.Id]bxtypes.Task
+	deals map[bxtypes.Id]bxtypes.Deal
+}
+
+func (bx *fakeEventsBx) GetTask(id bxtypes.Id) (bxtypes.Task, error) {
+	if task, ok := bx.tasks[id]; ok {
+		return task, nil
+	}
+	return bxtypes.Task{}, errors.New("task not found")
+}
+
+func (bx *fakeEventsBx) GetDeal(id bxtypes.Id) (bxtypes.Deal, error) {
+	if deal, ok := bx.deals[id]; ok {
+		return deal, nil
+	}
+	return bxtypes.Deal{}, errors.New("deal not found")
+}
+
+func (bx *fakeEventsBx) AuthUserById(id bxtypes.Id) (api.BxUser, error) {
+	return nil, errors.New("users are not loaded in test") // Authors are shown by ids
+}
+
+func (bx *fakeEventsBx) HookUserId() bxtypes.Id { return testHookUser }
+
+type fakePortals map[string]api.BxWrapper
+
+func (p fakePortals) Get(portal string) (api.BxWrapper, bool) {
+	bx, ok := p[portal]
+	return bx, ok
+}
+
+func (p fakePortals) Names() []string { return []string{""} }
+func (p fakePortals) Close() error    { return nil }
+
+// Message sent through fake telegram api
+type sentMessage struct {
+	ChatId      string `json:"chat_id"`
+	Text        string `json:"text"`
+	ReplyMarkup string `json:"reply_markup"`
+}
+
+type eventsTest struct {
+	b  *bot
+	bx *fakeEventsBx
+
+	mutex sync.Mutex
+	sent  []sentMessage
+}
+
+// Bot with the default portal and "north" one without events token, telegram api is faked by test server
+func newEventsTest(t *testing.T) *eventsTest {
+	et := &eventsTest{bx: &fakeEventsBx{tasks: map[bxtypes.Id]bxtypes.Task{}, deals: map[bxtypes.Id]bxtypes.Deal{}}}
+	tg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := sentMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("telegram request: %s", err)
+		}
+		et.mutex.Lock()
+		et.sent = append(et.sent, msg)
+		et.mutex.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":1,"type":"private"}}}`))
+	}))
+	t.Cleanup(tg.Close)
+
+	telebot, err := tele.NewBot(tele.Settings{Token: "TOKEN", URL: tg.URL, Offline: true, ParseMode: tele.ModeHTML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	views, err := screens.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := i18n.Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	et.b = &bot{
+		logger:       slog.Default(),
+		bot:          telebot,
+		portals:      fakePortals{"": et.bx, "north": et.bx},
+		idStore:      NewJsonUsersIdStore(slog.Default(), filepath.Join(dir, "users.json")),
+		settings:     NewJsonUserSettingsStore(slog.Default(), filepath.Join(dir, "settings.json")),
+		catalog:      catalog,
+		views:        views,
+		eventsTokens: map[string]string{"": testEventsToken},
+	}
+	return et
+}
+
+// Posts event and waits until it is handled
+func (et *eventsTest) post(t *testing.T, srv *httptest.Server, path string, form url.Values) int {
+	resp, err := http.PostForm(srv.URL+path, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	et.b.eventsWg.Wait()
+	return resp.StatusCode
+}
+
+// Returns messages sent since the previous call
+func (et *eventsTest) takeSent() []sentMessage {
+	et.mutex.Lock()
+	defer et.mutex.Unlock()
+	sent := et.sent
+	et.sent = nil
+	return sent
+}
+
+func eventForm(token, event, id string) url.Values {
+	form := url.Values{}
+	form.Set("event", event)
+	form.Set("data[FIELDS_AFTER][ID]", id)
+	if token != "" {
+		form.Set("auth[application_token]", token)
+	}
+	return form
+}
+
+func TestBxEventRequest(t *testing.T) {
+	et := newEventsTest(t)
+	et.bx.tasks[5] = bxtypes.Task{Id: 5, Title: "Call", ResponsibleId: 10, CreatedBy: 11}
+	et.b.idStore.Set(100, api.BxAccount{BxId: 10})
+	srv := httptest.NewServer(et.b.eventsHandler())
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		path string
+		form url.Values
+		want int
+	}{
+		{"unknown portal", eventsPath + "/west", eventForm(testEventsToken, eventTaskAdd, "5"), http.StatusNotFound},
+		{"portal without token", eventsPath + "/north", eventForm(testEventsToken, eventTaskAdd, "5"), http.StatusNotFound},
+		{"empty token", eventsPath, eventForm("", eventTaskAdd, "5"), http.StatusForbidden},
+		{"wrong token", eventsPath, eventForm("guess", eventTaskAdd, "5"), http.StatusForbidden},
+		{"token prefix", eventsPath, eventForm(testEventsToken[:3], eventTaskAdd, "5"), http.StatusForbidden},
+		{"no entity id", eventsPath, eventForm(testEventsToken, eventTaskAdd, ""), http.StatusBadRequest},
+		{"invalid entity id", eventsPath, eventForm(testEventsToken, eventTaskAdd, "5abc"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := et.post(t, srv, tt.path, tt.form); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+		if sent := et.takeSent(); len(sent) != 0 {
+			t.Errorf("%s: rejected event is notified: %v", tt.name, sent)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + eventsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("get: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	if got := et.post(t, srv, eventsPath, eventForm(testEventsToken, strings.ToLower(eventTaskAdd), "5")); got != http.StatusOK {
+		t.Fatalf("valid event: status %d", got)
+	}
+	if sent := et.takeSent(); len(sent) != 1 || sent[0].ChatId != "100" {
+		t.Errorf("valid event is sent as %v, want one message to 100", sent)
+	}
+}
+
+func TestEventEntityId(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   bxtypes.Id
+		ok     bool
+	}{
+		{"task event", map[string]string{"data[FIELDS_AFTER][ID]": "12"}, 12, true},
+		{"crm event", map[string]string{"data[FIELDS][ID]": "34"}, 34, true},
+		{"task fields go first", map[string]string{"data[FIELDS_AFTER][ID]": "12", "data[FIELDS][ID]": "34"}, 12, true},
+		{"no id", map[string]string{"data[FIELDS_BEFORE][ID]": "12"}, 0, false},
+		{"not a number", map[string]string{"data[FIELDS][ID]": "D_12"}, 0, false},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		for k, v := range tt.fields {
+			form.Set(k, v)
+		}
+		got, err := eventEntityId(form)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("%s: eventEntityId() = %d, %v, want %d, ok %v", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNotifyTask(t *testing.T) {
+	et := newEventsTest(t)
+	src := eventSource{"", et.bx}
+	et.b.idStore.Set(100, api.BxAccount{BxId: 10})
+	et.b.idStore.Set(101, api.BxAccount{BxId: 10})
+	et.b.idStore.Set(101, api.BxAccount{Portal: "north", BxId: 10}) // Has switched to other portal
+	et.bx.deals[7] = bxtypes.Deal{Id: 7, Title: "Supply"}
+
+	tests := []struct {
+		name     string
+		task     bxtypes.Task
+		added    bool
+		sent     bool
+		dealBtn  bool
+		complete bool
+	}{
+		{"added by other", bxtypes.Task{CreatedBy: 11, ChangedBy: 10}, true, true, false, true},
+		{"added by responsible", bxtypes.Task{CreatedBy: 10, ChangedBy: 11}, true, false, false, false},
+		{"changed by other", bxtypes.Task{CreatedBy: 10, ChangedBy: 11}, false, true, false, true},
+		{"changed by responsible", bxtypes.Task{CreatedBy: 11, ChangedBy: 10}, false, false, false, false},
+		{"changed through bot", bxtypes.Task{CreatedBy: 11, ChangedBy: testHookUser}, false, false, false, false},
+		{"completed", bxtypes.Task{ChangedBy: 11, Status: bxtypes.TaskStateCompleted}, false, true, false, false},
+		{"with deal", bxtypes.Task{ChangedBy: 11, Crm: bxtypes.StrList{"D_7"}}, false, true, true, true},
+		{"deal is not loaded", bxtypes.Task{ChangedBy: 11, Crm: bxtypes.StrList{"D_8"}}, false, true, false, true},
+	}
+	for _, tt := range tests {
+		tt.task.Id, tt.task.Title, tt.task.ResponsibleId = 5, "Call", 10
+		et.bx.tasks[5] = tt.task
+		if err := et.b.notifyTask(src, 5, tt.added); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		sent := et.takeSent()
+		if !tt.sent {
+			if len(sent) != 0 {
+				t.Errorf("%s: notification is sent: %v", tt.name, sent)
+			}
+			continue
+		}
+		if len(sent) != 1 || sent[0].ChatId != "100" { // 101 has other active account
+			t.Errorf("%s: sent %v, want one message to 100", tt.name, sent)
+			continue
+		}
+		if got := strings.Contains(sent[0].ReplyMarkup, "openDeal"); got != tt.dealBtn {
+			t.Errorf("%s: deal button %v, want %v: %s", tt.name, got, tt.dealBtn, sent[0].ReplyMarkup)
+		}
+		if got := strings.Contains(sent[0].ReplyMarkup, "completeTask"); got != tt.complete {
+			t.Errorf("%s: complete button %v, want %v: %s", tt.name, got, tt.complete, sent[0].ReplyMarkup)
+		}
+	}
+}
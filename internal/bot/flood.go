@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Flood control of users' updates
+// Every user has token bucket, updates without token are dropped
+// Handlers of one user and timeouts of his session are run one by one - screens share prevMsg and tagged vars of the session
+// Callback that duplicates a waiting or running one is dropped, user sees busy toast instead
+// Telegram 429 responses are retried after retry_after by http transport of telebot
+
+const (
+	floodBurst    = 5                      // Updates that could be sent at once
+	floodRate     = 1.0                    // Tokens per second
+	busyDelay     = 500 * time.Millisecond // Typing indicator is shown if handler takes longer
+	floodCleanup  = 10 * time.Minute
+	retryAttempts = 2                // Retries of one telegram request
+	retryMaxWait  = 30 * time.Second // Longer retry_after is not waited - error is returned
+)
+
+type floodVerdict int
+
+const (
+	floodAllowed   = floodVerdict(iota)
+	floodLimited   // No tokens left
+	floodDuplicate // The same callback is waiting or running
+)
+
+// Flood state of one user
+type userFlood struct {
+	tokens  float64
+	updated time.Time
+	lock    sync.Mutex      // Is held while handler runs
+	pending map[string]bool // Callbacks that wait or run
+	waiting int             // Updates that wait or run - user is not cleaned up while they exist
+}
+
+type floodControl struct {
+	mutex sync.Mutex
+	users map[int64]*userFlood
+}
+
+func newFloodControl() *floodControl {
+	return &floodControl{users: map[int64]*userFlood{}}
+}
+
+// Takes token and registers update, key is callback data(empty for messages)
+func (f *floodControl) enter(tgId int64, key string, now time.Time) (*userFlood, floodVerdict) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	u := f.user(tgId, now)
+	if key != "" && u.pending[key] {
+		return u, floodDuplicate
+	}
+	u.tokens = min(floodBurst, u.tokens+now.Sub(u.updated).Seconds()*floodRate)
+	u.updated = now
+	if u.tokens < 1 {
+		return u, floodLimited
+	}
+	u.tokens--
+	u.waiting++
+	if key != "" {
+		u.pending[key] = true
+	}
+	return u, floodAllowed
+}
+
+// Runs fn between handlers of the user - session timeouts change the same state as handlers
+// It is not user's update so no tokens are taken
+func (f *floodControl) serialize(tgId int64, fn func()) {
+	f.mutex.Lock()
+	u := f.user(tgId, time.Now())
+	u.waiting++
+	f.mutex.Unlock()
+	defer f.leave(u, "")
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	fn()
+}
+
+// Returns flood state of the user, is called under mutex
+func (f *floodControl) user(tgId int64, now time.Time) *userFlood {
+	u := f.users[tgId]
+	if u == nil {
+		u = &userFlood{tokens: floodBurst, updated: now, pending: map[string]bool{}}
+		f.users[tgId] = u
+	}
+	return u
+}
+
+// Unregisters finished update
+func (f *floodControl) leave(u *userFlood, key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	u.waiting--
+	delete(u.pending, key)
+}
+
+// Drops idle users with full buckets - is called by scheduler
+func (f *floodControl) cleanup(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for tgId, u := range f.users {
+		if u.waiting == 0 && now.Sub(u.updated).Seconds()*floodRate >= floodBurst {
+			delete(f.users, tgId)
+		}
+	}
+}
+
+// Key of context value that marks update which already passed flood control
+// Trigger calls handlers with the same context - they must not wait for the lock held by the caller
+const floodPassedKey = "floodPassed"
+
+// Limits rate of user's updates and serializes his handlers
+func (b *bot) floodMiddle(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Get(floodPassedKey) != nil {
+			return next(c)
+		}
+		key := ""
+		if cb := c.Callback(); cb != nil {
+			key = cb.Unique + "|" + cb.Data
+		}
+		u, verdict := b.flood.enter(c.Sender().ID, key, time.Now())
+		switch verdict {
+		case floodLimited:
+			b.logger.Debug("flood limit", "tgId", c.Sender().ID)
+			if c.Callback() != nil {
+				return c.Respond(&tele.CallbackResponse{Text: b.tr(c).Tr("common.tooFast")})
+			}
+			return nil // Answer to every message would be flood too
+		case floodDuplicate:
+			return c.Respond(&tele.CallbackResponse{Text: b.tr(c).Tr("common.busy")})
+		}
+		defer b.flood.leave(u, key)
+
+		u.lock.Lock()
+		defer u.lock.Unlock()
+		c.Set(floodPassedKey, true)
+		busy := time.AfterFunc(busyDelay, func() {
+			c.Notify(tele.Typing)
+		})
+		defer busy.Stop()
+		return next(c)
+	}
+}
+
+// Http transport that retries telegram requests after retry_after of 429 response
+type retryTransport struct {
+	logger *slog.Logger
+	base   http.RoundTripper
+}
+
+func newRetryTransport(logger *slog.Logger) *retryTransport {
+	return &retryTransport{logger: logger, base: http.DefaultTransport}
+}
+
+// Failed requests are counted in metrics after retries
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	method := path.Base(req.URL.Path) // Url is /bot<token>/<method>
+	switch {
+	case err != nil:
+		metrics.TelegramError(method, "network")
+	case resp.StatusCode >= http.StatusBadRequest:
+		metrics.TelegramError(method, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == retryAttempts || req.GetBody == nil {
+			return resp, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data)) // Is returned if request is not retried
+
+		wait, ok := retryAfter(resp, data)
+		if !ok || wait > retryMaxWait {
+			return resp, nil
+		}
+		// Logged with debug level - tg logs of warnings would hit the limit too
+		t.logger.Debug("telegram flood limit", "retryAfter", wait.String(), "attempt", attempt+1)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context()) // Round tripper must not modify the request
+		req.Body = body
+	}
+}
+
+// Wait time from response parameters or Retry-After header
+func retryAfter(resp *http.Response, data []byte) (time.Duration, bool) {
+	body := struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}{}
+	if json.Unmarshal(data, &body) == nil && body.Parameters.RetryAfter > 0 {
+		return time.Duration(body.Parameters.RetryAfter) * time.Second, true
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
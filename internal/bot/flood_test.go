@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFloodEnterBucket(t *testing.T) {
+	f := newFloodControl()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < floodBurst; i++ {
+		u, verdict := f.enter(1, "", now)
+		if verdict != floodAllowed {
+			t.Fatalf("update %d is rejected: %v", i, verdict)
+		}
+		f.leave(u, "")
+	}
+	if _, verdict := f.enter(1, "", now); verdict != floodLimited {
+		t.Errorf("update over burst = %v, want limited", verdict)
+	}
+	if _, verdict := f.enter(2, "", now); verdict != floodAllowed {
+		t.Errorf("other user is limited: %v", verdict)
+	}
+	// One token is refilled per second
+	u, verdict := f.enter(1, "", now.Add(time.Second))
+	if verdict != floodAllowed {
+		t.Errorf("update after refill = %v, want allowed", verdict)
+	}
+	f.leave(u, "")
+	if _, verdict := f.enter(1, "", now.Add(time.Second)); verdict != floodLimited {
+		t.Errorf("the second update after refill of one token = %v, want limited", verdict)
+	}
+}
+
+func TestFloodEnterDuplicate(t *testing.T) {
+	f := newFloodControl()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	u, _ := f.enter(1, "openDeal|5", now)
+	if _, verdict := f.enter(1, "openDeal|5", now); verdict != floodDuplicate {
+		t.Errorf("the same callback = %v, want duplicate", verdict)
+	}
+	other, verdict := f.enter(1, "openDeal|6", now)
+	if verdict != floodAllowed {
+		t.Errorf("other callback = %v, want allowed", verdict)
+	}
+	f.leave(other, "openDeal|6")
+	f.leave(u, "openDeal|5")
+	if _, verdict := f.enter(1, "openDeal|5", now); verdict != floodAllowed {
+		t.Errorf("callback after the first one finished = %v, want allowed", verdict)
+	}
+}
+
+func TestFloodCleanup(t *testing.T) {
+	f := newFloodControl()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	idle, _ := f.enter(1, "", now)
+	f.leave(idle, "")
+	f.enter(2, "", now) // Is still running
+
+	f.cleanup(now.Add(time.Second)) // Bucket of idle user is not full yet
+	if len(f.users) != 2 {
+		t.Errorf("users are dropped before buckets are full: %d left", len(f.users))
+	}
+	f.cleanup(now.Add(time.Hour))
+	if _, ok := f.users[1]; ok {
+		t.Error("idle user is not dropped")
+	}
+	if _, ok := f.users[2]; !ok {
+		t.Error("user with running update is dropped")
+	}
+}
+
+func TestFloodSerialize(t *testing.T) {
+	f := newFloodControl()
+	u, _ := f.enter(1, "", time.Now())
+	u.lock.Lock() // Handler is running
+
+	done := make(chan struct{})
+	go func() {
+		f.serialize(1, func() {})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("serialized function runs together with handler")
+	case <-time.After(20 * time.Millisecond):
+	}
+	u.lock.Unlock()
+	f.leave(u, "")
+	<-done
+
+	// Functions of one user do not overlap
+	running := atomic.Int32{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.serialize(1, func() {
+				if running.Add(1) > 1 {
+					t.Error("serialized functions overlap")
+				}
+				time.Sleep(time.Millisecond)
+				running.Add(-1)
+			})
+		}()
+	}
+	wg.Wait()
+	if u := f.users[1]; u.waiting != 0 {
+		t.Errorf("%d updates are left waiting", u.waiting)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"parameters", `{"ok":false,"error_code":429,"parameters":{"retry_after":3}}`, "", 3 * time.Second, true},
+		{"parameters first", `{"parameters":{"retry_after":3}}`, "7", 3 * time.Second, true},
+		{"header", `{"ok":false}`, "7", 7 * time.Second, true},
+		{"header with broken body", `<html>`, "2", 2 * time.Second, true},
+		{"nothing", `{"ok":false}`, "", 0, false},
+		{"invalid header", ``, "soon", 0, false},
+		{"zero", `{"parameters":{"retry_after":0}}`, "0", 0, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		got, ok := retryAfter(resp, []byte(tt.body))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: retryAfter() = %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	calls := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("retried request body = %q", body)
+		}
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newRetryTransport(slog.Default())}
+	resp, err := client.Post(srv.URL+"/botTOKEN/sendMessage", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("status %d after %d calls, want 200 after 2", resp.StatusCode, calls.Load())
+	}
+}
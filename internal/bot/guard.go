@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Protection of auth path - every message of unknown sender could end in bitrix requests
+// Auth attempts are limited per telegram user and globally, repeated failures ban the user for a while
+// Phones that were not found are cached, so sharing the same contact again does not reach bitrix
+// Linked users(auth by id after restart) are limited only per user - restart must not lock everybody out by global limit
+// Admins get warnings through tg logs when limits trip
+// All the state is in memory only - restart lifts bans and forgets unknown phones, it is accepted because both are short
+
+const (
+	authWindow       = time.Minute
+	authUserLimit    = 10  // Auth attempts of one telegram user per authWindow
+	authGlobalLimit  = 100 // Auth attempts of all users per authWindow
+	authFailLimit    = 5   // Failures per authFailWindow before ban
+	authFailWindow   = time.Hour
+	authBanDuration  = time.Hour
+	unknownPhonesTTL = 10 * time.Minute
+	guardCleanup     = 10 * time.Minute
+)
+
+type guardVerdict int
+
+const (
+	guardAllowed       = guardVerdict(iota)
+	guardUserLimited   // Too many attempts of the user
+	guardGlobalLimited // Too many attempts of all users
+	guardBanned        // User is banned for failures
+)
+
+type authGuard struct {
+	mutex         sync.Mutex
+	attempts      map[int64][]time.Time // Attempts of users in the current authWindow
+	global        []time.Time
+	failures      map[int64][]time.Time
+	bans          map[int64]time.Time  // Until
+	notified      map[int64]bool       // Users that were told about limit - the next rejects are silent
+	globalAlerted time.Time            // When admins were alerted about global limit
+	unknownPhones map[string]time.Time // Until
+}
+
+func newAuthGuard() *authGuard {
+	return &authGuard{
+		attempts:      map[int64][]time.Time{},
+		failures:      map[int64][]time.Time{},
+		bans:          map[int64]time.Time{},
+		notified:      map[int64]bool{},
+		unknownPhones: map[string]time.Time{},
+	}
+}
+
+// Drops times that are older than window
+func recent(times []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= window {
+		i++
+	}
+	return times[i:]
+}
+
+// Counts auth attempt if it is allowed, attempts of linked users are not counted in global limit
+// Notify is true for the first reject - user is told about it once
+func (g *authGuard) allow(tgId int64, linked bool, now time.Time) (verdict guardVerdict, notify bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if until, ok := g.bans[tgId]; ok {
+		if now.Before(until) {
+			return guardBanned, false // User was told on ban
+		}
+		delete(g.bans, tgId)
+	}
+	g.attempts[tgId] = recent(g.attempts[tgId], now, authWindow)
+	g.global = recent(g.global, now, authWindow)
+	switch {
+	case len(g.attempts[tgId]) >= authUserLimit:
+		verdict = guardUserLimited
+	case !linked && len(g.global) >= authGlobalLimit:
+		verdict = guardGlobalLimited
+	default:
+		delete(g.notified, tgId)
+		g.attempts[tgId] = append(g.attempts[tgId], now)
+		if !linked {
+			g.global = append(g.global, now)
+		}
+		return guardAllowed, false
+	}
+	notify = !g.notified[tgId]
+	g.notified[tgId] = true
+	return verdict, notify
+}
+
+// Returns true once per authWindow - admins are not flooded with global limit alerts
+func (g *authGuard) alertGlobal(now time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if now.Sub(g.globalAlerted) < authWindow {
+		return false
+	}
+	g.globalAlerted = now
+	return true
+}
+
+// Counts failed attempt, returns true if the user is banned because of it
+func (g *authGuard) fail(tgId int64, now time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	failures := append(recent(g.failures[tgId], now, authFailWindow), now)
+	if len(failures) < authFailLimit {
+		g.failures[tgId] = failures
+		return false
+	}
+	delete(g.failures, tgId)
+	g.bans[tgId] = now.Add(authBanDuration)
+	return true
+}
+
+// Forgets failures of successfully authorized user
+func (g *authGuard) success(tgId int64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.failures, tgId)
+	delete(g.attempts, tgId)
+	delete(g.notified, tgId)
+}
+
+// Key of phone lookup - phone could be unknown in one portal and known in another
+func unknownPhoneKey(phone string, portals []string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	return strings.Join(portals, ",") + ":" + digits
+}
+
+func (g *authGuard) isUnknownPhone(key string, now time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	until, ok := g.unknownPhones[key]
+	return ok && now.Before(until)
+}
+
+func (g *authGuard) rememberUnknownPhone(key string, now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.unknownPhones[key] = now.Add(unknownPhonesTTL)
+}
+
+// Drops outdated records - is called by scheduler
+func (g *authGuard) cleanup(now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for tgId, times := range g.attempts {
+		if len(recent(times, now, authWindow)) == 0 {
+			delete(g.attempts, tgId)
+			delete(g.notified, tgId)
+		}
+	}
+	for tgId, times := range g.failures {
+		if len(recent(times, now, authFailWindow)) == 0 {
+			delete(g.failures, tgId)
+		}
+	}
+	for tgId, until := range g.bans {
+		if !now.Before(until) {
+			delete(g.bans, tgId)
+		}
+	}
+	for key, until := range g.unknownPhones {
+		if !now.Before(until) {
+			delete(g.unknownPhones, key)
+		}
+	}
+}
+
+// Checks auth attempt of user without session, returns false if it is rejected
+// Rejected user is told about it only once, so the bot does not answer to flood
+func (b *bot) guardAuth(c tele.Context) bool {
+	tgId := c.Sender().ID
+	now := time.Now()
+	_, linked := b.idStore.Get(tgId)
+	verdict, notify := b.guard.allow(tgId, linked, now)
+	tr := b.tr(c)
+	if verdict != guardAllowed {
+		metrics.Auth(metrics.AuthAny, metrics.AuthRejected)
+	}
+	switch verdict {
+	case guardAllowed:
+		return true
+	case guardUserLimited:
+		if notify {
+			b.logger.Warn("auth rate limit", "tgId", tgId, "username", c.Sender().Username, "limit", authUserLimit)
+			c.Send(tr.Tr("auth.rateLimited"))
+		}
+	case guardGlobalLimited:
+		if b.guard.alertGlobal(now) {
+			b.logger.Warn("global auth rate limit", "limit", authGlobalLimit)
+		}
+		if notify {
+			c.Send(tr.Tr("auth.busy"))
+		}
+	}
+	return false
+}
+
+// Counts failed auth of the user, bans and tells him when limit is reached
+func (b *bot) authFailed(c tele.Context, method string) {
+	metrics.Auth(method, metrics.AuthFailure)
+	if !b.guard.fail(c.Sender().ID, time.Now()) {
+		return
+	}
+	b.logger.Warn("auth ban", "tgId", c.Sender().ID, "username", c.Sender().Username, "failures", authFailLimit, "duration", authBanDuration.String())
+	c.Send(b.tr(c).Tr("auth.banned", "minutes", int(authBanDuration.Minutes())))
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecent(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	times := []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute), now.Add(-30 * time.Second), now}
+	tests := []struct {
+		window time.Duration
+		want   int
+	}{
+		{time.Hour, 4},
+		{2*time.Minute + time.Second, 4},
+		{2 * time.Minute, 3}, // Time exactly window ago is dropped
+		{time.Minute, 2},
+		{time.Second, 1},
+	}
+	for _, tt := range tests {
+		if got := recent(times, now, tt.window); len(got) != tt.want {
+			t.Errorf("recent(%s) = %v, want %d times", tt.window, got, tt.want)
+		}
+	}
+	if got := recent(nil, now, time.Minute); len(got) != 0 {
+		t.Errorf("recent(nil) = %v", got)
+	}
+}
+
+func TestAllowUserLimit(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < authUserLimit; i++ {
+		if verdict, _ := g.allow(1, false, now); verdict != guardAllowed {
+			t.Fatalf("attempt %d is rejected: %v", i, verdict)
+		}
+	}
+	if verdict, notify := g.allow(1, false, now); verdict != guardUserLimited || !notify {
+		t.Errorf("the first reject = %v, %v, want user limited with notify", verdict, notify)
+	}
+	if verdict, notify := g.allow(1, false, now); verdict != guardUserLimited || notify {
+		t.Errorf("the second reject = %v, %v, want silent user limit", verdict, notify)
+	}
+	if verdict, _ := g.allow(2, false, now); verdict != guardAllowed {
+		t.Errorf("other user is rejected: %v", verdict)
+	}
+	if verdict, _ := g.allow(1, false, now.Add(authWindow)); verdict != guardAllowed {
+		t.Errorf("user is rejected after window: %v", verdict)
+	}
+}
+
+func TestAllowGlobalLimit(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < authGlobalLimit; i++ {
+		if verdict, _ := g.allow(int64(i), false, now); verdict != guardAllowed {
+			t.Fatalf("attempt of user %d is rejected: %v", i, verdict)
+		}
+	}
+	if verdict, notify := g.allow(-1, false, now); verdict != guardGlobalLimited || !notify {
+		t.Errorf("unknown user = %v, %v, want global limit with notify", verdict, notify)
+	}
+	if verdict, _ := g.allow(-2, true, now); verdict != guardAllowed {
+		t.Errorf("linked user is rejected by global limit: %v", verdict)
+	}
+	if verdict, _ := g.allow(-1, false, now.Add(authWindow)); verdict != guardAllowed {
+		t.Errorf("user is rejected after window: %v", verdict)
+	}
+}
+
+func TestLinkedUsersAreNotCountedGlobally(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2*authGlobalLimit; i++ {
+		g.allow(int64(i), true, now)
+	}
+	if verdict, _ := g.allow(-1, false, now); verdict != guardAllowed {
+		t.Errorf("unknown user is rejected after linked ones: %v", verdict)
+	}
+}
+
+func TestFailBans(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < authFailLimit-1; i++ {
+		if g.fail(1, now) {
+			t.Fatalf("user is banned after %d failures", i+1)
+		}
+	}
+	if !g.fail(1, now) {
+		t.Fatal("user is not banned after limit")
+	}
+	if verdict, notify := g.allow(1, true, now); verdict != guardBanned || notify {
+		t.Errorf("banned user = %v, %v, want silent ban", verdict, notify)
+	}
+	if verdict, _ := g.allow(1, true, now.Add(authBanDuration)); verdict != guardAllowed {
+		t.Errorf("user is rejected after ban: %v", verdict)
+	}
+}
+
+func TestFailWindow(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < authFailLimit-1; i++ {
+		g.fail(1, now)
+	}
+	if g.fail(1, now.Add(authFailWindow)) {
+		t.Error("old failures are counted")
+	}
+	g.success(2)
+	for i := 0; i < authFailLimit-1; i++ {
+		g.fail(2, now)
+	}
+	g.success(2)
+	if g.fail(2, now) {
+		t.Error("failures are counted after success")
+	}
+}
+
+func TestUnknownPhones(t *testing.T) {
+	g := newAuthGuard()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	key := unknownPhoneKey("+7 (999) 123-45-67", []string{"", "north"})
+	if key != unknownPhoneKey("79991234567", []string{"", "north"}) {
+		t.Error("keys of the same phone differ")
+	}
+	if key == unknownPhoneKey("79991234567", []string{"north"}) {
+		t.Error("keys of different portals match")
+	}
+	g.rememberUnknownPhone(key, now)
+	if !g.isUnknownPhone(key, now.Add(unknownPhonesTTL-time.Second)) {
+		t.Error("phone is not cached")
+	}
+	g.cleanup(now.Add(unknownPhonesTTL))
+	if g.isUnknownPhone(key, now) {
+		t.Error("phone is not dropped by cleanup")
+	}
+}
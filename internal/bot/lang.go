@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Language selection
+
+// Returns localizer for the sender
+// Language from settings is preferred, then language of telegram client
+func (b *bot) tr(c tele.Context) api.Localizer {
+	if c.Sender() == nil {
+		return b.catalog.Localizer("")
+	}
+	if lang := b.settings.Get(c.Sender().ID).Lang; lang != "" {
+		return b.catalog.Localizer(lang)
+	}
+	return b.catalog.Localizer(c.Sender().LanguageCode)
+}
+
+// Shows language buttons
+func (b *bot) onLang(c tele.Context) error {
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{}
+
+	autoBtn := menu.Data(b.tr(c).Tr("lang.auto"), "setLang", "")
+	b.bot.Handle(&autoBtn, b.onSetLang)
+	rows = append(rows, menu.Row(autoBtn))
+	for _, lang := range b.catalog.Langs() {
+		btn := menu.Data(b.catalog.Localizer(lang).Tr("lang.name"), "setLang", lang) // Every language is named in itself
+		b.bot.Handle(&btn, b.onSetLang)
+		rows = append(rows, menu.Row(btn))
+	}
+	menu.Inline(rows...)
+
+	return c.Send(b.tr(c).Tr("lang.choose"), menu)
+}
+
+// Stores selected language and applies it to session
+func (b *bot) onSetLang(c tele.Context) error {
+	lang := c.Data() // Empty for telegram client language
+	if lang != "" && !b.catalog.Supports(lang) {
+		return c.Respond()
+	}
+
+	tgId := c.Sender().ID
+	b.settings.Update(tgId, func(settings *api.UserSettings) { settings.Lang = lang })
+	if err := b.settings.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+
+	tr := b.tr(c)
+	if b.sessions.Exist(tgId) {
+		b.sessions.Get(tgId).SetLocalizer(tr)
+	}
+	b.logger.Debug("set language", "tgId", tgId, "lang", tr.Lang())
+
+	if c.Message() != nil {
+		b.bot.Delete(c.Message())
+	}
+	return c.Send(tr.Tr("lang.set"))
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Labels of handler metrics - only registered commands get their own label, so users can not flood metrics with new series
+var metricsCommands = map[string]bool{
+	"/accounts": true, "/audit": true, "/broadcast": true, "/cancel": true, "/digest": true,
+	"/lang": true, "/login": true, "/remind": true, "/revoke": true, "/start": true,
+	"/start_logs": true, "/stop": true, "/users": true, "/whois": true,
+}
+
+const metricsUniqueLen = 32 // Longer button uniques are cut
+
+// Counts handled updates and their duration by endpoint
+func (b *bot) metricsMiddle(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		endpoint := metricsEndpoint(c) // Handler could change callback
+		start := time.Now()
+		err := next(c)
+		metrics.ObserveHandler(endpoint, time.Since(start), err)
+		return err
+	}
+}
+
+// Endpoint label of update
+// Is got before handler could change callback - session router rewrites unique and data of raw callbacks
+func metricsEndpoint(c tele.Context) string {
+	if cb := c.Callback(); cb != nil {
+		unique := cb.Unique
+		if unique == "" { // Session buttons are not registered in telebot so their data is raw - \funique|payload
+			unique, _, _ = strings.Cut(strings.TrimPrefix(cb.Data, "\f"), "|")
+		}
+		return "callback:" + sanitizeLabel(unique)
+	}
+	msg := c.Message()
+	switch {
+	case msg == nil:
+		return "other"
+	case msg.Contact != nil:
+		return "contact"
+	case msg.Photo != nil:
+		return "photo"
+	case msg.Document != nil:
+		return "document"
+	case msg.Voice != nil:
+		return "voice"
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		command, _, _ := strings.Cut(strings.Fields(msg.Text + " ")[0], "@") // Commands in groups have bot username
+		if metricsCommands[command] {
+			return command
+		}
+	}
+	return "text"
+}
+
+// Leaves only letters, digits and underscores of button unique
+// Trailing digits are dropped - some uniques have entity id in them(addComment<deal id>)
+func sanitizeLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, s)
+	s = strings.TrimRight(s, "0123456789")
+	if len(s) > metricsUniqueLen {
+		s = s[:metricsUniqueLen]
+	}
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+func TestSanitizeLabel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"openDeal", "openDeal"},
+		{"addComment12345", "addComment"}, // Entity id
+		{"page_2", "page_"},
+		{"broad-cast!", "broadcast"},
+		{"123", "unknown"},
+		{"", "unknown"},
+		{"veryLongButtonUniqueThatIsLongerThanLimit", "veryLongButtonUniqueThatIsLonger"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeLabel(tt.in); got != tt.want {
+			t.Errorf("sanitizeLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		u    tele.Update
+		want string
+	}{
+		{"command", tele.Update{Message: &tele.Message{Text: "/digest 08:30"}}, "/digest"},
+		{"command with bot name", tele.Update{Message: &tele.Message{Text: "/start@tomestobot"}}, "/start"},
+		{"unknown command", tele.Update{Message: &tele.Message{Text: "/random1"}}, "text"},
+		{"text", tele.Update{Message: &tele.Message{Text: "hello"}}, "text"},
+		{"contact", tele.Update{Message: &tele.Message{Contact: &tele.Contact{}}}, "contact"},
+		{"registered button", tele.Update{Callback: &tele.Callback{Unique: "snooze", Data: "1:2:15m"}}, "callback:snooze"},
+		{"session button with id", tele.Update{Callback: &tele.Callback{Data: "\faddComment42|"}}, "callback:addComment"},
+		{"empty update", tele.Update{}, "other"},
+	}
+	for _, tt := range tests {
+		if got := metricsEndpoint(tele.NewContext(nil, tt.u)); got != tt.want {
+			t.Errorf("%s: metricsEndpoint() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/session"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Reminders before task deadlines
+// Sync job looks for tasks with close deadlines and puts reminders to durable job queue
+// Queue marks reminder as taken before it is sent, so it is never sent twice even after restart
+// Reminders are synced for the active account of the user, tasks of all users of a portal are requested by batches
+// Reminder id contains deadline - if deadline is changed the old reminder is dropped and a new one is scheduled
+// Failed reminder is scheduled again as a new job a few times, unless the user has blocked the bot
+// Jobs keep portal of the account - they are dropped when the account is unlinked
+
+const (
+	jobRemind           = "remind"
+	remindSyncInterval  = 10 * time.Minute
+	remindCheckInterval = time.Minute
+	remindBeforeMax     = 7 * 24 * time.Hour
+	snoozeTomorrowTime  = "09:00" // Is used if digests are disabled
+	jobAttempts         = 3
+	jobRetryDelay       = 5 * time.Minute
+)
+
+// Snooze options - payload is "<task id>:<deadline unix>:<option>"
+var snoozeBtn = tele.Btn{Unique: "snooze"}
+
+const (
+	snooze15m      = "15m"
+	snooze1h       = "1h"
+	snoozeTomorrow = "tomorrow"
+)
+
+type remindPayload struct {
+	api.BxAccount            // Account the reminder is scheduled for - its portal and user
+	TaskId        bxtypes.Id `json:"taskId"`
+	Deadline      time.Time  `json:"deadline"` // Deadline the reminder is scheduled for
+}
+
+func remindJobId(tgId int64, acc api.BxAccount, taskId bxtypes.Id, deadline time.Time) string {
+	id := fmt.Sprintf("remind:%d:%d:%d", tgId, taskId, deadline.Unix())
+	if acc.Portal != "" { // Task ids of different portals could match
+		id += ":" + acc.Portal
+	}
+	return id
+}
+
+// Schedules reminders of tasks with close deadlines - is called by scheduler
+func (b *bot) syncReminders(now time.Time) {
+	// Users with reminders by portals
+	portals := map[string][]api.LinkedUser{}
+	before := time.Duration(0)
+	b.idStore.Each(func(u api.LinkedUser) bool {
+		settings := b.settings.Get(u.TgId)
+		if !settings.Remind.Off && !u.Blocked {
+			portals[u.Portal] = append(portals[u.Portal], u)
+			before = max(before, b.remindBeforeOf(settings))
+		}
+		return true
+	})
+
+	// Tasks that should be reminded before the next sync with the longest lead time, with a margin for slow requests
+	deadline := now.Add(before + 2*remindSyncInterval)
+	for portal, users := range portals {
+		bx, ok := b.portals.Get(portal)
+		if !ok { // Portal was removed from config
+			continue
+		}
+		ids := make([]bxtypes.Id, 0, len(users))
+		for _, u := range users {
+			ids = append(ids, bxtypes.Id(u.BxId))
+		}
+		tasks, err := bx.ListTasksDueBetween(ids, now, deadline) // Overdue tasks are in digest
+		if err != nil {
+			b.logger.Warn("sync reminders", "portal", portal, "err", err.Error())
+			continue
+		}
+		for _, u := range users {
+			if err := b.scheduleReminders(now, u, b.remindBeforeOf(b.settings.Get(u.TgId)), tasks[bxtypes.Id(u.BxId)]); err != nil {
+				b.logger.Warn("sync reminders", "tgId", u.TgId, "err", err.Error())
+			}
+		}
+	}
+}
+
+func (b *bot) scheduleReminders(now time.Time, u api.LinkedUser, before time.Duration, tasks []bxtypes.Task) error {
+	for _, t := range tasks {
+		if t.Deadline.Before(now) || t.Deadline.After(now.Add(before+2*remindSyncInterval)) { // Tasks are requested for the longest lead time of all users
+			continue
+		}
+		runAt := t.Deadline.Add(-before)
+		if runAt.Before(now) { // Task was created or lead time was changed too late
+			runAt = now
+		}
+		payload, err := json.Marshal(remindPayload{BxAccount: u.BxAccount, TaskId: t.Id, Deadline: t.Deadline.Time})
+		if err != nil {
+			return err
+		}
+		if _, err := b.jobs.Schedule(api.Job{
+			Id:      remindJobId(u.TgId, u.BxAccount, t.Id, t.Deadline.Time),
+			Kind:    jobRemind,
+			RunAt:   runAt,
+			TgId:    u.TgId,
+			Portal:  u.Portal,
+			Payload: payload,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drops pending jobs of unlinked account
+func (b *bot) dropJobs(tgId int64, portal string) {
+	if n, err := b.jobs.Drop(tgId, portal); err != nil {
+		b.logger.Warn("drop jobs", "tgId", tgId, "portal", portal, "err", err.Error())
+	} else if n > 0 {
+		b.logger.Debug("jobs dropped", "tgId", tgId, "portal", portal, "count", n)
+	}
+}
+
+// Runs due jobs - is called by scheduler
+func (b *bot) runJobs(now time.Time) {
+	jobs, err := b.jobs.Take(now)
+	if err != nil {
+		b.logger.Warn("take jobs: " + err.Error())
+		return
+	}
+	for _, job := range jobs {
+		switch {
+		case b.stopped(): // Jobs are already taken - they are scheduled again
+			err = errStopped
+		case job.Kind == jobRemind:
+			err = b.remind(job)
+		default:
+			err = fmt.Errorf("unknown job kind %s", job.Kind)
+		}
+		if err != nil {
+			b.logger.Warn("run job", "id", job.Id, "attempt", job.Attempt, "err", err.Error())
+			b.retryJob(job, now, err)
+		}
+	}
+}
+
+// Schedules failed job again as a new one - the failed job is already taken
+// Job that is interrupted by stop is run after restart without counting the attempt
+func (b *bot) retryJob(job api.Job, now time.Time, err error) {
+	retry := job
+	switch {
+	case unreachable(err):
+		b.idStore.MarkBlocked(job.TgId)
+		if err := b.idStore.Save(); err != nil {
+			b.logger.Warn(err.Error())
+		}
+		return
+	case errors.Is(err, errStopped):
+		retry.Id = job.Id + ":stopped" + strconv.FormatInt(now.Unix(), 10)
+		retry.RunAt = now
+	case job.Attempt+1 >= jobAttempts:
+		return
+	default:
+		retry.Attempt++
+		retry.Id = job.Id + ":retry" + strconv.Itoa(retry.Attempt)
+		retry.RunAt = now.Add(jobRetryDelay)
+	}
+	if _, err := b.jobs.Schedule(retry); err != nil {
+		b.logger.Warn("retry job", "id", job.Id, "err", err.Error())
+	}
+}
+
+// Sends reminder if the task is still open and its deadline is the same
+func (b *bot) remind(job api.Job) error {
+	p := remindPayload{}
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	settings := b.settings.Get(job.TgId)
+	if settings.Remind.Off {
+		return nil
+	}
+	if acc, ok := b.idStore.Get(job.TgId); !ok || acc != p.BxAccount { // User was unlinked or switched to other account
+		return nil
+	}
+
+	bxUser, err := b.authAccount(p.BxAccount)
+	if err != nil {
+		return err
+	}
+	defer bxUser.Close()
+	task, err := bxUser.GetTask(p.TaskId)
+	if err != nil {
+		return err
+	}
+	// Task could be delegated to someone else after the reminder was scheduled
+	if task.Status == bxtypes.TaskStateCompleted || !task.Deadline.Equal(p.Deadline) || task.ResponsibleId != bxtypes.Id(p.BxId) {
+		return nil
+	}
+
+	tr := b.catalog.Localizer(settings.Lang)
+	text, err := b.views.Render(tr, screens.Reminder, screens.ReminderView{
+		Task:     task.Title,
+		Deadline: task.Deadline.In(b.zoneOf(settings)),
+	})
+	if err != nil {
+		return err
+	}
+
+	snoozePayload := task.Id.String() + ":" + strconv.FormatInt(p.Deadline.Unix(), 10) + ":"
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{menu.Row(
+		menu.Data(tr.Tr("remind.snooze15mBtn"), snoozeBtn.Unique, snoozePayload+snooze15m),
+		menu.Data(tr.Tr("remind.snooze1hBtn"), snoozeBtn.Unique, snoozePayload+snooze1h),
+		menu.Data(tr.Tr("remind.snoozeTomorrowBtn"), snoozeBtn.Unique, snoozePayload+snoozeTomorrow),
+	)}
+	if b.roles.of(p.Portal).resolve(bxUser.Get()).Can(api.ActionCompleteTask) {
+		rows = append(rows, menu.Row(menu.Data(tr.Tr("notify.completeBtn"), session.CompleteTaskBtn.Unique, task.Id.String())))
+	}
+	menu.Inline(rows...)
+
+	if !b.bulk.wait(b.done) {
+		return errStopped
+	}
+	_, err = b.bot.Send(&tele.User{ID: job.TgId}, text, menu)
+	return err
+}
+
+// Schedules the reminder again
+func (b *bot) onSnooze(c tele.Context) error {
+	tr := b.tr(c)
+	parts := strings.Split(c.Data(), ":")
+	if len(parts) != 3 {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+	taskId, idErr := strconv.Atoi(parts[0])
+	deadlineUnix, deadlineErr := strconv.ParseInt(parts[1], 10, 64)
+	if idErr != nil || deadlineErr != nil {
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+
+	tgId := c.Sender().ID
+	settings := b.settings.Get(tgId)
+	now := time.Now().In(b.zoneOf(settings))
+	var runAt time.Time
+	switch parts[2] {
+	case snooze15m:
+		runAt = now.Add(15 * time.Minute)
+	case snooze1h:
+		runAt = now.Add(time.Hour)
+	case snoozeTomorrow:
+		at := b.digestTimeOf(settings)
+		if at == "" {
+			at = snoozeTomorrowTime
+		}
+		clock, _ := time.Parse(clockLayout, at) // Digest time is already validated
+		y, m, d := now.Date()
+		runAt = time.Date(y, m, d+1, clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	default:
+		return c.Respond(&tele.CallbackResponse{Text: tr.Tr("common.btnExpired")})
+	}
+
+	acc, _ := b.idStore.Get(tgId) // Session middleware has already checked it
+	deadline := time.Unix(deadlineUnix, 0)
+	payload, err := json.Marshal(remindPayload{BxAccount: acc, TaskId: bxtypes.Id(taskId), Deadline: deadline})
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	// Every snooze is a new job - the original one is already taken
+	if _, err := b.jobs.Schedule(api.Job{
+		Id:      remindJobId(tgId, acc, bxtypes.Id(taskId), deadline) + ":" + strconv.FormatInt(runAt.Unix(), 10),
+		Kind:    jobRemind,
+		RunAt:   runAt,
+		TgId:    tgId,
+		Portal:  acc.Portal,
+		Payload: payload,
+	}); err != nil {
+		return b.sendError(c, err)
+	}
+
+	// Reminder is shown again with snooze time, title is loaded because the message has only plain text of it
+	snoozed := tr.Tr("remind.snoozed", "time", runAt.Format(tr.Tr("format.dateTime")))
+	title, err := b.taskTitle(acc, bxtypes.Id(taskId))
+	if err != nil {
+		b.logger.Warn("snooze load task", "tgId", tgId, "taskId", taskId, "err", err.Error())
+		if _, err := b.bot.EditReplyMarkup(c.Message(), &tele.ReplyMarkup{}); err != nil {
+			b.logger.Debug("snooze remove buttons", "tgId", tgId, "err", err.Error())
+		}
+		return c.Respond(&tele.CallbackResponse{Text: snoozed})
+	}
+	text, err := b.views.Render(tr, screens.Reminder, screens.ReminderView{
+		Task:     title,
+		Deadline: deadline.In(b.zoneOf(settings)),
+		Snoozed:  runAt,
+	})
+	if err != nil {
+		return b.sendError(c, err)
+	}
+	return c.Edit(text, &tele.ReplyMarkup{})
+}
+
+func (b *bot) taskTitle(acc api.BxAccount, taskId bxtypes.Id) (string, error) {
+	bxUser, err := b.authAccount(acc)
+	if err != nil {
+		return "", err
+	}
+	defer bxUser.Close()
+	task, err := bxUser.GetTask(taskId)
+	if err != nil {
+		return "", err
+	}
+	return task.Title, nil
+}
+
+// Shows or changes reminders settings
+// /remind [on|off|<lead time like 30m>]
+func (b *bot) onRemind(c tele.Context) error {
+	tr := b.tr(c)
+	tgId := c.Sender().ID
+
+	change := func(settings *api.UserSettings) {}
+	switch arg := strings.TrimSpace(c.Message().Payload); arg {
+	case "":
+	case "on", "off":
+		change = func(settings *api.UserSettings) { settings.Remind.Off = arg == "off" }
+	default:
+		before, err := time.ParseDuration(arg)
+		if err != nil || before <= 0 || before > remindBeforeMax {
+			return c.Send(tr.Tr("remind.usage"))
+		}
+		change = func(settings *api.UserSettings) {
+			settings.Remind.Before = before.String()
+			settings.Remind.Off = false
+		}
+	}
+	settings := b.settings.Update(tgId, change)
+	if err := b.settings.Save(); err != nil {
+		b.logger.Warn(err.Error())
+	}
+
+	text := tr.Tr("remind.on", "before", b.remindBeforeOf(settings).String())
+	if settings.Remind.Off {
+		text = tr.Tr("remind.off")
+	}
+	return c.Send(text + "\n\n" + tr.Tr("remind.usage"))
+}
+
+// Returns reminder lead time of the user
+func (b *bot) remindBeforeOf(settings api.UserSettings) time.Duration {
+	if before, err := time.ParseDuration(settings.Remind.Before); err == nil && before > 0 {
+		return before
+	}
+	return b.remindBefore
+}
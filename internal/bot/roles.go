@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Roles of users
+// Role is derived from bitrix user so it is known only after auth and is kept in session
+// Rules are read from json file(ROLES_FILE), the first matching rule is applied:
+//   - users - by bitrix user id(admins from ADMIN_BX_IDS are added here)
+//   - departments - the highest role of user's departments(UF_DEPARTMENT)
+//   - userTypes - by bitrix user type(employee, extranet, email)
+//   - default - rep if it is not set
+// Department heads(UF_HEAD) get at least heads role(supervisor if it is not set) unless they are in users, so team view works without listing them
+// Also config contains roles that have to confirm task completion
+// Every portal has its own config in "portals" section - user and department ids differ between portals
+// Portal without section gets default, userTypes, heads and confirmComplete of the top level config
+
+type rolesConfig struct {
+	Default     api.Role                `json:"default"`
+	Users       map[bxtypes.Id]api.Role `json:"users"`
+	Departments map[bxtypes.Id]api.Role `json:"departments"`
+	UserTypes   map[string]api.Role     `json:"userTypes"`
+	Heads       api.Role                `json:"heads"` // The lowest role of department heads
+
+	ConfirmComplete []api.Role `json:"confirmComplete"` // Roles that confirm task completion
+
+	heads *departmentHeads // Nil for removed portal
+}
+
+// Roles configs of portals
+type portalRoles map[string]rolesConfig
+
+// Reads roles config, empty filename means default config
+// Unlike other stores broken config is an error - wrong roles must not be given silently
+func loadRolesConfig(filename string, portals []string, adminBxIds map[string][]int64) (portalRoles, error) {
+	file := struct {
+		rolesConfig
+		Portals map[string]rolesConfig `json:"portals"`
+	}{}
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("read roles file: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parse roles file: %w", err)
+		}
+	}
+	for name := range file.Portals {
+		if !slices.Contains(portals, name) {
+			return nil, fmt.Errorf("unknown portal %q in roles config", name)
+		}
+	}
+
+	roles := portalRoles{}
+	for _, name := range portals {
+		config, ok := file.Portals[name]
+		if name == "" {
+			config = file.rolesConfig
+		} else if !ok {
+			config = rolesConfig{
+				Default:         file.Default,
+				UserTypes:       file.UserTypes,
+				Heads:           file.Heads,
+				ConfirmComplete: file.ConfirmComplete,
+			}
+		}
+		if err := config.prepare(adminBxIds[name]); err != nil {
+			return nil, fmt.Errorf("portal %q: %w", name, err)
+		}
+		roles[name] = config
+	}
+	return roles, nil
+}
+
+// Sets defaults, adds admins and validates roles
+func (config *rolesConfig) prepare(adminBxIds []int64) error {
+	if config.Default == "" {
+		config.Default = api.RoleRep
+	}
+	if config.Heads == "" {
+		config.Heads = api.RoleSupervisor
+	}
+	users := map[bxtypes.Id]api.Role{} // Map could be shared with top level config
+	for id, r := range config.Users {
+		users[id] = r
+	}
+	for _, id := range adminBxIds {
+		users[bxtypes.Id(id)] = api.RoleAdmin
+	}
+	config.Users = users
+
+	// Validate roles
+	roles := []api.Role{config.Default, config.Heads}
+	for _, m := range []map[bxtypes.Id]api.Role{config.Users, config.Departments} {
+		for _, r := range m {
+			roles = append(roles, r)
+		}
+	}
+	for _, r := range config.UserTypes {
+		roles = append(roles, r)
+	}
+	roles = append(roles, config.ConfirmComplete...)
+	for _, r := range roles {
+		if !r.Valid() {
+			return fmt.Errorf("unknown role %q in roles config", r)
+		}
+	}
+	return nil
+}
+
+// Returns roles config of the portal, removed portal gets the lowest role
+func (roles portalRoles) of(portal string) rolesConfig {
+	if config, ok := roles[portal]; ok {
+		return config
+	}
+	return rolesConfig{Default: api.RoleViewer}
+}
+
+// Returns role of the bitrix user
+func (config rolesConfig) resolve(u bxtypes.User) api.Role {
+	if r, ok := config.Users[u.Id]; ok {
+		return r
+	}
+	role := config.ruled(u)
+	if config.heads.has(u.Id) {
+		role = role.Max(config.Heads)
+	}
+	return role
+}
+
+// Returns role by departments, user type or default one
+func (config rolesConfig) ruled(u bxtypes.User) api.Role {
+	role, found := api.Role(""), false
+	for _, d := range u.Departments {
+		if r, ok := config.Departments[d]; ok {
+			role, found = role.Max(r), true
+		}
+	}
+	if found {
+		return role
+	}
+	if r, ok := config.UserTypes[u.UserType]; ok {
+		return r
+	}
+	return config.Default
+}
+
+const (
+	headsTTL   = 10 * time.Minute // Heads are reloaded so changes of company structure are seen without restart
+	headsRetry = time.Minute      // Failed load is retried after it, previous heads are used meanwhile
+)
+
+// Heads of portal departments - all departments are listed at once and cached
+type departmentHeads struct {
+	logger *slog.Logger
+	bx     api.BxWrapper
+
+	mutex  sync.Mutex // Roles are resolved by concurrent handlers
+	heads  map[bxtypes.Id]bool
+	expire time.Time
+}
+
+func newDepartmentHeads(logger *slog.Logger, bx api.BxWrapper) *departmentHeads {
+	return &departmentHeads{
+		logger: logger,
+		bx:     bx,
+		heads:  map[bxtypes.Id]bool{},
+	}
+}
+
+// Checks if the user heads any department
+func (h *departmentHeads) has(id bxtypes.Id) bool {
+	if h == nil {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if now := time.Now(); !now.Before(h.expire) {
+		departments, err := h.bx.ListDepartments()
+		if err != nil {
+			h.logger.Warn("load department heads", "err", err.Error())
+			h.expire = now.Add(headsRetry)
+			return h.heads[id]
+		}
+		h.heads = map[bxtypes.Id]bool{}
+		for _, d := range departments {
+			if d.HeadId != 0 {
+				h.heads[d.HeadId] = true
+			}
+		}
+		h.expire = now.Add(headsTTL)
+	}
+	return h.heads[id]
+}
+
+// Returns role of the telegram user - the lowest one if user has no session
+func (b *bot) roleOf(tgId int64) api.Role {
+	if b.sessions.Exist(tgId) {
+		return b.sessions.Get(tgId).Role()
+	}
+	return api.RoleViewer
+}
+
+// Middleware that checks user's role for the action
+// Must go after session middleware so the user is already authorized
+func (b *bot) require(action api.Action) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if role := b.roleOf(c.Sender().ID); !role.Can(action) {
+				b.logger.Warn("access denied", "tgId", c.Sender().ID, "username", c.Sender().Username, "role", role, "action", action, "text", c.Text())
+				b.record(c, action, "", api.AuditResultDenied)
+				text := b.tr(c).Tr("access.denied", "role", api.RoleText(b.tr(c), role))
+				if c.Callback() != nil {
+					return c.Respond(&tele.CallbackResponse{Text: text, ShowAlert: true})
+				}
+				return c.Send(text)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+// Portal with department listing only
+type fakeDepartmentsBx struct {
+	api.BxWrapper
+	departments []bxtypes.Department
+	err         error
+	calls       int
+}
+
+func (bx *fakeDepartmentsBx) ListDepartments() ([]bxtypes.Department, error) {
+	bx.calls++
+	return bx.departments, bx.err
+}
+
+func TestResolveHeads(t *testing.T) {
+	bx := &fakeDepartmentsBx{departments: []bxtypes.Department{
+		{Id: 1, HeadId: 10},
+		{Id: 2, Parent: 1, HeadId: 11},
+		{Id: 3, Parent: 1}, // Without head
+	}}
+	roles, err := loadRolesConfig("", []string{""}, map[string][]int64{"": {12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := roles.of("")
+	config.heads = newDepartmentHeads(slog.Default(), bx)
+	config.Departments = map[bxtypes.Id]api.Role{2: api.RoleViewer, 3: api.RoleAdmin}
+
+	tests := []struct {
+		name string
+		user bxtypes.User
+		want api.Role
+	}{
+		{"head", bxtypes.User{Id: 10}, api.RoleSupervisor},
+		{"head of viewers department", bxtypes.User{Id: 11, Departments: bxtypes.IdList{2}}, api.RoleSupervisor},
+		{"head with higher department role", bxtypes.User{Id: 10, Departments: bxtypes.IdList{3}}, api.RoleAdmin},
+		{"admin head", bxtypes.User{Id: 12}, api.RoleAdmin},
+		{"employee", bxtypes.User{Id: 13, Departments: bxtypes.IdList{1}}, api.RoleRep},
+	}
+	for _, tt := range tests {
+		if got := config.resolve(tt.user); got != tt.want {
+			t.Errorf("%s: resolve() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if bx.calls != 1 {
+		t.Errorf("departments are listed %d times, want once", bx.calls)
+	}
+
+	// Viewer heads role turns the rule off
+	config.Heads = api.RoleViewer
+	if got := config.resolve(bxtypes.User{Id: 10}); got != api.RoleRep {
+		t.Errorf("head with viewer heads role = %s, want default rep", got)
+	}
+}
+
+func TestDepartmentHeadsLoadError(t *testing.T) {
+	bx := &fakeDepartmentsBx{departments: []bxtypes.Department{{Id: 1, HeadId: 10}}}
+	h := newDepartmentHeads(slog.Default(), bx)
+	if !h.has(10) {
+		t.Fatal("head is not found")
+	}
+
+	// Failed reload keeps loaded heads
+	bx.err = errors.New("portal is down")
+	h.expire = h.expire.Add(-headsTTL)
+	if !h.has(10) || bx.calls != 2 {
+		t.Errorf("head is lost after failed reload, %d calls", bx.calls)
+	}
+	if h.has(10); bx.calls != 2 {
+		t.Errorf("failed load is retried at once, %d calls", bx.calls)
+	}
+
+	var removed *departmentHeads // Removed portal has no heads
+	if removed.has(10) {
+		t.Error("removed portal has head")
+	}
+}
@@ -0,0 +1,124 @@
+package screens
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Screens are rendered with html/template so all bitrix data is escaped for telegram html parse mode
+// Default templates are embedded, any of them could be overridden by a file with the same name in templates dir
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+// Template names
+const (
+	Start         = "start.html"
+	Deals         = "deals.html"
+	DealCard      = "deal_card.html"
+	CommentAdded  = "comment_added.html"
+	TaskCompleted = "task_completed.html"
+	TaskConfirm   = "task_confirm.html"
+	HistoryPage   = "history_page.html"
+	HistoryEntry  = "history_entry.html"
+	Users         = "users.html"
+	Whois         = "whois.html"
+	Audit         = "audit.html"
+	TaskEvent     = "task_event.html"
+	DealEvent     = "deal_event.html"
+	CommentEvent  = "comment_event.html"
+	Digest        = "digest.html"
+	Reminder      = "reminder.html"
+
+	BroadcastPreview = "broadcast_preview.html"
+	BroadcastReport  = "broadcast_report.html"
+)
+
+var required = []string{Start, Deals, DealCard, CommentAdded, TaskCompleted, TaskConfirm, HistoryPage, HistoryEntry, Users, Whois, Audit, TaskEvent, DealEvent, CommentEvent, Digest, Reminder, BroadcastPreview, BroadcastReport}
+
+type Renderer struct {
+	tmpl *template.Template // Is only cloned, never executed
+
+	mutex   sync.Mutex
+	locales map[string]*template.Template // Clones bound to localizer by language
+}
+
+// Parses embedded templates and overrides them with templates from dir(if it is not empty)
+func Load(dir string) (*Renderer, error) {
+	tmpl := template.New("screens").Funcs(placeholderFuncs)
+	tmpl, err := tmpl.ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse embedded templates: %w", err)
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, fmt.Errorf("list templates dir: %w", err)
+		}
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("read template %s: %w", f, err)
+			}
+			if _, err := tmpl.New(filepath.Base(f)).Parse(string(data)); err != nil {
+				return nil, fmt.Errorf("parse template %s: %w", f, err)
+			}
+		}
+	}
+
+	for _, name := range required {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("missing template %s", name)
+		}
+	}
+	return &Renderer{tmpl: tmpl, locales: map[string]*template.Template{}}, nil
+}
+
+// Functions are bound to localizer of the language on its first render
+var placeholderFuncs = template.FuncMap{
+	"tr":     func(key string, args ...any) string { return key },
+	"plural": func(key string, n int, args ...any) string { return key },
+}
+
+// Renders screen in user's language
+// Trailing spaces and new lines are trimmed so templates could end with new line
+func (r *Renderer) Render(tr api.Localizer, name string, data any) (string, error) {
+	tmpl, err := r.bound(tr)
+	if err != nil {
+		return "", err
+	}
+
+	str := strings.Builder{}
+	if err := tmpl.ExecuteTemplate(&str, name, data); err != nil {
+		return "", fmt.Errorf("execute template %s: %w", name, err)
+	}
+	return strings.TrimSpace(str.String()), nil
+}
+
+// Returns templates with functions of the localizer's language
+// Localizers of one language are the same so the first one is bound
+func (r *Renderer) bound(tr api.Localizer) (*template.Template, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if tmpl := r.locales[tr.Lang()]; tmpl != nil {
+		return tmpl, nil
+	}
+	tmpl, err := r.tmpl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("clone templates: %w", err)
+	}
+	tmpl.Funcs(template.FuncMap{
+		"tr":     tr.Tr,
+		"plural": tr.Plural,
+	})
+	r.locales[tr.Lang()] = tmpl
+	return tmpl, nil
+}
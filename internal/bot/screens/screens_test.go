@@ -0,0 +1,79 @@
+package screens
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/internal/i18n"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+func TestRenderBindsLocales(t *testing.T) {
+	r, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, err := i18n.Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := StartView{User: bxtypes.User{Name: "Ivan", LastName: "<b>"}}
+
+	texts := map[string]string{}
+	for _, lang := range []string{"en", "ru", "en"} { // The second en render uses bound templates
+		text, err := r.Render(cat.Localizer(lang), Start, view)
+		if err != nil {
+			t.Fatalf("render %s: %s", lang, err)
+		}
+		if prev, ok := texts[lang]; ok && prev != text {
+			t.Errorf("render %s differs from the first one: %q and %q", lang, prev, text)
+		}
+		texts[lang] = text
+	}
+	if texts["en"] == texts["ru"] {
+		t.Errorf("en and ru renders are the same: %q", texts["en"])
+	}
+	if !strings.Contains(texts["en"], "Hello, Ivan &lt;b&gt;") {
+		t.Errorf("en render is not localized or not escaped: %q", texts["en"])
+	}
+	if len(r.locales) != 2 {
+		t.Errorf("%d locales are bound, want 2", len(r.locales))
+	}
+}
+
+func TestRenderSnoozedReminder(t *testing.T) {
+	r, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, err := i18n.Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := cat.Localizer("en")
+	deadline := time.Date(2024, 5, 10, 18, 0, 0, 0, time.UTC)
+	view := ReminderView{Task: "Call <client>", Deadline: deadline}
+
+	text, err := r.Render(tr, Reminder, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text, "💤") {
+		t.Errorf("not snoozed reminder has snooze line: %q", text)
+	}
+
+	view.Snoozed = deadline.Add(-time.Hour)
+	snoozed, err := r.Render(tr, Reminder, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := text + "\n\n" + tr.Tr("remind.snoozed", "time", view.Snoozed.Format(tr.Tr("format.dateTime")))
+	if snoozed != want {
+		t.Errorf("snoozed reminder = %q, want %q", snoozed, want)
+	}
+	if !strings.Contains(snoozed, "<i>Call &lt;client&gt;</i>") {
+		t.Errorf("snoozed reminder lost formatting: %q", snoozed)
+	}
+}
@@ -0,0 +1,191 @@
+package screens
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+// Data of the screens - fields are exported to be accessible in templates
+
+type StartView struct {
+	User bxtypes.User
+}
+
+type DealsView struct {
+	Assignee    string // Name of employee whose deals are shown, empty for own deals
+	Count       int
+	FilterParts []string // Plain text descriptions of filter parts, empty if there is no filter
+}
+
+type DealCardView struct {
+	Deal     bxtypes.Deal
+	Stage    string // Localized stage name
+	Assignee string // Name of assignee if it is not the user
+	Contacts []ContactView
+	Company  *CompanyView // Nil if deal has no company
+
+	ContactsUnavailable bool // Failed to load - card is shown without them
+	CompanyUnavailable  bool
+}
+
+type ContactView struct {
+	Name   string
+	Post   string
+	Phones []Link
+	Emails []Link
+}
+
+type CompanyView struct {
+	Title  string
+	Phones []Link
+	Emails []Link
+}
+
+// Link with already checked href - html/template allows only http(s) and mailto urls
+type Link struct {
+	Href template.URL
+	Text string
+}
+
+type CommentAddedView struct {
+	Deal        bxtypes.Deal
+	Assignee    string // Name of assignee if it is not the user
+	Comment     string
+	Attachments []string // File names
+}
+
+type TaskCompletedView struct {
+	Deal     bxtypes.Deal
+	Assignee string // Name of assignee if it is not the user
+	Task     bxtypes.Task
+}
+
+type TaskConfirmView = TaskCompletedView
+
+type HistoryPageView struct {
+	Deal    bxtypes.Deal
+	Page    int // From 1
+	Pages   int
+	Entries []template.HTML // Already rendered entries
+}
+
+type HistoryEntryView struct {
+	Entry    bxtypes.TimelineEntry
+	Activity bool
+	Text     string // Cut entry text
+}
+
+// Notifications of bitrix events
+
+type TaskEventView struct {
+	New       bool // Task was added, otherwise changed
+	Completed bool
+	Task      bxtypes.Task
+	Deal      *bxtypes.Deal // Nil if task is not linked to deal
+	Author    string        // Who made the change, empty if it is unknown
+}
+
+type DealEventView struct {
+	Deal   bxtypes.Deal
+	Stage  string // Localized stage name
+	Author string
+}
+
+type CommentEventView struct {
+	Deal    bxtypes.Deal
+	Author  string
+	Comment string // Cut comment text
+}
+
+// Daily digest - deadlines are in user's time zone
+
+type DigestView struct {
+	Overdue []DigestTask
+	Today   []DigestTask
+	Week    []DigestTask
+}
+
+type DigestTask struct {
+	Title    string
+	Deadline time.Time
+}
+
+type ReminderView struct {
+	Task     string
+	Deadline time.Time // In user's time zone
+	Snoozed  time.Time // When the reminder comes again, zero if it is not snoozed
+}
+
+// Admin screens
+
+type UsersView struct {
+	Count int // Count of all linked users
+	Page  int // From 1
+	Pages int
+	Users []api.LinkedUser
+}
+
+type WhoisView struct {
+	Linked  api.LinkedUser
+	User    *bxtypes.User      // Nil if bitrix user could not be loaded
+	Role    string             // Localized role name
+	Session *api.SessionStatus // Nil if user has no active session
+}
+
+type AuditView struct {
+	Entries []api.AuditEntry // From newest to oldest
+}
+
+type BroadcastPreviewView struct {
+	Text       string // Message as recipients see it
+	Audience   string // Localized audience name
+	Recipients int
+}
+
+type BroadcastReportView struct {
+	Audience    string
+	Queued      bool // Waits for previous broadcasts
+	Done        bool
+	Total       int
+	Sent        int
+	Blocked     int
+	Failed      int
+	Undelivered []BroadcastDelivery // Blocked and failed ones
+	More        int                 // Undelivered ones that are not listed
+}
+
+type BroadcastDelivery struct {
+	TgId     int64
+	Username string
+	Status   string // Localized
+}
+
+// Links creation
+
+func PhoneLinks(phones []bxtypes.Multifield) []Link {
+	links := []Link{}
+	for _, p := range phones {
+		// Leave only digits and plus so the url is safe
+		number := strings.Map(func(r rune) rune {
+			if (r >= '0' && r <= '9') || r == '+' {
+				return r
+			}
+			return -1
+		}, p.Value)
+		links = append(links, Link{Href: template.URL("tel:" + number), Text: p.Value})
+	}
+	return links
+}
+
+func EmailLinks(emails []bxtypes.Multifield) []Link {
+	links := []Link{}
+	for _, e := range emails {
+		links = append(links, Link{Href: template.URL("mailto:" + url.PathEscape(e.Value)), Text: e.Value})
+	}
+	return links
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Telegram does not allow bots to download files bigger than 20MB
+const maxAttachmentSize = 20 << 20
+
+// Downloads file attached to the message(photo, document or voice)
+// Returns nil slice if there is no attachment
+func (s *session) downloadAttachments(msg *tele.Message) ([]bxtypes.File, error) {
+	var (
+		file *tele.File
+		name string
+	)
+	switch {
+	case msg.Photo != nil:
+		file = &msg.Photo.File
+		name = fmt.Sprintf("photo_%s.jpg", msg.Photo.UniqueID)
+	case msg.Document != nil:
+		file = &msg.Document.File
+		name = msg.Document.FileName
+		if name == "" {
+			name = "document_" + msg.Document.UniqueID
+		}
+	case msg.Voice != nil:
+		file = &msg.Voice.File
+		name = fmt.Sprintf("voice_%s.ogg", msg.Voice.UniqueID)
+	default:
+		return nil, nil
+	}
+	if file.FileSize > maxAttachmentSize {
+		return nil, api.ErrorFileTooBig
+	}
+
+	// Download
+	reader, err := s.bot.File(file)
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	s.logger.Debug("downloaded attachment", "name", name, "size", len(content))
+
+	return []bxtypes.File{{Name: name, Content: content}}, nil
+}
+
+// Returns text of the message - caption in case of media message
+func msgText(msg *tele.Message) string {
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Audit of bitrix changes
+
+// Writes action to audit log
+// Failed writing is only logged because the action is already done
+func (s *session) record(c tele.Context, action api.Action, entity string, payload any, result string) {
+	e := api.AuditEntry{
+		Time:     time.Now(),
+		TgId:     c.Sender().ID,
+		Username: c.Sender().Username,
+		Portal:   s.portal,
+		BxId:     int64(s.bxUser.Get().Id),
+		Action:   action,
+		Entity:   entity,
+		Result:   result,
+	}
+	if payload != nil {
+		e.PayloadHash = api.PayloadHash(payload)
+	}
+	if err := s.audit.Append(e); err != nil {
+		s.logger.Warn("audit append", "err", err.Error(), "action", action, "entity", entity)
+	}
+}
+
+func dealEntity(id bxtypes.Id) string {
+	return "deal:" + id.String()
+}
+
+func commentEntity(id bxtypes.Id) string {
+	return "comment:" + id.String()
+}
+
+func taskEntity(id bxtypes.Id) string {
+	return "task:" + id.String()
+}
+
+// Comment data that is hashed - files are identified by name and content
+func commentAuditPayload(text string, files []bxtypes.File) any {
+	return struct {
+		Text  string         `json:"text"`
+		Files []bxtypes.File `json:"files"`
+	}{text, files}
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+// Deal card with detail info, client contacts and company
+
+type dealCard struct {
+	deal                bxtypes.Deal
+	contacts            []bxtypes.Contact
+	company             *bxtypes.Company // Nil if deal has no company
+	contactsUnavailable bool
+	companyUnavailable  bool
+}
+
+// Requests all info that is shown on deal card
+// Only deal itself is required - card without contacts or company is better than error
+func loadDealCard(logger *slog.Logger, u api.BxUser, dealId bxtypes.Id) (dealCard, error) {
+	deal, err := u.GetDeal(dealId)
+	if err != nil {
+		return dealCard{}, err
+	}
+	card := dealCard{deal: deal}
+	if card.contacts, err = u.ListDealContacts(dealId); err != nil {
+		logger.Warn("deal card contacts", "dealId", dealId, "err", err.Error())
+		card.contactsUnavailable = true
+	}
+	if deal.CompanyId != 0 {
+		company, err := u.GetCompany(deal.CompanyId)
+		if err != nil {
+			logger.Warn("deal card company", "dealId", dealId, "companyId", deal.CompanyId, "err", err.Error())
+			card.companyUnavailable = true
+		} else {
+			card.company = &company
+		}
+	}
+	return card, nil
+}
+
+// Prepares card data for template
+func (card dealCard) view(tr api.Localizer) screens.DealCardView {
+	v := screens.DealCardView{
+		Deal:     card.deal,
+		Stage:    StageText(tr, card.deal.StageId),
+		Contacts: []screens.ContactView{},
+
+		ContactsUnavailable: card.contactsUnavailable,
+		CompanyUnavailable:  card.companyUnavailable,
+	}
+	for _, c := range card.contacts {
+		v.Contacts = append(v.Contacts, screens.ContactView{
+			Name:   strings.Join(strings.Fields(c.LastName+" "+c.Name+" "+c.SecondName), " "),
+			Post:   c.Post,
+			Phones: screens.PhoneLinks(c.Phone),
+			Emails: screens.EmailLinks(c.Email),
+		})
+	}
+	if card.company != nil {
+		v.Company = &screens.CompanyView{
+			Title:  card.company.Title,
+			Phones: screens.PhoneLinks(card.company.Phone),
+			Emails: screens.EmailLinks(card.company.Email),
+		}
+	}
+	return v
+}
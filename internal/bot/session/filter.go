@@ -0,0 +1,179 @@
+package session
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Deals filter and search screens
+
+// Adds filter control buttons to the bottom of deals list
+func (s *session) appendDealFilterRow(menu *tele.ReplyMarkup) {
+	stageBtn := menu.Data(s.tr.Tr("filter.stageBtn"), "filterStages")
+	s.handlers.Handle(&stageBtn, s.onFilterStages)
+	searchBtn := menu.Data(s.tr.Tr("filter.searchBtn"), "searchDeals")
+	s.handlers.Handle(&searchBtn, s.onSearchDeals)
+	row := []tele.InlineButton{*stageBtn.Inline(), *searchBtn.Inline()}
+
+	if s.dealQuery.IsFiltered() {
+		resetBtn := menu.Data(s.tr.Tr("filter.resetBtn"), "resetFilter")
+		s.handlers.Handle(&resetBtn, s.onResetFilter)
+		row = append(row, *resetBtn.Inline())
+	}
+	menu.InlineKeyboard = append(menu.InlineKeyboard, row)
+}
+
+// Describes current filter - parts are plain text, empty if there is no filter
+func (s *session) dealFilterParts() []string {
+	parts := []string{}
+	if s.dealQuery.StageId != "" {
+		parts = append(parts, s.tr.Tr("filter.stage", "stage", s.stageText(s.dealQuery.StageId)))
+	}
+	if s.dealQuery.CategoryId != "" {
+		parts = append(parts, s.tr.Tr("filter.category", "category", s.dealQuery.CategoryId))
+	}
+	if s.dealQuery.Title != "" {
+		parts = append(parts, s.tr.Tr("filter.title", "title", s.dealQuery.Title))
+	}
+	if !s.dealQuery.CreatedFrom.IsZero() {
+		parts = append(parts, s.tr.Tr("filter.createdFrom", "date", s.dealQuery.CreatedFrom.Format(s.tr.Tr("format.date"))))
+	}
+	if !s.dealQuery.CreatedTo.IsZero() {
+		parts = append(parts, s.tr.Tr("filter.createdTo", "date", s.dealQuery.CreatedTo.Format(s.tr.Tr("format.date"))))
+	}
+	if s.dealQuery.Sort != api.DealSortDefault {
+		parts = append(parts, s.tr.Tr("filter.sort", "field", s.tr.Tr(dealSortKeys[s.dealQuery.Sort]), "desc", s.dealQuery.SortDesc))
+	}
+	return parts
+}
+
+// Sort fields by search token values
+var dealSorts = map[string]api.DealSort{
+	"title":   api.DealSortTitle,
+	"created": api.DealSortCreated,
+	"close":   api.DealSortCloseDate,
+}
+
+var dealSortKeys = map[api.DealSort]string{
+	api.DealSortTitle:     "filter.sortTitle",
+	api.DealSortCreated:   "filter.sortCreated",
+	api.DealSortCloseDate: "filter.sortCloseDate",
+}
+
+// Applies search text to query - words with known prefixes set filters and sort, other words are title
+// pipeline:<id>, from:<date>, to:<date>, sort:title|created|close(sort:-created for descending)
+// Dates are in ISO or user's locale format, returns the first invalid token
+func applyDealSearch(query *api.DealQuery, text string, dateLayout string) (string, bool) {
+	parseDate := func(v string) (time.Time, bool) {
+		for _, layout := range []string{time.DateOnly, dateLayout} {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	title := []string{}
+	for _, word := range strings.Fields(text) {
+		key, value, found := strings.Cut(word, ":")
+		if !found || value == "" {
+			title = append(title, word)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "pipeline":
+			if strings.Trim(value, "0123456789") != "" {
+				return word, false
+			}
+			query.CategoryId = value
+		case "from":
+			t, ok := parseDate(value)
+			if !ok {
+				return word, false
+			}
+			query.CreatedFrom = t
+		case "to":
+			t, ok := parseDate(value)
+			if !ok {
+				return word, false
+			}
+			query.CreatedTo = t.Add(24*time.Hour - time.Second) // Whole day is included
+		case "sort":
+			sort, ok := dealSorts[strings.TrimPrefix(value, "-")]
+			if !ok {
+				return word, false
+			}
+			query.Sort = sort
+			query.SortDesc = strings.HasPrefix(value, "-")
+		default:
+			title = append(title, word)
+		}
+	}
+	query.Title = strings.Join(title, " ")
+	return "", true
+}
+
+// Shows stage chips
+func (s *session) onFilterStages(c tele.Context) error {
+	// Stage id is short enough to be a payload itself
+	btns := []inlineBtnDescr{{
+		text:    s.tr.Tr("filter.allStages"),
+		unique:  "selectStageAll",
+		payload: "",
+	}}
+	for i, stage := range bxtypes.DealStages {
+		text := s.stageText(stage)
+		if stage == s.dealQuery.StageId {
+			text = "✅ " + text
+		}
+		btns = append(btns, inlineBtnDescr{
+			text:    text,
+			unique:  fmt.Sprintf("selectStage%d", i),
+			payload: stage,
+		})
+	}
+	return s.showPaginated(c, "stages", s.tr.Tr("filter.selectStage"), newPaginator(s.onSelectStage, btns, paginatorDescr{columns: 2}))
+}
+
+// Applies selected stage and shows deals again
+func (s *session) onSelectStage(c tele.Context) error {
+	s.dealQuery.StageId = c.Data()
+	return s.onListDeals(c)
+}
+
+// Asks to write search string
+func (s *session) onSearchDeals(c tele.Context) error {
+	if err := s.flow.Transition(stateSearchingDeals); err != nil {
+		return s.sendError(c, err)
+	}
+	return s.showPrompt(c, "searchDeals", s.tr.Tr("filter.searchPrompt"))
+}
+
+// Applies written search string
+func (s *session) onSearchText(c tele.Context) error {
+	s.flow.Transition(stateIdle) // Leave state before any error
+	defer s.bot.Delete(c.Message())
+
+	query := s.dealQuery
+	if token, ok := applyDealSearch(&query, c.Text(), s.tr.Tr("format.date")); !ok {
+		return c.Send(s.tr.Tr("filter.invalidToken", "token", html.EscapeString(token)))
+	}
+	s.dealQuery = query
+	return s.onListDeals(c)
+}
+
+// Resets all filters but keeps scope and sort order
+func (s *session) onResetFilter(c tele.Context) error {
+	s.dealQuery = api.DealQuery{
+		AssignedId: s.dealQuery.AssignedId,
+		Sort:       s.dealQuery.Sort,
+		SortDesc:   s.dealQuery.SortDesc,
+	}
+	return s.onListDeals(c)
+}
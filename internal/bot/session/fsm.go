@@ -0,0 +1,143 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Small declarative state machine for multi-step dialogs
+// Every state declares what user input it accepts, so handlers do not have to check flags
+
+type stateId string
+
+type fsmState struct {
+	onText      tele.HandlerFunc            // Handles text messages - initial state's handler is used if nil
+	acceptMedia bool                        // Photos, documents and voices are passed to onText too
+	callbacks   map[string]tele.HandlerFunc // Button handlers by unique that are active only in this state
+	timeout     time.Duration               // Machine returns to initial state after timeout if it is not 0
+	onTimeout   func() error                // Is called after timeout
+}
+
+type fsm struct {
+	logger *slog.Logger
+	mutex  sync.Mutex   // Timeouts fire from other goroutines
+	run    func(func()) // Runs timeout between user's handlers
+
+	initial stateId
+	states  map[stateId]fsmState
+	current stateId
+	timer   *time.Timer // Timeout of current state
+}
+
+func newFsm(logger *slog.Logger, run func(func()), initial stateId, states map[stateId]fsmState) *fsm {
+	if _, ok := states[initial]; !ok {
+		panic(fmt.Sprintf("fsm: initial state %s is not declared", initial))
+	}
+	return &fsm{
+		logger:  logger,
+		run:     run,
+		initial: initial,
+		states:  states,
+		current: initial,
+	}
+}
+
+func (m *fsm) Current() stateId {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.current
+}
+
+// Switches to the state and starts its timeout
+func (m *fsm) Transition(to stateId) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	st, ok := m.states[to]
+	if !ok {
+		return fmt.Errorf("fsm: unknown state %s", to)
+	}
+	m.logger.Debug("fsm transition", "from", m.current, "to", to)
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.current = to
+
+	if st.timeout > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(st.timeout, func() {
+			m.run(func() { m.timeout(timer, st) })
+		})
+		m.timer = timer
+	}
+	return nil
+}
+
+// Returns to initial state if timer is still the one of current state
+func (m *fsm) timeout(timer *time.Timer, st fsmState) {
+	m.mutex.Lock()
+	if m.timer != timer { // State was changed before timeout
+		m.mutex.Unlock()
+		return
+	}
+	m.logger.Debug("fsm timeout", "state", m.current)
+	m.current = m.initial
+	m.timer = nil
+	m.mutex.Unlock()
+
+	if st.onTimeout != nil {
+		if err := st.onTimeout(); err != nil {
+			m.logger.Warn(fmt.Sprintf("fsm timeout handler: %s", err.Error()))
+		}
+	}
+}
+
+// Returns to initial state
+// Returns false if machine is already there
+func (m *fsm) Cancel() (bool, error) {
+	if m.Current() == m.initial {
+		return false, nil
+	}
+	return true, m.Transition(m.initial)
+}
+
+// Stops timeout and returns to initial state without any handlers
+// Is used when session is stopped
+func (m *fsm) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.current = m.initial
+}
+
+// Passes text or media message to current state handler
+func (m *fsm) HandleMessage(c tele.Context) error {
+	m.mutex.Lock()
+	st := m.states[m.current]
+	initial := m.states[m.initial]
+	m.mutex.Unlock()
+
+	isMedia := c.Message() != nil && (c.Message().Photo != nil || c.Message().Document != nil || c.Message().Voice != nil)
+	if st.onText == nil || (isMedia && !st.acceptMedia) {
+		st = initial
+	}
+	if st.onText == nil {
+		return nil
+	}
+	return st.onText(c)
+}
+
+// Returns button handler of current state
+func (m *fsm) Callback(unique string) tele.HandlerFunc {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.states[m.current].callbacks[unique]
+}
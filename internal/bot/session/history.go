@@ -0,0 +1,171 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Deal history(timeline) screens
+
+const (
+	historyPageLimit  = 3500 // Telegram allows 4096 chars per message - leave some space for header
+	historyEntryLimit = 800  // One entry is cut if it is longer
+)
+
+type historyPayload struct {
+	deal        bxtypes.Deal
+	dealPayload string            // Payload of the deal buttons - to go to comment from history
+	pages       [][]template.HTML // Rendered entries of every page
+}
+
+// Loads deal timeline and shows the first page
+func (s *session) onDealHistory(c tele.Context) error {
+	// Decode payload
+	tag, err := decodeTag(c.Data())
+	if err != nil {
+		s.logger.Debug("decode tag err")
+		return s.sendError(c, err) // Already typed err
+	}
+	deal, err := s.deal.Get(tag)
+	if err != nil {
+		s.logger.Debug("get deal invalid tag")
+		return s.sendError(c, err) // Already typed err
+	}
+
+	// Request timeline
+	entries, err := s.bxUser.ListDealTimeline(deal.Id)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	// Case when history is empty
+	if len(entries) == 0 {
+		msg, e := s.bot.Send(c.Chat(), s.tr.Tr("history.empty"))
+		if e != nil {
+			return s.sendError(c, e)
+		}
+		go func() {
+			time.Sleep(3 * time.Second)
+			s.bot.Delete(msg)
+		}()
+		return nil
+	}
+
+	pages, err := s.splitHistoryPages(entries)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	historyTag := s.history.Set(historyPayload{
+		deal:        deal,
+		dealPayload: c.Data(),
+		pages:       pages,
+	})
+	return s.showHistoryPage(c, historyTag, 0)
+}
+
+// Handles page switch buttons
+func (s *session) onHistoryPage(c tele.Context) error {
+	tag, i, err := decodeTagWithI(c.Data())
+	if err != nil {
+		s.logger.Debug("decode tag with I err")
+		return s.sendError(c, err) // Already typed err
+	}
+	return s.showHistoryPage(c, tag, i)
+}
+
+func (s *session) showHistoryPage(c tele.Context, tag Tag, page int) error {
+	history, err := s.history.Get(tag)
+	if err != nil {
+		s.logger.Debug("get history invalid tag")
+		return s.sendError(c, err) // Already typed err
+	}
+	if page >= len(history.pages) { // To be sure its ok
+		return s.sendError(c, fmt.Errorf("invalid history page"))
+	}
+
+	// Navigation buttons
+	tagBytes := tag.Bytes()
+	pagePayload := func(i int) string {
+		iBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(iBytes, uint32(i))
+		return hex.EncodeToString(append(tagBytes[:], iBytes...))
+	}
+	btns := []inlineBtnWithHandlerDescr{}
+	if page > 0 {
+		btns = append(btns, inlineBtnWithHandlerDescr{
+			text:    s.tr.Tr("history.newerBtn"),
+			unique:  "historyNewer",
+			handler: s.onHistoryPage,
+			payload: pagePayload(page - 1),
+		})
+	}
+	if page+1 < len(history.pages) {
+		btns = append(btns, inlineBtnWithHandlerDescr{
+			text:    s.tr.Tr("history.olderBtn"),
+			unique:  "historyOlder",
+			handler: s.onHistoryPage,
+			payload: pagePayload(page + 1),
+		})
+	}
+	btns = append(btns, inlineBtnWithHandlerDescr{
+		text:    s.tr.Tr("deal.addCommentBtn"),
+		unique:  "addComment" + history.deal.Id.String(),
+		handler: s.onWriteComment,
+		payload: history.dealPayload,
+	})
+	menu, err := creatInlineMenuWithHandler(s.handlers, btns)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	text, err := s.views.Render(s.tr, screens.HistoryPage, screens.HistoryPageView{
+		Deal:    history.deal,
+		Page:    page + 1,
+		Pages:   len(history.pages),
+		Entries: history.pages[page],
+	})
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return s.show(c, "history", text, menu)
+}
+
+// Renders entries and splits them into pages that fit into one message
+func (s *session) splitHistoryPages(entries []bxtypes.TimelineEntry) ([][]template.HTML, error) {
+	pages := [][]template.HTML{}
+	page := []template.HTML{}
+	pageLen := 0
+	for _, e := range entries {
+		text := []rune(e.Text)
+		if len(text) > historyEntryLimit {
+			text = append(text[:historyEntryLimit], '…')
+		}
+		entry, err := s.views.Render(s.tr, screens.HistoryEntry, screens.HistoryEntryView{
+			Entry:    e,
+			Activity: e.Kind == bxtypes.TimelineEntryActivity,
+			Text:     string(text),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page) > 0 && pageLen+len(entry) > historyPageLimit {
+			pages = append(pages, page)
+			page = []template.HTML{}
+			pageLen = 0
+		}
+		page = append(page, template.HTML(entry)) // Is already escaped by template
+		pageLen += len(entry)
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
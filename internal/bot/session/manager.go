@@ -2,57 +2,113 @@ package session
 
 import (
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
 
 	tele "gopkg.in/telebot.v4"
 )
 
+// Settings that are common for all sessions
+type ManagerDescriptor struct {
+	Views        *screens.Renderer
+	Audit        api.AuditLog
+	UndoWindow   time.Duration               // How long changes could be undone, zero disables undo
+	ConfirmRoles map[string][]api.Role       // Roles that confirm task completion by portal
+	Serialize    func(tgId int64, fn func()) // Runs fn between handlers of the user - is used by timeouts, fn is run at once if nil
+}
+
 // Manages start/stop of sessions
 type sessionManager struct {
 	logger *slog.Logger
 	bot    *tele.Bot
 	group  *tele.Group
+	descr  ManagerDescriptor
 
+	mutex sync.RWMutex // Users are accessed from handlers of different users and metrics scrapes
 	users map[int64]*session
 }
 
-func NewManager(logger *slog.Logger, bot *tele.Bot, group *tele.Group) api.SessionManager {
+func NewManager(logger *slog.Logger, bot *tele.Bot, group *tele.Group, descr ManagerDescriptor) api.SessionManager {
 	m := &sessionManager{
 		logger: logger,
 		bot:    bot,
 		group:  group,
+		descr:  descr,
 
 		users: map[int64]*session{},
 	}
 
+	// All session input is dispatched by sender
+	for _, endpoint := range []string{tele.OnText, tele.OnPhoto, tele.OnDocument, tele.OnVoice} {
+		group.Handle(endpoint, m.onMessage)
+	}
+	group.Handle(tele.OnCallback, m.onCallback)
+
 	return m
 }
 
+func (m *sessionManager) onMessage(c tele.Context) error {
+	s := m.get(c.Sender().ID)
+	if s == nil { // Session middleware creates it before, just to be sure
+		return nil
+	}
+	return s.flow.HandleMessage(c)
+}
+
+func (m *sessionManager) onCallback(c tele.Context) error {
+	s := m.get(c.Sender().ID)
+	if s == nil {
+		return nil
+	}
+	return s.onCallback(c)
+}
+
+func (m *sessionManager) get(tgId int64) *session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.users[tgId]
+}
+
 func (m *sessionManager) Exist(tgId int64) bool {
-	_, exists := m.users[tgId]
-	return exists
+	return m.get(tgId) != nil
 }
 
 func (m *sessionManager) Get(tgId int64) api.Session {
-	return m.users[tgId]
+	if s := m.get(tgId); s != nil {
+		return s
+	}
+	return nil // Typed nil pointer must not leak into interface
+}
+
+func (m *sessionManager) Count() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.users)
 }
 
-func (m *sessionManager) Start(tgId int64, u api.BxUser) api.Session {
+func (m *sessionManager) Start(tgId int64, portal string, u api.BxUser, tr api.Localizer, role api.Role) api.Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	// If session exists return it
 	if s := m.users[tgId]; s != nil {
 		m.logger.Warn("trying to start session that already exists", "tgId", tgId)
 		return s
 	}
-	s := createSession(m.logger.With("tgId", tgId), m.bot, m.group, u)
+	s := createSession(m.logger.With("tgId", tgId, "portal", portal), m.bot, m.descr, tgId, portal, u, tr, role)
 	m.users[tgId] = s
 	return s
 }
 
 func (m *sessionManager) Stop(tgId int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if m.users[tgId] == nil {
 		m.logger.Warn("trying to stop session that does not exist", "tgId", tgId)
 		return
 	}
+	m.users[tgId].flow.Reset() // So timeouts will not fire
 	delete(m.users, tgId)
 }
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"errors"
+	"slices"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Screens navigation
+// Every screen edits the same message(prevMsg) in place, new message is sent only if editing is impossible
+// Shown screens are kept in stack so back button can return to the previous one without requests to bitrix
+
+type screen struct {
+	id        string                   // Showing screen with the same id returns stack to it
+	text      string                   // Message text
+	menu      func() *tele.ReplyMarkup // Builds keyboard - is called on every render because paginator needs new tag
+	transient bool                     // Prompts that are dropped from stack when next screen is shown
+}
+
+// Shows screen with static menu
+func (s *session) show(c tele.Context, id string, text string, menu *tele.ReplyMarkup) error {
+	return s.showScreen(c, screen{
+		id:   id,
+		text: text,
+		menu: func() *tele.ReplyMarkup { return menu },
+	})
+}
+
+// Shows screen with paginated menu
+func (s *session) showPaginated(c tele.Context, id string, text string, p *paginator) error {
+	return s.showScreen(c, screen{
+		id:   id,
+		text: text,
+		menu: func() *tele.ReplyMarkup { return p.menu(s, s.paginator.Set(p), p.page) },
+	})
+}
+
+// Shows text prompt - waits for user's message
+func (s *session) showPrompt(c tele.Context, id string, text string) error {
+	return s.showScreen(c, screen{
+		id:        id,
+		text:      text,
+		menu:      func() *tele.ReplyMarkup { return &tele.ReplyMarkup{} },
+		transient: true,
+	})
+}
+
+// Pushes screen to stack and renders it
+func (s *session) showScreen(c tele.Context, scr screen) error {
+	// Return to the screen with the same id if it is already in stack
+	if i := slices.IndexFunc(s.screens, func(o screen) bool { return o.id == scr.id }); i >= 0 {
+		s.screens = s.screens[:i]
+	}
+	// Drop prompt
+	if l := len(s.screens); l > 0 && s.screens[l-1].transient {
+		s.screens = s.screens[:l-1]
+	}
+	s.screens = append(s.screens, scr)
+	return s.render(c, scr)
+}
+
+// Edits previous message or sends new one if it is impossible
+func (s *session) render(c tele.Context, scr screen) error {
+	s.screenId.Store(scr.id)
+	menu := s.withBackBtn(scr.menu())
+	if s.prevMsg != nil {
+		_, err := s.bot.Edit(s.prevMsg, scr.text, menu)
+		if err == nil || errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+			return nil
+		}
+		s.logger.Debug("edit screen failed - resend", "err", err.Error())
+		s.clearPrev()
+	}
+	return s.ask(c, scr.text, menu)
+}
+
+// Returns copy of menu with back button row if there is a screen to return to
+func (s *session) withBackBtn(menu *tele.ReplyMarkup) *tele.ReplyMarkup {
+	if len(s.screens) < 2 {
+		return menu
+	}
+	backMenu := &tele.ReplyMarkup{}
+	backBtn := backMenu.Data(s.tr.Tr("common.back"), "navBack")
+	s.handlers.Handle(&backBtn, s.onBack)
+	backMenu.InlineKeyboard = append(slices.Clone(menu.InlineKeyboard), []tele.InlineButton{*backBtn.Inline()})
+	return backMenu
+}
+
+// Returns to the previous screen
+func (s *session) onBack(c tele.Context) error {
+	// Any dialog is cancelled
+	s.flow.Transition(stateIdle)
+
+	if len(s.screens) < 2 {
+		return nil
+	}
+	s.screens = s.screens[:len(s.screens)-1]
+	return s.render(c, s.screens[len(s.screens)-1])
+}
+
+// Closes prompt screen if it is shown
+func (s *session) closePrompt(c tele.Context) error {
+	if l := len(s.screens); l > 0 && s.screens[l-1].transient {
+		return s.onBack(c)
+	}
+	return nil
+}
+
+// Clears navigation stack - next screen will be the root one
+func (s *session) resetNavigation() {
+	s.screens = nil
+	s.screenId.Store("")
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Buttons of notifications that are sent outside of session(bitrix events)
+// Notification could be pressed in any later session so payload is bitrix id, not tag - it is short enough
+
+var (
+	OpenDealBtn     = tele.Btn{Unique: "openDeal"}     // Payload is deal id
+	CompleteTaskBtn = tele.Btn{Unique: "completeTask"} // Payload is task id
+)
+
+// Shows deal from notification
+func (s *session) onOpenDeal(c tele.Context) error {
+	dealId, err := strconv.Atoi(c.Data())
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: s.tr.Tr("common.btnExpired")})
+	}
+	deal, err := s.bxUser.GetDeal(bxtypes.Id(dealId))
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	// Deal could be reassigned since notification was sent
+	if ok, err := s.canAccess(c, deal.AssignedId, dealEntity(deal.Id)); !ok {
+		if err != nil {
+			return s.sendError(c, err)
+		}
+		return nil
+	}
+
+	s.flow.Transition(stateIdle)
+	s.clearPrev() // Deal is shown under the notification
+	s.resetNavigation()
+	return s.showDeal(c, deal.Id)
+}
+
+// Completes task from notification
+func (s *session) onCompleteTaskById(c tele.Context) error {
+	taskId, err := strconv.Atoi(c.Data())
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: s.tr.Tr("common.btnExpired")})
+	}
+	task, err := s.bxUser.GetTask(bxtypes.Id(taskId))
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if task.Status == bxtypes.TaskStateCompleted {
+		return c.Respond(&tele.CallbackResponse{Text: s.tr.Tr("tasks.alreadyCompleted")})
+	}
+	if ok, err := s.canAccess(c, task.ResponsibleId, taskEntity(task.Id)); !ok {
+		if err != nil {
+			return s.sendError(c, err)
+		}
+		return nil
+	}
+
+	// Deal is needed for report and supervisor's note, task could have no deal
+	deal := bxtypes.NilDeal
+	if dealId := task.DealId(); dealId != 0 {
+		if deal, err = s.bxUser.GetDeal(dealId); err != nil {
+			return s.sendError(c, err)
+		}
+	}
+
+	// Pass the task like it was selected from deal tasks list
+	s.flow.Transition(stateIdle)
+	s.clearPrev()
+	s.resetNavigation()
+	tagBytes := s.dealTasks.Set(tasksPayload{deal: deal, tasks: []bxtypes.Task{task}}).Bytes()
+	payload := binary.LittleEndian.AppendUint32(tagBytes[:], 0)
+	return s.askCompleteTask(c, hex.EncodeToString(payload))
+}
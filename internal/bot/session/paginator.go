@@ -0,0 +1,132 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Paginated inline keyboard
+// Long lists are split into pages with prev/next/page number controls
+// Page change edits the message in place instead of resending it
+
+const defaultPageSize = 8
+
+const paginatorsKept = 8 // Lists in older messages stay pageable
+
+type paginator struct {
+	btns     []inlineBtnDescr
+	handler  tele.HandlerFunc // Handler for all item buttons - nil if they are handled by fsm state
+	pageSize int
+	columns  int                          // 1 or 2
+	footer   func(menu *tele.ReplyMarkup) // Optional - adds rows after navigation row
+	page     int                          // Current page - back navigation returns to it
+}
+
+type paginatorDescr struct {
+	pageSize int // defaultPageSize if 0
+	columns  int // 1 if 0
+	footer   func(menu *tele.ReplyMarkup)
+}
+
+func newPaginator(handler tele.HandlerFunc, btns []inlineBtnDescr, descr paginatorDescr) *paginator {
+	p := &paginator{
+		btns:     btns,
+		handler:  handler,
+		pageSize: descr.pageSize,
+		columns:  descr.columns,
+		footer:   descr.footer,
+	}
+	if p.pageSize <= 0 {
+		p.pageSize = defaultPageSize
+	}
+	if p.columns <= 0 {
+		p.columns = 1
+	}
+	return p
+}
+
+func (p *paginator) pagesCount() int {
+	return max(1, (len(p.btns)+p.pageSize-1)/p.pageSize)
+}
+
+// Builds keyboard for the page
+// Tag is the tag of paginator in session so navigation buttons can find it
+func (p *paginator) menu(s *session, tag Tag, page int) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{}
+
+	// Items
+	from := page * p.pageSize
+	to := min(from+p.pageSize, len(p.btns))
+	row := tele.Row{}
+	for _, b := range p.btns[from:to] {
+		btn := menu.Data(b.text, b.unique, b.payload)
+		if p.handler != nil {
+			s.handlers.Handle(&btn, p.handler)
+		}
+		row = append(row, btn)
+		if len(row) == p.columns {
+			rows = append(rows, row)
+			row = tele.Row{}
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	// Navigation
+	if count := p.pagesCount(); count > 1 {
+		tagBytes := tag.Bytes()
+		pagePayload := func(i int) string {
+			iBytes := make([]byte, 4)
+			binary.LittleEndian.PutUint32(iBytes, uint32(i))
+			return hex.EncodeToString(append(tagBytes[:], iBytes...))
+		}
+		nav := tele.Row{}
+		if page > 0 {
+			prevBtn := menu.Data("‹", "pagePrev", pagePayload(page-1))
+			s.handlers.Handle(&prevBtn, s.onPaginatorPage)
+			nav = append(nav, prevBtn)
+		}
+		numBtn := menu.Data(fmt.Sprintf("%d/%d", page+1, count), "pageNum")
+		s.handlers.Handle(&numBtn, func(c tele.Context) error { return nil }) // Just an indicator
+		nav = append(nav, numBtn)
+		if page+1 < count {
+			nextBtn := menu.Data("›", "pageNext", pagePayload(page+1))
+			s.handlers.Handle(&nextBtn, s.onPaginatorPage)
+			nav = append(nav, nextBtn)
+		}
+		rows = append(rows, nav)
+	}
+	menu.Inline(rows...)
+
+	if p.footer != nil {
+		p.footer(menu)
+	}
+	return menu
+}
+
+// Handles page switch - edits keyboard of the message
+func (s *session) onPaginatorPage(c tele.Context) error {
+	tag, page, err := decodeTagWithI(c.Data())
+	if err != nil {
+		s.logger.Debug("decode tag with I err")
+		return s.sendError(c, err) // Already typed err
+	}
+	p, err := s.paginator.Get(tag)
+	if err != nil {
+		s.logger.Debug("get paginator invalid tag")
+		return s.sendError(c, err) // Already typed err
+	}
+	if page >= p.pagesCount() { // To be sure its ok
+		return s.sendError(c, fmt.Errorf("invalid page"))
+	}
+	p.page = page
+	if _, err := s.bot.EditReplyMarkup(c.Message(), s.withBackBtn(p.menu(s, tag, page))); err != nil {
+		return s.sendError(c, err)
+	}
+	return nil
+}
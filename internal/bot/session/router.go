@@ -0,0 +1,78 @@
+package session
+
+import (
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Per session button handlers
+// Buttons are not registered in telebot itself because then sessions would overwrite each other's handlers
+// Manager catches all callbacks and passes them to the sender's session
+// Uniques contain ids of deals and tasks, so handlers are kept for two generations of registrations - buttons of old messages expire
+// Handlers of notification buttons are permanent because notifications come at any time
+
+const routerGeneration = 256 // Registrations before the oldest handlers are dropped
+
+type router struct {
+	permanent map[string]tele.HandlerFunc
+	recent    map[string]tele.HandlerFunc // Handlers by button unique
+	old       map[string]tele.HandlerFunc // Previous generation
+}
+
+func newRouter() *router {
+	return &router{
+		permanent: map[string]tele.HandlerFunc{},
+		recent:    map[string]tele.HandlerFunc{},
+		old:       map[string]tele.HandlerFunc{},
+	}
+}
+
+func (r *router) Handle(btn *tele.Btn, handler tele.HandlerFunc) {
+	if len(r.recent) >= routerGeneration {
+		r.old = r.recent
+		r.recent = map[string]tele.HandlerFunc{}
+	}
+	r.recent[btn.Unique] = handler
+}
+
+// Registers handler that never expires
+func (r *router) HandlePermanent(btn *tele.Btn, handler tele.HandlerFunc) {
+	r.permanent[btn.Unique] = handler
+}
+
+func (r *router) get(unique string) tele.HandlerFunc {
+	if handler := r.recent[unique]; handler != nil {
+		return handler
+	}
+	if handler := r.old[unique]; handler != nil {
+		return handler
+	}
+	return r.permanent[unique]
+}
+
+// Parses raw callback data(\funique|payload) that telebot leaves if no handler is registered
+// Sets unique and payload to callback so c.Data() works as usual
+func parseCallback(c tele.Context) string {
+	cb := c.Callback()
+	data := strings.TrimPrefix(cb.Data, "\f")
+	unique, payload, _ := strings.Cut(data, "|")
+	cb.Unique = unique
+	cb.Data = payload
+	return unique
+}
+
+// Handles all callbacks of the session
+// Buttons of current fsm state are checked first
+func (s *session) onCallback(c tele.Context) error {
+	unique := parseCallback(c)
+	handler := s.flow.Callback(unique)
+	if handler == nil {
+		handler = s.handlers.get(unique)
+	}
+	if handler == nil {
+		s.logger.Debug("unknown callback", "unique", unique)
+		return c.Respond(&tele.CallbackResponse{Text: s.tr.Tr("common.btnExpired")})
+	}
+	return handler(c)
+}
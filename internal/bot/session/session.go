@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"log/slog"
 	"regexp"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/internal/bot/screens"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
 
 	tele "gopkg.in/telebot.v4"
@@ -16,26 +19,50 @@ import (
 
 type session struct {
 	logger *slog.Logger
-	bot    *tele.Bot   // Because the only way to send a message and get beck it's sign is through this var
-	group  *tele.Group // Group for sessions' endpoints
+	bot    *tele.Bot // Because the only way to send a message and get beck it's sign is through this var
+	views  *screens.Renderer
+	audit  api.AuditLog // Records all bitrix changes
 
-	// tgID   int64
-	bxUser api.BxUser
+	handlers *router      // Buttons handlers of this session
+	flow     *fsm         // Current dialog state - what input is expected
+	run      func(func()) // Runs timers between user's handlers, so they do not race with them
+
+	tgId    int64
+	portal  string // Bitrix portal of the user
+	bxUser  api.BxUser
+	tr      api.Localizer // User's language
+	role    api.Role
+	started time.Time
 
 	// Dynamic data
 
+	// Deals filter - is kept between list requests
+	dealQuery api.DealQuery
+
 	// Payload
 	deals     TaggedVar[[]bxtypes.Deal]
 	deal      TaggedVar[bxtypes.Deal]
 	dealTasks TaggedVar[tasksPayload]
+	history   TaggedVar[historyPayload]
+	paginator TaggedVar[*paginator] // Last shown paginated lists
+	team      TaggedVar[[]bxtypes.User]
+
+	// Employee whose deals are browsed by supervisor - nil for own deals
+	assignee *bxtypes.User
+	names    map[bxtypes.Id]string // Names of other assignees - deals of subordinates are opened from notifications too
+
+	// Undo of changes
+	undoWindow      time.Duration
+	undos           map[Tag]undoAction // Actions that could be undone by tag from button payload
+	confirmComplete bool               // Task completion is confirmed for user's role
 
 	// Comment - the difficulty is that the msg is just text
-	waitingForComment bool          // Writing comment is toggled and now I waiting for text message that will be treated like a comment
-	writeCommentMsg   tele.Editable // For future deletion
-	addCommentPayload string        // Exception - supposed to be in msg data field
+	addCommentPayload string // Exception - supposed to be in msg data field
 
-	// Previous request msg for deletion
-	prevMsg tele.Editable
+	// Navigation
+	prevMsg  tele.Editable // Message with current screen - is edited on navigation
+	screens  []screen      // Stack of shown screens for back button
+	screenId atomic.Value  // Id of current screen - status is read from handlers of admins
 }
 
 // Supplement structures
@@ -59,54 +86,134 @@ type tasksPayload struct {
 	tasks []bxtypes.Task
 }
 
+// Dialog states
+const (
+	stateIdle           = stateId("idle")
+	stateWritingComment = stateId("writingComment")
+	stateSearchingDeals = stateId("searchingDeals")
+	stateSelectingTask  = stateId("selectingTask")
+)
+
+// How long user's text input is waited
+const inputTimeout = 10 * time.Minute
+
 // Create session function
-func createSession(logger *slog.Logger, bot *tele.Bot, group *tele.Group, user api.BxUser) *session {
+func createSession(logger *slog.Logger, bot *tele.Bot, descr ManagerDescriptor, tgId int64, portal string, user api.BxUser, tr api.Localizer, role api.Role) *session {
 	s := &session{
-		logger: logger,
-		bot:    bot,
-		group:  group,
-		bxUser: user,
+		logger:   logger,
+		bot:      bot,
+		views:    descr.Views,
+		audit:    descr.Audit,
+		handlers: newRouter(),
+		tgId:     tgId,
+		portal:   portal,
+		bxUser:   user,
+		tr:       tr,
+		role:     role,
+		started:  time.Now(),
 
 		// Dynamic data
+		dealQuery: api.DealQuery{Sort: api.DealSortCreated, SortDesc: true},
+
 		deals:     newTaggedVar[[]bxtypes.Deal](),
 		deal:      newTaggedVar[bxtypes.Deal](),
 		dealTasks: newTaggedVar[tasksPayload](),
+		history:   newTaggedVar[historyPayload](),
+		paginator: newTaggedRing[*paginator](paginatorsKept),
+		team:      newTaggedVar[[]bxtypes.User](),
+		names:     map[bxtypes.Id]string{},
 
-		waitingForComment: false,
-		writeCommentMsg:   nil,
 		addCommentPayload: "",
+
+		undoWindow:      descr.UndoWindow,
+		undos:           map[Tag]undoAction{},
+		confirmComplete: slices.Contains(descr.ConfirmRoles[portal], role),
 	}
-	// Setup some handlers
-	s.group.Handle(tele.OnText, s.onAddComment)
+	s.run = func(fn func()) { fn() }
+	if descr.Serialize != nil {
+		s.run = func(fn func()) { descr.Serialize(tgId, fn) }
+	}
+	// Dialog states
+	s.flow = newFsm(logger, s.run, stateIdle, map[stateId]fsmState{
+		stateIdle: {
+			onText: s.onUnexpectedMessage,
+		},
+		stateWritingComment: { // Comment could be a text or a media with caption
+			onText:      s.onAddComment,
+			acceptMedia: true,
+			timeout:     inputTimeout,
+			onTimeout:   s.onInputTimeout,
+		},
+		stateSearchingDeals: {
+			onText:    s.onSearchText,
+			timeout:   inputTimeout,
+			onTimeout: s.onInputTimeout,
+		},
+		stateSelectingTask: {
+			callbacks: map[string]tele.HandlerFunc{
+				"selectTask": s.onCompleteTask,
+			},
+		},
+	})
+	// Buttons of notifications
+	s.handlers.HandlePermanent(&OpenDealBtn, s.onOpenDeal)
+	s.handlers.HandlePermanent(&CompleteTaskBtn, s.onCompleteTaskById)
 
 	return s
 }
 
+// Changes language - is applied from next screen
+func (s *session) SetLocalizer(tr api.Localizer) {
+	s.tr = tr
+}
+
+func (s *session) Role() api.Role {
+	return s.role
+}
+
+func (s *session) Status() api.SessionStatus {
+	status := api.SessionStatus{
+		Started: s.started,
+		State:   string(s.flow.Current()),
+	}
+	status.Screen, _ = s.screenId.Load().(string)
+	return status
+}
+
 // Commands handlers
 // Are named after commands or actions they execute
 
 // Main message - may be consider as help
 func (s *session) OnStart(c tele.Context) error {
-	s.clearPrev()
+	s.flow.Reset() // Any dialog is dropped
+	s.clearPrev()  // Start screen is always sent after the command
+	s.resetNavigation()
 	menu := &tele.ReplyMarkup{}
 
-	listDealsBtn := menu.Data("Показать открытые сделки", "list_deals")
-	s.group.Handle(&listDealsBtn, s.onListDeals)
+	listDealsBtn := menu.Data(s.tr.Tr("start.listDealsBtn"), "list_deals")
+	s.handlers.Handle(&listDealsBtn, s.onListOwnDeals)
+	rows := []tele.Row{menu.Row(listDealsBtn)}
 
-	menu.Inline(
-		menu.Row(listDealsBtn),
-	)
+	if s.role.Can(api.ActionViewTeam) {
+		teamBtn := menu.Data(s.tr.Tr("team.btn"), "listTeam")
+		s.handlers.Handle(&teamBtn, s.onListTeam)
+		rows = append(rows, menu.Row(teamBtn))
+	}
+	menu.Inline(rows...)
 
-	return s.ask(c, fmt.Sprintf("Здравствуйте, %s %s.\nВыберите действие:", s.bxUser.Get().Name, s.bxUser.Get().LastName), menu)
+	text, err := s.views.Render(s.tr, screens.Start, screens.StartView{User: s.bxUser.Get()})
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return s.show(c, "start", text, menu)
 }
 
 // Handles list deals message
 func (s *session) onListDeals(c tele.Context) error {
-	s.clearPrev()
 	s.logger.Debug("on list deals")
 
 	// Get deals
-	deals, err := s.bxUser.ListDeals()
+	deals, err := s.bxUser.ListDeals(s.dealQuery)
 	if err != nil {
 		return s.sendError(c, err)
 	}
@@ -115,9 +222,9 @@ func (s *session) onListDeals(c tele.Context) error {
 	// Store deals
 	tagBytes := s.deals.Set(deals).Bytes()
 
-	// Case when no deals found
-	if len(deals) == 0 {
-		msg, e := s.bot.Send(c.Sender(), "Не найдено открытых сделок.")
+	// Case when no deals found - with filter user still needs buttons to change it
+	if len(deals) == 0 && !s.dealQuery.IsFiltered() {
+		msg, e := s.bot.Send(c.Sender(), s.tr.Tr("deals.empty"))
 		if e != nil {
 			return s.sendError(c, e)
 		}
@@ -143,16 +250,23 @@ func (s *session) onListDeals(c tele.Context) error {
 			payload: hex.EncodeToString(payload),
 		})
 	}
-	menu, err := creatInlineMenu(s.group, s.onDealActions, btnDescrs)
+	p := newPaginator(s.onDealActions, btnDescrs, paginatorDescr{
+		footer: s.appendDealFilterRow,
+	})
+
+	text, err := s.views.Render(s.tr, screens.Deals, screens.DealsView{
+		Assignee:    s.scopeName(),
+		Count:       len(deals),
+		FilterParts: s.dealFilterParts(),
+	})
 	if err != nil {
-		s.sendError(c, err)
+		return s.sendError(c, err)
 	}
-	return s.ask(c, "Выберите сделку:", menu)
+	return s.showPaginated(c, "deals", text, p)
 }
 
 // Shows actions with select deal
 func (s *session) onDealActions(c tele.Context) error {
-	s.clearPrev()
 	// Get deal of the button
 	s.logger.Debug(c.Data())
 	s.logger.Debug(fmt.Sprint([]byte(c.Data())))
@@ -173,56 +287,77 @@ func (s *session) onDealActions(c tele.Context) error {
 	if i >= len(deals) { // To be sure its ok
 		return s.sendError(c, fmt.Errorf("invalid deal index"))
 	}
+	return s.showDeal(c, deals[i].Id)
+}
+
+// Shows deal card with actions
+func (s *session) showDeal(c tele.Context, dealId bxtypes.Id) error {
+	// Load full deal info
+	card, err := loadDealCard(s.logger, s.bxUser, dealId)
+	if err != nil {
+		return s.sendError(c, err)
+	}
 
 	// Save selected deal and encode tag to payload
-	deal := deals[i]
+	deal := card.deal
 	tagBytes := s.deal.Set(deal).Bytes()
 	payload := hex.EncodeToString(tagBytes[:])
 
 	// Create buttons
-	menu, err := creatInlineMenuWithHandler(s.group, []inlineBtnWithHandlerDescr{
+	menu, err := creatInlineMenuWithHandler(s.handlers, []inlineBtnWithHandlerDescr{
 		{
-			text:    "Добавить коментарий",
+			text:    s.tr.Tr("deal.addCommentBtn"),
 			unique:  "addComment" + deal.Id.String(),
 			handler: s.onWriteComment,
 			payload: payload,
 		},
 		{
-			text:    "Показать открытые задачи",
+			text:    s.tr.Tr("deal.listTasksBtn"),
 			unique:  "listTasks" + deal.Id.String(),
 			handler: s.onListTasks,
 			payload: payload,
 		},
+		{
+			text:    s.tr.Tr("deal.historyBtn"),
+			unique:  "dealHistory" + deal.Id.String(),
+			handler: s.onDealHistory,
+			payload: payload,
+		},
 	})
 	if err != nil {
 		s.sendError(c, err)
 	}
-	return s.ask(c, fmt.Sprintf("<b>Сделка</b>: <i>%s</i>\n<b>Статус</b>: <i>%s</i>\n\nВыберите действие:", deal.Title, bxtypes.DealStageText(deal.StageId)), menu)
+	view := card.view(s.tr)
+	view.Assignee = s.foreignAssignee(deal)
+	text, err := s.views.Render(s.tr, screens.DealCard, view)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return s.show(c, "deal", text, menu)
 }
 
 // Asks to write a coomment
 func (s *session) onWriteComment(c tele.Context) error {
-	// Send message
-	msg, err := s.bot.Send(c.Sender(), "Напишите комментарий:")
-	if err != nil {
-		return err
+	if !s.allowed(c, api.ActionComment) {
+		return nil
 	}
 	// Save payload
-	s.writeCommentMsg = msg
 	s.addCommentPayload = c.Data() // Redirect payload from button
-	s.waitingForComment = true
-	return nil
+	if err := s.flow.Transition(stateWritingComment); err != nil {
+		return s.sendError(c, err)
+	}
+	return s.showPrompt(c, "writeComment", s.tr.Tr("comment.prompt"))
 }
 
-// Add written comment to deal
-// Handles all bare text messages
-// Culls them if we do not wait comment
-func (s *session) onAddComment(c tele.Context) error {
-	// Check if it is comment or just text msg
-	if !s.waitingForComment {
-		s.logger.Debug("got text when I don't expect it")
-		defer s.bot.Delete(c.Message()) // Delete received text msg
-		msg, err := s.bot.Send(c.Chat(), "Текстовые сообщения не разрешены.")
+// Cancels current dialog and returns to previous screen
+func (s *session) OnCancel(c tele.Context) error {
+	defer s.bot.Delete(c.Message())
+	cancelled, err := s.flow.Cancel()
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if !cancelled {
+		msg, err := s.bot.Send(c.Chat(), s.tr.Tr("common.noActiveAction"))
 		if err != nil {
 			return s.sendError(c, err)
 		}
@@ -232,19 +367,54 @@ func (s *session) onAddComment(c tele.Context) error {
 		}()
 		return nil
 	}
+	return s.onBack(c)
+}
 
-	// Clear previous
-	s.clearPrev()
-	s.waitingForComment = false // Remove flag before any error
-	if s.writeCommentMsg != nil {
-		if err := s.bot.Delete(s.writeCommentMsg); err != nil {
-			return s.sendError(c, err)
-		}
-		s.writeCommentMsg = nil
+// Is called when user did not write anything in time
+func (s *session) onInputTimeout() error {
+	c := s.userContext()
+	if err := s.closePrompt(c); err != nil {
+		return err
 	}
+	msg, err := s.bot.Send(c.Chat(), s.tr.Tr("common.inputTimeout"))
+	if err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(3 * time.Second)
+		s.bot.Delete(msg)
+	}()
+	return nil
+}
+
+// Culls messages that are not expected in current state
+func (s *session) onUnexpectedMessage(c tele.Context) error {
+	s.logger.Debug("got message when I don't expect it")
+	defer s.bot.Delete(c.Message()) // Delete received msg
+	msg, err := s.bot.Send(c.Chat(), s.tr.Tr("common.unexpectedMessage"))
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	go func() {
+		time.Sleep(3 * time.Second)
+		s.bot.Delete(msg)
+	}()
+	return nil
+}
+
+// Add written comment to deal
+// Handles text and media messages in writing comment state
+func (s *session) onAddComment(c tele.Context) error {
+	// Clear previous - report goes after user's message so next screen is sent as new message
+	s.clearPrev()
+	s.flow.Transition(stateIdle) // Leave state before any error
 	defer s.bot.Delete(c.Message())
+	if !s.allowed(c, api.ActionComment) { // Role is checked again - dialog could last longer than the role
+		return nil
+	}
 
-	s.logger.Debug("onAddComment", "msg", c.Text())
+	text := msgText(c.Message())
+	s.logger.Debug("onAddComment", "msg", text)
 
 	// Decode payload
 	tag, err := decodeTag(s.addCommentPayload)
@@ -258,28 +428,55 @@ func (s *session) onAddComment(c tele.Context) error {
 		return s.sendError(c, err) // Already typed err
 	}
 
+	// Download attachments
+	files, err := s.downloadAttachments(c.Message())
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if text == "" && len(files) > 0 { // Bitrix does not accept empty comments
+		text = files[0].Name
+	}
+
 	// Add comment
-	commentId, err := s.bxUser.AddCommentToDeal(deal.Id, c.Text())
+	commentId, err := s.bxUser.AddCommentToDeal(deal.Id, text, files...)
+	s.record(c, api.ActionComment, dealEntity(deal.Id), commentAuditPayload(text, files), api.AuditResult(err))
 	if err != nil {
 		return s.sendError(c, err)
 	}
-	s.logger.Debug("Added comment", "id", commentId)
+	s.logger.Debug("Added comment", "id", commentId, "files", len(files))
+	assignee := s.foreignAssignee(deal)
+	if assignee != "" { // Comment author is the supervisor
+		s.logger.Info("comment added to subordinate's deal", "deal", deal.Id, "assignedId", deal.AssignedId, "bxId", s.bxUser.Get().Id)
+	}
 
 	// Report status
-	if err = c.Send(fmt.Sprintf("<b>Добавлен комментарий.</b>\n\nСделка: <i>%s</i>\nКомментарий: %s", deal.Title, c.Text())); err != nil {
+	reportView := screens.CommentAddedView{
+		Deal:        deal,
+		Assignee:    assignee,
+		Comment:     text,
+		Attachments: []string{},
+	}
+	for _, f := range files {
+		reportView.Attachments = append(reportView.Attachments, f.Name)
+	}
+	report, err := s.views.Render(s.tr, screens.CommentAdded, reportView)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if err = s.sendWithUndo(c, report, undoAction{kind: undoComment, dealId: deal.Id, id: commentId}); err != nil {
 		return err
 	}
 
 	// Create buttons
-	menu, err := creatInlineMenuWithHandler(s.group, []inlineBtnWithHandlerDescr{
+	menu, err := creatInlineMenuWithHandler(s.handlers, []inlineBtnWithHandlerDescr{
 		{
-			text:    "Да",
+			text:    s.tr.Tr("common.yes"),
 			unique:  "listTasks" + deal.Id.String(),
 			handler: s.onListTasks,
 			payload: s.addCommentPayload, // Contains deal
 		},
 		{
-			text:    "Нет",
+			text:    s.tr.Tr("common.no"),
 			unique:  "goToStart",
 			handler: s.OnEnd,
 		},
@@ -287,12 +484,14 @@ func (s *session) onAddComment(c tele.Context) error {
 	if err != nil {
 		s.sendError(c, err)
 	}
-	return s.ask(c, "Нужно ли закрыть задачу по этой сделке?", menu)
+	return s.show(c, "closeTaskQuestion", s.tr.Tr("comment.closeTaskQuestion"), menu)
 }
 
 // Lists deal tasks
 func (s *session) onListTasks(c tele.Context) error {
-	s.clearPrev()
+	if !s.allowed(c, api.ActionCompleteTask) { // Tasks are listed only to be completed
+		return nil
+	}
 	// Decode payload
 	s.logger.Debug(c.Data())
 	tag, err := decodeTag(c.Data())
@@ -307,14 +506,14 @@ func (s *session) onListTasks(c tele.Context) error {
 	}
 
 	// Request tasks
-	tasks, err := s.bxUser.ListDealTasks(deal.Id)
+	tasks, err := s.bxUser.ListDealTasks(deal.Id, deal.AssignedId)
 	if err != nil {
 		return s.sendError(c, err)
 	}
 
 	// Case when no deals found
 	if len(tasks) == 0 {
-		msg, e := s.bot.Send(c.Chat(), "Нет открытых задач.")
+		msg, e := s.bot.Send(c.Chat(), s.tr.Tr("tasks.empty"))
 		if e != nil {
 			return s.sendError(c, e)
 		}
@@ -341,46 +540,138 @@ func (s *session) onListTasks(c tele.Context) error {
 		payload := append(tagBytes[:], iBytes...)
 		btns = append(btns, inlineBtnDescr{
 			text:    r.ReplaceAllLiteralString(t.Title, ""),
-			unique:  "selectTask" + t.Id.String(),
+			unique:  "selectTask", // Is handled by selecting task state
 			payload: hex.EncodeToString(payload),
 		})
 	}
-	menu, err := creatInlineMenu(s.group, s.onCompleteTask, btns)
-	if err != nil {
+	if err := s.flow.Transition(stateSelectingTask); err != nil {
 		return s.sendError(c, err)
 	}
-	return s.ask(c, "Выберите задачу для завершения:", menu)
+	return s.showPaginated(c, "tasks", s.tr.Tr("tasks.select"), newPaginator(nil, btns, paginatorDescr{}))
 }
 
-// Completes selected task
+// Handles selected task - asks for confirmation first if it is required for user's role
 func (s *session) onCompleteTask(c tele.Context) error {
-	s.clearPrev()
-	// Decode payload
-	tag, i, err := decodeTagWithI(c.Data())
+	s.flow.Transition(stateIdle)
+	return s.askCompleteTask(c, c.Data())
+}
+
+// Completes task of the payload(deal tasks tag, id) or asks for confirmation
+func (s *session) askCompleteTask(c tele.Context, data string) error {
+	if !s.allowed(c, api.ActionCompleteTask) {
+		return nil
+	}
+	payload, task, err := s.selectedTask(data)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if !s.confirmComplete {
+		return s.completeTask(c, payload.deal, task)
+	}
+
+	menu, err := creatInlineMenuWithHandler(s.handlers, []inlineBtnWithHandlerDescr{
+		{
+			text:    s.tr.Tr("common.yes"),
+			unique:  "confirmComplete",
+			handler: s.onConfirmCompleteTask,
+			payload: data, // Contains task
+		},
+		{
+			text:    s.tr.Tr("common.no"),
+			unique:  "cancelComplete",
+			handler: s.onCancelCompleteTask,
+		},
+	})
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	text, err := s.views.Render(s.tr, screens.TaskConfirm, screens.TaskConfirmView{
+		Deal:     payload.deal,
+		Assignee: s.foreignAssignee(payload.deal),
+		Task:     task,
+	})
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return s.show(c, "confirmComplete", text, menu)
+}
+
+func (s *session) onConfirmCompleteTask(c tele.Context) error {
+	if !s.allowed(c, api.ActionCompleteTask) {
+		return nil
+	}
+	payload, task, err := s.selectedTask(c.Data())
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return s.completeTask(c, payload.deal, task)
+}
+
+// Returns to tasks list
+func (s *session) onCancelCompleteTask(c tele.Context) error {
+	if len(s.screens) < 2 { // Task was selected from notification - there is no list
+		s.resetNavigation()
+		return s.clearPrev()
+	}
+	if err := s.onBack(c); err != nil {
+		return err
+	}
+	return s.flow.Transition(stateSelectingTask)
+}
+
+// Decodes task button payload
+func (s *session) selectedTask(data string) (tasksPayload, bxtypes.Task, error) {
+	tag, i, err := decodeTagWithI(data)
 	if err != nil {
 		s.logger.Debug("decode tag with I err")
-		return s.sendError(c, err) // Already typed err
+		return tasksPayload{}, bxtypes.Task{}, err // Already typed err
 	}
-	tasksPayload, err := s.dealTasks.Get(tag)
+	payload, err := s.dealTasks.Get(tag)
 	if err != nil {
 		s.logger.Debug("get deal tasks invalid tag")
-		return s.sendError(c, err) // Already typed err
+		return tasksPayload{}, bxtypes.Task{}, err // Already typed err
 	}
-	s.logger.Debug(fmt.Sprint(tasksPayload))
-	s.logger.Debug(fmt.Sprint(i))
-	if i >= len(tasksPayload.tasks) { // To be sure its ok
-		return s.sendError(c, fmt.Errorf("invalid task index"))
+	if i >= len(payload.tasks) { // To be sure its ok
+		return tasksPayload{}, bxtypes.Task{}, fmt.Errorf("invalid task index")
 	}
+	return payload, payload.tasks[i], nil
+}
 
-	task := tasksPayload.tasks[i]
+// Completes the task and reports with undo button
+func (s *session) completeTask(c tele.Context, deal bxtypes.Deal, task bxtypes.Task) error {
+	s.clearPrev()
 
 	// Make request
-	if err := s.bxUser.CompleteTask(task.Id); err != nil {
+	err := s.bxUser.CompleteTask(task.Id)
+	s.record(c, api.ActionCompleteTask, taskEntity(task.Id), nil, api.AuditResult(err))
+	if err != nil {
 		return s.sendError(c, err)
 	}
+	undo := undoAction{kind: undoCompleteTask, dealId: deal.Id, id: task.Id}
+
+	// Task is completed by the webhook user so completion on employee's deal is noted in deal timeline
+	assignee := s.foreignAssignee(deal)
+	if assignee != "" {
+		s.logger.Info("task completed on subordinate's deal", "task", task.Id, "deal", deal.Id, "assignedId", deal.AssignedId, "bxId", s.bxUser.Get().Id)
+		note := s.tr.Tr("team.taskCompletedNote", "task", task.Title, "name", userFullName(s.bxUser.Get()))
+		noteId, err := s.bxUser.AddCommentToDeal(deal.Id, note)
+		s.record(c, api.ActionComment, dealEntity(deal.Id), commentAuditPayload(note, nil), api.AuditResult(err))
+		if err != nil {
+			s.logger.Warn("add task completion note", "deal", deal.Id, "err", err.Error())
+		}
+		undo.noteId = noteId
+	}
 
 	// Send report
-	if err := c.Send(fmt.Sprintf("Завершена задача: <i>%s</i>\n\nСделка: <i>%s</i>", task.Title, tasksPayload.deal.Title)); err != nil {
+	report, err := s.views.Render(s.tr, screens.TaskCompleted, screens.TaskCompletedView{
+		Deal:     deal,
+		Assignee: assignee,
+		Task:     task,
+	})
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	if err := s.sendWithUndo(c, report, undo); err != nil {
 		return s.sendError(c, err)
 	}
 
@@ -389,7 +680,8 @@ func (s *session) onCompleteTask(c tele.Context) error {
 
 func (s *session) OnEnd(c tele.Context) error {
 	s.clearPrev()
-	msg, err := s.bot.Send(c.Chat(), "Спасибо.")
+	s.resetNavigation()
+	msg, err := s.bot.Send(c.Chat(), s.tr.Tr("common.thanks"))
 	if err != nil {
 		return s.sendError(c, err)
 	}
@@ -402,49 +694,75 @@ func (s *session) OnEnd(c tele.Context) error {
 
 // Supporting functions
 
-// Creates inline menu
-func creatInlineMenu(group *tele.Group, handler tele.HandlerFunc, btns []inlineBtnDescr) (*tele.ReplyMarkup, error) {
+// Creates inline menu with custom handler for every button
+// Lists with one handler for all buttons are built with paginator
+func creatInlineMenuWithHandler(handlers *router, btns []inlineBtnWithHandlerDescr) (*tele.ReplyMarkup, error) {
 	// Setup buttons
 	rows := []tele.Row{}
 	menu := &tele.ReplyMarkup{}
 	for _, b := range btns {
-		slog.Debug(b.payload)
 		btn := menu.Data(b.text, b.unique, b.payload) // Attach index of deal in deals array
-		group.Handle(&btn, handler)
+		handlers.Handle(&btn, b.handler)
 		rows = append(rows, menu.Row(btn))
 	}
 	menu.Inline(rows...)
 	return menu, nil
 }
 
-// The same thing but with custom handler for every button
-func creatInlineMenuWithHandler(group *tele.Group, btns []inlineBtnWithHandlerDescr) (*tele.ReplyMarkup, error) {
-	// Setup buttons
-	rows := []tele.Row{}
-	menu := &tele.ReplyMarkup{}
-	for _, b := range btns {
-		btn := menu.Data(b.text, b.unique, b.payload) // Attach index of deal in deals array
-		group.Handle(&btn, b.handler)
-		rows = append(rows, menu.Row(btn))
+// Returns localized stage name
+// Unknown stages are named by bitrix id
+func StageText(tr api.Localizer, stageId string) string {
+	if key := "stage." + stageId; tr.Has(key) {
+		return tr.Tr(key)
+	}
+	return bxtypes.DealStageText(stageId)
+}
+
+func (s *session) stageText(stageId string) string {
+	return StageText(s.tr, stageId)
+}
+
+// Checks if user's role allows the action
+// Denied actions are logged and reported to user
+func (s *session) allowed(c tele.Context, action api.Action) bool {
+	if s.role.Can(action) {
+		return true
+	}
+	s.logger.Warn("access denied", "bxId", s.bxUser.Get().Id, "role", s.role, "action", action)
+	s.record(c, action, "", nil, api.AuditResultDenied)
+	s.alert(c, s.tr.Tr("access.denied", "role", api.RoleText(s.tr, s.role)))
+	return false
+}
+
+// Shows alert on button press or sends message
+func (s *session) alert(c tele.Context, text string) {
+	if c.Callback() != nil {
+		c.Respond(&tele.CallbackResponse{Text: text, ShowAlert: true})
+	} else {
+		c.Send(text)
 	}
-	menu.Inline(rows...)
-	return menu, nil
 }
 
 // Function that analise !my !internal errors and log/ sends report
 func (s *session) sendError(c tele.Context, err error) error {
-	addFooter, str := api.ErrorText(err)
+	addFooter, str := api.ErrorText(s.tr, err)
 
 	s.logger.Warn(str)
 
 	if addFooter {
-		str += "\n\nДля перезапуска отправьте команду <code>/start</code>"
+		str += s.tr.Tr("common.restartFooter")
 	}
 
 	return c.Send(str)
 }
 
-// Sends message and saves it for future deletion
+// Context of user's private chat for handlers that are not run by update - timeouts
+func (s *session) userContext() tele.Context {
+	user := &tele.User{ID: s.tgId}
+	return s.bot.NewContext(tele.Update{Message: &tele.Message{Sender: user, Chat: &tele.Chat{ID: s.tgId, Type: tele.ChatPrivate}}})
+}
+
+// Sends message and saves it for future editing/deletion
 func (s *session) ask(c tele.Context, what any, opts ...any) error {
 	msg, err := s.bot.Send(c.Chat(), what, opts...)
 	if err != nil {
@@ -59,6 +59,38 @@ func (v *taggedVar[T]) Set(data T) Tag {
 	return v.tag
 }
 
+// Keeps several last values - messages with older values stay usable
+type taggedRing[T any] struct {
+	values []taggedVar[T] // Oldest first
+	size   int
+}
+
+func newTaggedRing[T any](size int) TaggedVar[T] {
+	return &taggedRing[T]{size: size}
+}
+
+func (r *taggedRing[T]) Get(tag Tag) (T, error) {
+	for _, v := range r.values {
+		if v.tag == tag {
+			return v.data, nil
+		}
+	}
+	var data T
+	if l := len(r.values); l > 0 {
+		data = r.values[l-1].data // The same as taggedVar - the latest data is returned anyway
+	}
+	return data, api.ErrorInvalidTag
+}
+
+func (r *taggedRing[T]) Set(data T) Tag {
+	v := taggedVar[T]{data: data, tag: Tag(uuid.New())}
+	r.values = append(r.values, v)
+	if len(r.values) > r.size {
+		r.values = r.values[len(r.values)-r.size:]
+	}
+	return v.tag
+}
+
 // Some supplement functions because main usage - pack/unpacking to payload string
 
 // Decodes Tag from bytes
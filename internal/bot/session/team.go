@@ -0,0 +1,145 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Supervisor's team screens
+// Supervisor picks an employee and browses his deals like own ones - scope is kept in dealQuery.AssignedId
+// Actions on employee's deals are attributed to supervisor
+
+// Lists subordinates
+func (s *session) onListTeam(c tele.Context) error {
+	if !s.allowed(c, api.ActionViewTeam) {
+		return nil
+	}
+
+	users, err := s.bxUser.ListSubordinates()
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	// Case when user heads no department or it is empty
+	if len(users) == 0 {
+		msg, e := s.bot.Send(c.Chat(), s.tr.Tr("team.empty"))
+		if e != nil {
+			return s.sendError(c, e)
+		}
+		go func() {
+			time.Sleep(3 * time.Second)
+			s.bot.Delete(msg)
+		}()
+		return nil
+	}
+
+	tagBytes := s.team.Set(users).Bytes()
+	btns := []inlineBtnDescr{}
+	for i, u := range users {
+		iBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(iBytes, uint32(i))
+		payload := append(tagBytes[:], iBytes...)
+		btns = append(btns, inlineBtnDescr{
+			text:    userFullName(u),
+			unique:  "teamMember" + u.Id.String(),
+			payload: hex.EncodeToString(payload),
+		})
+	}
+	return s.showPaginated(c, "team", s.tr.Tr("team.select"), newPaginator(s.onSelectTeamMember, btns, paginatorDescr{}))
+}
+
+// Shows deals of selected employee
+func (s *session) onSelectTeamMember(c tele.Context) error {
+	// Decode payload
+	tag, i, err := decodeTagWithI(c.Data())
+	if err != nil {
+		s.logger.Debug("decode tag with I err")
+		return s.sendError(c, err) // Already typed err
+	}
+	users, err := s.team.Get(tag)
+	if err != nil {
+		s.logger.Debug("get team invalid tag")
+		return s.sendError(c, err) // Already typed err
+	}
+	if i >= len(users) { // To be sure its ok
+		return s.sendError(c, fmt.Errorf("invalid team member index"))
+	}
+
+	s.assignee = &users[i]
+	s.dealQuery = api.DealQuery{
+		AssignedId: users[i].Id,
+		Sort:       s.dealQuery.Sort,
+		SortDesc:   s.dealQuery.SortDesc,
+	}
+	return s.onListDeals(c)
+}
+
+// Returns to user's own deals
+func (s *session) onListOwnDeals(c tele.Context) error {
+	s.assignee = nil
+	s.dealQuery.AssignedId = 0
+	return s.onListDeals(c)
+}
+
+// Checks that the deal or task of entity belongs to the user or to his subordinate
+// Notification buttons are pressed after the entity could be reassigned to anybody
+func (s *session) canAccess(c tele.Context, ownerId bxtypes.Id, entity string) (bool, error) {
+	if ownerId == s.bxUser.Get().Id {
+		return true, nil
+	}
+	if !s.allowed(c, api.ActionViewTeam) {
+		return false, nil
+	}
+	team, err := s.bxUser.ListSubordinates()
+	if err != nil {
+		return false, err
+	}
+	if slices.ContainsFunc(team, func(u bxtypes.User) bool { return u.Id == ownerId }) {
+		return true, nil
+	}
+	s.logger.Warn("access denied", "bxId", s.bxUser.Get().Id, "entity", entity, "owner", ownerId)
+	s.record(c, api.ActionViewTeam, entity, nil, api.AuditResultDenied)
+	s.alert(c, s.tr.Tr("access.notTeam"))
+	return false, nil
+}
+
+// Returns name of the deal's assignee if it is not the user - for attribution
+func (s *session) foreignAssignee(deal bxtypes.Deal) string {
+	if deal.AssignedId == 0 || deal.AssignedId == s.bxUser.Get().Id {
+		return ""
+	}
+	if s.assignee != nil && s.assignee.Id == deal.AssignedId {
+		return userFullName(*s.assignee)
+	}
+	if name, ok := s.names[deal.AssignedId]; ok {
+		return name
+	}
+	u, err := s.bxUser.GetUser(deal.AssignedId)
+	if err != nil { // Is not cached - next card tries again
+		s.logger.Debug("get assignee", "id", deal.AssignedId, "err", err.Error())
+		return s.tr.Tr("team.unknownAssignee", "id", deal.AssignedId)
+	}
+	s.names[deal.AssignedId] = userFullName(u)
+	return s.names[deal.AssignedId]
+}
+
+// Name of the current deals scope - empty for own deals
+func (s *session) scopeName() string {
+	if s.dealQuery.AssignedId == 0 || s.assignee == nil {
+		return ""
+	}
+	return userFullName(*s.assignee)
+}
+
+func userFullName(u bxtypes.User) string {
+	return strings.Join(strings.Fields(u.Name+" "+u.LastName), " ")
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+	"github.com/google/uuid"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Undo of comments and task completions
+// Report of the change gets undo button that works for undo window
+// Several actions could be undone at the same time so they are stored by tag in a map, not in tagged var
+
+type undoKind int
+
+const (
+	undoComment undoKind = iota
+	undoCompleteTask
+)
+
+type undoAction struct {
+	kind     undoKind
+	dealId   bxtypes.Id
+	id       bxtypes.Id // Comment or task id
+	noteId   bxtypes.Id // Comment that noted task completion on employee's deal, zero if there is no one
+	text     string     // Report text - is kept to mark it as undone
+	deadline time.Time
+}
+
+// Sends report with undo button, the button is removed when undo window ends
+func (s *session) sendWithUndo(c tele.Context, text string, action undoAction) error {
+	if s.undoWindow <= 0 {
+		return c.Send(text)
+	}
+
+	tag := Tag(uuid.New())
+	tagBytes := tag.Bytes()
+	action.text = text
+	action.deadline = time.Now().Add(s.undoWindow)
+	s.undos[tag] = action
+
+	menu := &tele.ReplyMarkup{}
+	undoBtn := menu.Data(s.tr.Tr("undo.btn"), "undo", hex.EncodeToString(tagBytes[:]))
+	s.handlers.Handle(&undoBtn, s.onUndo)
+	menu.Inline(menu.Row(undoBtn))
+
+	msg, err := s.bot.Send(c.Chat(), text, menu)
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(s.undoWindow, func() {
+		s.run(func() { delete(s.undos, tag) })
+		s.bot.EditReplyMarkup(msg, nil) // Fails if the action was undone - it is fine
+	})
+	return nil
+}
+
+// Reverts the action of the report
+func (s *session) onUndo(c tele.Context) error {
+	if !s.allowed(c, api.ActionUndo) {
+		return nil
+	}
+	tag, err := decodeTag(c.Data())
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	action, ok := s.undos[tag]
+	if !ok || time.Now().After(action.deadline) {
+		delete(s.undos, tag)
+		return c.Respond(&tele.CallbackResponse{Text: s.tr.Tr("undo.expired")})
+	}
+	delete(s.undos, tag) // Undo works only once
+
+	switch action.kind {
+	case undoComment:
+		err = s.bxUser.DeleteDealComment(action.dealId, action.id)
+		s.record(c, api.ActionUndo, commentEntity(action.id), nil, api.AuditResult(err))
+	case undoCompleteTask:
+		err = s.bxUser.RenewTask(action.id)
+		s.record(c, api.ActionUndo, taskEntity(action.id), nil, api.AuditResult(err))
+		if err == nil && action.noteId != 0 {
+			noteErr := s.bxUser.DeleteDealComment(action.dealId, action.noteId)
+			s.record(c, api.ActionUndo, commentEntity(action.noteId), nil, api.AuditResult(noteErr))
+		}
+	}
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	return c.Edit(action.text+"\n\n"+s.tr.Tr("undo.done"), &tele.ReplyMarkup{})
+}
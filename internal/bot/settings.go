@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// JSON Implementation for user settings store
+
+type jsonUserSettingsStore struct {
+	logger *slog.Logger
+
+	filename  string     // Storage filename
+	saveMutex sync.Mutex // Saves are written one by one so an older snapshot does not replace a newer one
+
+	mutex    sync.Mutex                 // Scheduler and events read settings in background
+	settings map[int64]api.UserSettings // Map where keys are tgIds
+}
+
+func NewJsonUserSettingsStore(logger *slog.Logger, filename string) api.UserSettingsStore {
+	// By default they are empty but we will fill them from file if no errors occurs
+	settings := map[int64]api.UserSettings{}
+
+	// Read file
+	if data, err := os.ReadFile(filename); err != nil {
+		logger.Warn(fmt.Sprintf("Error while trying to read user settings json file: %s\nWill create a new file", err.Error()))
+	} else {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			logger.Warn(fmt.Sprintf("Error while trying to parse user settings json file: %s\nWill create a new file", err.Error()))
+		}
+	}
+	return &jsonUserSettingsStore{
+		logger:   logger,
+		filename: filename,
+		settings: settings,
+	}
+}
+
+func (s *jsonUserSettingsStore) Set(tgId int64, settings api.UserSettings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.settings[tgId] = settings
+}
+
+func (s *jsonUserSettingsStore) Get(tgId int64) api.UserSettings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.settings[tgId] // Zero value if there is no settings
+}
+
+func (s *jsonUserSettingsStore) Update(tgId int64, fn func(settings *api.UserSettings)) api.UserSettings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	settings := s.settings[tgId]
+	fn(&settings)
+	s.settings[tgId] = settings
+	return settings
+}
+
+func (s *jsonUserSettingsStore) Save() (outErr error) {
+	defer func() {
+		if outErr != nil {
+			s.logger.Warn("Saving user settings json: " + outErr.Error())
+		}
+	}()
+
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	// Convert to string
+	s.mutex.Lock()
+	data, err := json.Marshal(s.settings)
+	s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshal settings: %w", err)
+	}
+	return writeFile(s.filename, data)
+}
+
+// Replaces file through temp one - reader or crash never sees half written file
+func writeFile(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return fmt.Errorf("write to file: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
+
+func (s *jsonUserSettingsStore) Close() error {
+	return s.Save()
+}
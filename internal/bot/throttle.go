@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"errors"
+	"time"
+)
+
+// Rate limit of bulk messages - broadcasts, digests and reminders share it, so together they do not hit telegram limit
+// of 30 messages per second. Messages that answer users are not limited
+
+const bulkRate = 25 // Messages per second - below telegram limit because handlers send messages too
+
+// Is returned by senders that were interrupted by bot stop
+var errStopped = errors.New("bot is stopped")
+
+type throttle struct {
+	tick *time.Ticker
+}
+
+func newThrottle(rate int) *throttle {
+	return &throttle{tick: time.NewTicker(time.Second / time.Duration(rate))}
+}
+
+// Waits for the next send slot, false if stop is closed before it
+// Ticks are not accumulated while nobody sends, so there is no burst after a pause
+func (t *throttle) wait(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-t.tick.C:
+		return true
+	}
+}
+
+func (t *throttle) close() {
+	t.tick.Stop()
+}
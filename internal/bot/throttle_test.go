@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleRate(t *testing.T) {
+	th := newThrottle(100)
+	defer th.close()
+	stop := make(chan struct{})
+	started := time.Now()
+	for i := 0; i < 10; i++ {
+		if !th.wait(stop) {
+			t.Fatal("wait is interrupted without stop")
+		}
+	}
+	if took := time.Since(started); took < 90*time.Millisecond {
+		t.Errorf("10 slots at 100 per second took %s", took)
+	}
+}
+
+func TestThrottleStop(t *testing.T) {
+	th := newThrottle(1)
+	defer th.close()
+	stop := make(chan struct{})
+	close(stop)
+	started := time.Now()
+	if th.wait(stop) {
+		t.Error("wait returns slot after stop")
+	}
+	if took := time.Since(started); took > 100*time.Millisecond {
+		t.Errorf("wait after stop took %s", took)
+	}
+}
@@ -1,51 +1,226 @@
 package bot
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
 )
 
 // JSON Implementation for users id store
+// Reverse index by bitrix account is built on load and kept in sync on every change
 
 type jsonUsersIdStore struct {
 	logger *slog.Logger
 
-	filename string          // Storage filename
-	ids      map[int64]int64 // Map where keys are tgIds, values are bxIds
+	filename  string     // Storage filename
+	saveMutex sync.Mutex // Saves are written one by one so an older snapshot does not replace a newer one
+
+	mutex sync.Mutex                           // Touch is called from concurrent handlers
+	users map[int64]api.LinkedUser             // Map where keys are tgIds
+	byBx  map[api.BxAccount]map[int64]struct{} // Telegram ids by linked account
 }
 
 func NewJsonUsersIdStore(logger *slog.Logger, filename string) api.UsersIdStore {
 	// By default they are empty but we will fill them from file if no errors occurs
-	ids := map[int64]int64{}
+	users := map[int64]api.LinkedUser{}
 
 	// Read file
 	if data, err := os.ReadFile(filename); err != nil {
 		logger.Warn(fmt.Sprintf("Error while trying to read users id json file: %s\nWill create a new file", err.Error()))
 	} else {
 		// Parsing file
-		// File contains of map[int64]int64 - ids
-		if err := json.Unmarshal(data, &ids); err != nil {
-			logger.Warn(fmt.Sprintf("Error while trying to parse users id json file: %s\nWill create a new file", err.Error()))
+		// File contains of map[int64]LinkedUser, old files contain map[int64]int64 - only ids
+		if err := json.Unmarshal(data, &users); err != nil {
+			ids := map[int64]int64{}
+			if err := json.Unmarshal(data, &ids); err != nil {
+				logger.Warn(fmt.Sprintf("Error while trying to parse users id json file: %s\nWill create a new file", err.Error()))
+			}
+			for tgId, bxId := range ids {
+				users[tgId] = api.LinkedUser{BxAccount: api.BxAccount{BxId: bxId}}
+			}
 		}
 	}
-	return &jsonUsersIdStore{
+	s := &jsonUsersIdStore{
 		logger:   logger,
 		filename: filename,
-		ids:      ids,
+		users:    users,
+		byBx:     map[api.BxAccount]map[int64]struct{}{},
+	}
+	for tgId, u := range users {
+		u.TgId = tgId
+		if !slices.Contains(u.Accounts, u.BxAccount) { // Files before multi-account support
+			u.Accounts = append(u.Accounts, u.BxAccount)
+		}
+		users[tgId] = u
+		for _, acc := range u.Accounts {
+			s.index(tgId, acc)
+		}
+	}
+	return s
+}
+
+// Adds account to reverse index, mutex must be locked
+func (s *jsonUsersIdStore) index(tgId int64, acc api.BxAccount) {
+	if s.byBx[acc] == nil {
+		s.byBx[acc] = map[int64]struct{}{}
+	}
+	s.byBx[acc][tgId] = struct{}{}
+}
+
+// Removes account from reverse index, mutex must be locked
+func (s *jsonUsersIdStore) unindex(tgId int64, acc api.BxAccount) {
+	delete(s.byBx[acc], tgId)
+	if len(s.byBx[acc]) == 0 {
+		delete(s.byBx, acc)
+	}
+}
+
+func (s *jsonUsersIdStore) Set(tgId int64, acc api.BxAccount) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	if !ok {
+		u = api.LinkedUser{TgId: tgId}
 	}
+	if !slices.Contains(u.Accounts, acc) {
+		u.Accounts = append(u.Accounts, acc)
+		s.index(tgId, acc)
+	}
+	u.BxAccount = acc
+	u.LastSeen = time.Now()
+	s.users[tgId] = u
+}
+
+func (s *jsonUsersIdStore) Get(tgId int64) (api.BxAccount, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	return u.BxAccount, ok
 }
 
-func (s *jsonUsersIdStore) Set(tgId int64, bxId int64) {
-	s.ids[tgId] = bxId
+func (s *jsonUsersIdStore) Accounts(tgId int64) []api.BxAccount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.users[tgId].Accounts)
 }
 
-func (s *jsonUsersIdStore) Get(tgId int64) (int64, bool) {
-	id, ok := s.ids[tgId] // Allows only like this
-	return id, ok
+func (s *jsonUsersIdStore) GetByBxId(acc api.BxAccount) []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tgIds := []int64{}
+	for tgId := range s.byBx[acc] {
+		tgIds = append(tgIds, tgId)
+	}
+	return tgIds
+}
+
+func (s *jsonUsersIdStore) Switch(tgId int64, acc api.BxAccount) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	if !ok || !slices.Contains(u.Accounts, acc) {
+		return false
+	}
+	u.BxAccount = acc
+	s.users[tgId] = u
+	return true
+}
+
+func (s *jsonUsersIdStore) Unlink(tgId int64, acc api.BxAccount) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	if !ok {
+		return false
+	}
+	i := slices.Index(u.Accounts, acc)
+	if i < 0 {
+		return false
+	}
+	s.unindex(tgId, acc)
+	u.Accounts = slices.Delete(u.Accounts, i, i+1)
+	if len(u.Accounts) == 0 {
+		delete(s.users, tgId)
+		return true
+	}
+	if u.BxAccount == acc { // The first one becomes active
+		u.BxAccount = u.Accounts[0]
+	}
+	s.users[tgId] = u
+	return true
+}
+
+func (s *jsonUsersIdStore) Touch(tgId int64, username string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	if !ok {
+		return
+	}
+	u.Username = username
+	u.LastSeen = time.Now()
+	u.Blocked = false // User writes to the bot so he unblocked it
+	s.users[tgId] = u
+}
+
+func (s *jsonUsersIdStore) MarkBlocked(tgId int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	if !ok {
+		return
+	}
+	u.Blocked = true
+	s.users[tgId] = u
+}
+
+func (s *jsonUsersIdStore) Delete(tgId int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[tgId]
+	for _, acc := range u.Accounts {
+		s.unindex(tgId, acc)
+	}
+	delete(s.users, tgId)
+	return ok
+}
+
+func (s *jsonUsersIdStore) List() []api.LinkedUser {
+	users := s.snapshot()
+	slices.SortFunc(users, func(a, b api.LinkedUser) int {
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.TgId, b.TgId)
+	})
+	return users
+}
+
+func (s *jsonUsersIdStore) Each(fn func(u api.LinkedUser) bool) {
+	for _, u := range s.snapshot() { // Mutex is not held so fn could use the store
+		if !fn(u) {
+			return
+		}
+	}
+}
+
+// Returns copy of all users
+func (s *jsonUsersIdStore) snapshot() []api.LinkedUser {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	users := make([]api.LinkedUser, 0, len(s.users))
+	for _, u := range s.users {
+		u.Accounts = slices.Clone(u.Accounts)
+		users = append(users, u)
+	}
+	return users
 }
 
 func (s *jsonUsersIdStore) Save() (outErr error) {
@@ -55,24 +230,17 @@ func (s *jsonUsersIdStore) Save() (outErr error) {
 		}
 	}()
 
-	// Convert to string
-	data, err := json.Marshal(s.ids)
-	if err != nil {
-		return fmt.Errorf("marshal ids: %w", err)
-	}
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
 
-	// Open/Create file
-	file, err := os.OpenFile(s.filename, os.O_RDWR|os.O_CREATE, 0777)
+	// Convert to string
+	s.mutex.Lock()
+	data, err := json.Marshal(s.users)
+	s.mutex.Unlock()
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
-	}
-	defer file.Close()
-
-	// Write to file
-	if _, err := file.Write(data); err != nil {
-		return fmt.Errorf("write to file: %w", err)
+		return fmt.Errorf("marshal users: %w", err)
 	}
-	return nil
+	return writeFile(s.filename, data)
 }
 
 func (s *jsonUsersIdStore) Close() (outErr error) {
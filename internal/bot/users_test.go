@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+func sortedTgIds(s api.UsersIdStore, acc api.BxAccount) []int64 {
+	ids := s.GetByBxId(acc)
+	slices.Sort(ids)
+	return ids
+}
+
+func TestUsersReverseIndex(t *testing.T) {
+	s := NewJsonUsersIdStore(slog.Default(), filepath.Join(t.TempDir(), "users.json"))
+	a := api.BxAccount{BxId: 42}
+	b := api.BxAccount{Portal: "north", BxId: 42}
+
+	s.Set(1, a)
+	s.Set(2, a)
+	s.Set(2, b)
+	if got := sortedTgIds(s, a); !slices.Equal(got, []int64{1, 2}) {
+		t.Errorf("after set: GetByBxId(a) = %v", got)
+	}
+	if got := sortedTgIds(s, b); !slices.Equal(got, []int64{2}) {
+		t.Errorf("after set: GetByBxId(b) = %v", got)
+	}
+
+	if !s.Unlink(2, a) {
+		t.Error("linked account is not unlinked")
+	}
+	if s.Unlink(2, a) {
+		t.Error("account is unlinked twice")
+	}
+	if got := sortedTgIds(s, a); !slices.Equal(got, []int64{1}) {
+		t.Errorf("after unlink: GetByBxId(a) = %v", got)
+	}
+	if got := sortedTgIds(s, b); !slices.Equal(got, []int64{2}) {
+		t.Errorf("after unlink: GetByBxId(b) = %v", got)
+	}
+
+	if !s.Delete(1) {
+		t.Error("linked user is not deleted")
+	}
+	if got := s.GetByBxId(a); len(got) != 0 {
+		t.Errorf("after delete: GetByBxId(a) = %v", got)
+	}
+	if s.Delete(1) {
+		t.Error("user is deleted twice")
+	}
+}
+
+func TestUsersUnlinkActive(t *testing.T) {
+	s := NewJsonUsersIdStore(slog.Default(), filepath.Join(t.TempDir(), "users.json"))
+	a := api.BxAccount{BxId: 1}
+	b := api.BxAccount{Portal: "north", BxId: 2}
+	c := api.BxAccount{Portal: "south", BxId: 3}
+	s.Set(1, a)
+	s.Set(1, b)
+	s.Set(1, c) // Active one
+
+	s.Unlink(1, c)
+	if got, ok := s.Get(1); !ok || got != a {
+		t.Errorf("active after unlinking active = %v, %v, want the first linked %v", got, ok, a)
+	}
+	s.Unlink(1, b)
+	if got, _ := s.Get(1); got != a {
+		t.Errorf("active after unlinking inactive = %v, want %v", got, a)
+	}
+	s.Unlink(1, a)
+	if _, ok := s.Get(1); ok {
+		t.Error("user is kept after unlinking the last account")
+	}
+	if got := s.List(); len(got) != 0 {
+		t.Errorf("List() = %v", got)
+	}
+}
+
+func TestUsersLegacyFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(filename, []byte(`{"10":42,"11":43}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := NewJsonUsersIdStore(slog.Default(), filename)
+	if got, ok := s.Get(10); !ok || got != (api.BxAccount{BxId: 42}) {
+		t.Errorf("Get(10) = %v, %v", got, ok)
+	}
+	if got := s.Accounts(11); !slices.Equal(got, []api.BxAccount{{BxId: 43}}) {
+		t.Errorf("Accounts(11) = %v", got)
+	}
+	if got := s.GetByBxId(api.BxAccount{BxId: 43}); !slices.Equal(got, []int64{11}) {
+		t.Errorf("GetByBxId(43) = %v", got)
+	}
+
+	// Migrated file is read back in the new format
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s = NewJsonUsersIdStore(slog.Default(), filename)
+	if got := s.Accounts(10); !slices.Equal(got, []api.BxAccount{{BxId: 42}}) {
+		t.Errorf("after save: Accounts(10) = %v", got)
+	}
+}
+
+func TestUsersConcurrentSave(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	s := NewJsonUsersIdStore(slog.Default(), filename)
+	wg := sync.WaitGroup{}
+	for i := int64(1); i <= 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Set(i, api.BxAccount{BxId: i})
+			s.Save()
+		}()
+	}
+	wg.Wait()
+
+	// The last save has all users whatever order saves were run in
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[int64]api.LinkedUser{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		t.Fatalf("file is broken: %s", err)
+	}
+	if len(users) != 20 {
+		t.Errorf("file has %d users, want 20", len(users))
+	}
+}
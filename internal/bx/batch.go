@@ -0,0 +1,123 @@
+package bx
+
+import (
+	"log/slog"
+	"net/url"
+	"slices"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxclient"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+// Batches and pages of requests - they are shared by wrapper and users
+
+// Bitrix runs up to 50 commands in one batch
+const batchLimit = 50
+
+// Bitrix returns lists by pages of 50 items, longer lists are cut - the bot shows only the newest or nearest ones anyway
+const (
+	listPageSize   = 50
+	listPagesLimit = 20
+)
+
+// Runs commands by batches of batchLimit, failed commands are logged and skipped
+func batchAll[T any](client bxclient.BxClient, logger *slog.Logger, cmd map[string]string) (map[string]T, error) {
+	names := make([]string, 0, len(cmd))
+	for name := range cmd {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	results := map[string]T{}
+	for from := 0; from < len(names); from += batchLimit {
+		chunk := map[string]string{}
+		for _, name := range names[from:min(from+batchLimit, len(names))] {
+			chunk[name] = cmd[name]
+		}
+		resp, err := client.Do("batch", bxtypes.ReqBatch{Cmd: chunk}, &bxtypes.Response[bxtypes.ResBatch]{})
+		if err != nil {
+			return nil, err
+		}
+		res, ok := resp.Result().(*bxtypes.Response[bxtypes.ResBatch])
+		if !ok {
+			return nil, api.ErrorParseResponse
+		}
+		part, err := bxtypes.BatchMap[T](res.Result.Result)
+		if err != nil {
+			return nil, api.ErrorParseResponse
+		}
+		if errs, _ := bxtypes.BatchMap[bxtypes.ResponseError](res.Result.Errors); len(errs) > 0 {
+			for name, e := range errs { // One is enough - usually all commands fail for the same reason
+				logger.Warn("batch", "cmd", name, "err", e.Error())
+				break
+			}
+		}
+		for name, r := range part {
+			results[name] = r
+		}
+	}
+	return results, nil
+}
+
+// Requests users by ids in batches, order of ids is kept and not found users are skipped
+func getUsers(client bxclient.BxClient, logger *slog.Logger, ids []bxtypes.Id) ([]bxtypes.User, error) {
+	cmd := map[string]string{}
+	for _, id := range ids {
+		cmd[id.String()] = "user.get?" + url.Values{"FILTER[ID]": {id.String()}}.Encode()
+	}
+	results, err := batchAll[[]bxtypes.User](client, logger, cmd)
+	if err != nil {
+		return nil, err
+	}
+	users := []bxtypes.User{}
+	for _, id := range ids {
+		if found := results[id.String()]; len(found) > 0 {
+			users = append(users, found[0])
+		}
+	}
+	return users, nil
+}
+
+// Requests all pages of list method, req returns request of the page that begins with start
+func listAll[T any](client bxclient.BxClient, method string, req func(start int) any) ([]T, error) {
+	items := []T{}
+	start := 0
+	for page := 0; page < listPagesLimit; page++ {
+		resp, err := client.Do(method, req(start), &bxtypes.ArrayResponse[T]{})
+		if err != nil {
+			return nil, err
+		}
+		res, ok := resp.Result().(*bxtypes.ArrayResponse[T])
+		if !ok {
+			return nil, api.ErrorParseResponse
+		}
+		items = append(items, res.Result...)
+		if res.Next == 0 {
+			break
+		}
+		start = res.Next
+	}
+	return items, nil
+}
+
+// Same as listAll for tasks.task.list - its result is an object with tasks
+func listTasks(client bxclient.BxClient, req func(start int) bxtypes.ReqTasksTaskList) ([]bxtypes.Task, error) {
+	tasks := []bxtypes.Task{}
+	start := 0
+	for page := 0; page < listPagesLimit; page++ {
+		resp, err := client.Do("tasks.task.list", req(start), &bxtypes.TasksListResponse{})
+		if err != nil {
+			return nil, err
+		}
+		res, ok := resp.Result().(*bxtypes.TasksListResponse)
+		if !ok {
+			return nil, api.ErrorParseResponse
+		}
+		tasks = append(tasks, res.Result.Tasks...)
+		if res.Next == 0 {
+			break
+		}
+		start = res.Next
+	}
+	return tasks, nil
+}
@@ -3,11 +3,16 @@ package bx
 import (
 	"fmt"
 	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/api"
 
+	"github.com/CGSG-2021-AE4/tomestobot/internal/metrics"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxclient"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/phone"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -18,12 +23,19 @@ type BxDescriptor struct {
 	BxDomain string `validate:"required,fqdn"` // Full Qualified Domain Name
 	BxUserId int    `validate:"required"`
 	BxHook   string `validate:"required"`
+
+	PhoneRegion string `validate:"required"` // ISO code of region whose numbers could be written without country code
 }
 
+// User fields that are searched for phone number
+var phoneFields = []string{"PERSONAL_MOBILE", "WORK_PHONE", "PERSONAL_PHONE"}
+
 type bxWrapper struct {
 	logger *slog.Logger
 
-	client bxclient.BxClient
+	client     bxclient.BxClient
+	hookUserId bxtypes.Id   // Owner of the webhook - all changes are made by him
+	region     phone.Region // Default region of phone numbers
 }
 
 func New(logger *slog.Logger, descr BxDescriptor) (api.BxWrapper, error) {
@@ -31,54 +43,85 @@ func New(logger *slog.Logger, descr BxDescriptor) (api.BxWrapper, error) {
 	if err := validate.Struct(descr); err != nil {
 		return nil, fmt.Errorf("bx wrapper descriptor validation: %w", err)
 	}
+	region, ok := phone.LookupRegion(descr.PhoneRegion)
+	if !ok {
+		return nil, fmt.Errorf("unknown phone region %s", descr.PhoneRegion)
+	}
 
 	// Create bxclient
 	c := bxclient.New(descr.BxDomain, descr.BxUserId, descr.BxHook)
 
 	// For debug
 	c.SetDebug(api.EnableRestyLogs)
+	c.SetObserver(metrics.ObserveBx)
 
 	return &bxWrapper{
-		logger: logger,
-		client: c,
+		logger:     logger,
+		client:     c,
+		hookUserId: bxtypes.Id(descr.BxUserId),
+		region:     region,
 	}, nil
 }
 
-func (b *bxWrapper) AuthUserByPhone(phone string) (api.BxUser, error) {
-
-	// Make request
-	resp, err := b.client.Do(
-		"user.get",
-		bxtypes.ReqUserGet{
-			Filter: map[string]string{
-				"PERSONAL_MOBILE": phone,
-			},
-		},
-		&bxtypes.ArrayResponse[bxtypes.User]{})
-	b.logger.Debug("got resp")
+// Number is normalized to E.164 and all phone fields are searched by its spellings by batches
+// Found users are checked by normalized value - the same user could be found by several fields
+func (b *bxWrapper) AuthUserByPhone(phoneNumber string) (api.BxUser, error) {
+	number, err := phone.Normalize(phoneNumber, b.region)
+	if err != nil {
+		b.logger.Debug("normalize phone", "err", err.Error())
+		return nil, api.ErrorInvalidPhoneNumber
+	}
+	cmd := map[string]string{}
+	for _, field := range phoneFields {
+		for i, variant := range phone.Variants(number, b.region) {
+			cmd[fmt.Sprintf("%s_%d", field, i)] = "user.get?" + url.Values{"FILTER[" + field + "]": {variant}}.Encode()
+		}
+	}
 
-	// Check for result to be valid
+	// Variants of one region fit one batch, more are split
+	results, err := batchAll[[]bxtypes.User](b.client, b.logger, cmd)
 	if err != nil {
 		return nil, err
 	}
-	res, ok := resp.Result().(*bxtypes.ArrayResponse[bxtypes.User])
-	if !ok {
-		return nil, api.ErrorParseResponse
+
+	found := map[bxtypes.Id]bxtypes.User{}
+	for _, users := range results {
+		for _, u := range users {
+			if b.hasPhone(u, number) {
+				found[u.Id] = u
+			}
+		}
 	}
-	if res.Total == 0 {
+	if len(found) == 0 {
 		return nil, api.ErrorUserNotFound
 	}
-	if res.Total > 1 {
+	if len(found) > 1 {
 		return nil, api.ErrorSeveralUsersFound
 	}
 
+	var user bxtypes.User
+	for _, u := range found { // The only one
+		user = u
+	}
+
 	// Create new user
 	return &bxUser{
-		bx:   b.client,
-		user: res.Result[0],
+		logger: b.logger,
+		bx:     b.client,
+		user:   user,
 	}, nil
 }
 
+// Checks if any phone field of the user is the number
+func (b *bxWrapper) hasPhone(u bxtypes.User, number string) bool {
+	for _, field := range []string{u.PersonalMobile, u.WorkPhone, u.PersonalPhone} {
+		if n, err := phone.Normalize(field, b.region); err == nil && n == number {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *bxWrapper) AuthUserById(id bxtypes.Id) (api.BxUser, error) {
 	// Make request
 	resp, err := b.client.Do(
@@ -107,11 +150,142 @@ func (b *bxWrapper) AuthUserById(id bxtypes.Id) (api.BxUser, error) {
 
 	// Create new user
 	return &bxUser{
-		bx:   b.client,
-		user: res.Result[0],
+		logger: b.logger,
+		bx:     b.client,
+		user:   res.Result[0],
 	}, nil
 }
 
+// Searches by both fields in one batch request, found users are checked by returned values
+// because unknown filter field is ignored by bitrix
+func (b *bxWrapper) FindUserByLogin(login string) (bxtypes.User, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return bxtypes.NilUser, api.ErrorUserNotFound
+	}
+	cmd := map[string]string{}
+	for _, field := range []string{"LOGIN", "EMAIL"} {
+		cmd[field] = "user.get?" + url.Values{"FILTER[" + field + "]": {login}}.Encode()
+	}
+
+	// Make request
+	resp, err := b.client.Do("batch", bxtypes.ReqBatch{Cmd: cmd}, &bxtypes.Response[bxtypes.ResBatch]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return bxtypes.NilUser, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[bxtypes.ResBatch])
+	if !ok {
+		return bxtypes.NilUser, api.ErrorParseResponse
+	}
+	results, err := bxtypes.BatchMap[[]bxtypes.User](res.Result.Result)
+	if err != nil {
+		return bxtypes.NilUser, api.ErrorParseResponse
+	}
+
+	found := map[bxtypes.Id]bxtypes.User{}
+	for _, users := range results {
+		for _, u := range users {
+			if strings.EqualFold(u.Login, login) || strings.EqualFold(u.Email, login) {
+				found[u.Id] = u
+			}
+		}
+	}
+	if len(found) == 0 {
+		return bxtypes.NilUser, api.ErrorUserNotFound
+	}
+	if len(found) > 1 {
+		return bxtypes.NilUser, api.ErrorSeveralUsersFound
+	}
+	var user bxtypes.User
+	for _, u := range found { // The only one
+		user = u
+	}
+	return user, nil
+}
+
+func (b *bxWrapper) NotifyUser(userId bxtypes.Id, message string) error {
+	_, err := b.client.Do(
+		"im.notify",
+		bxtypes.ReqImNotify{
+			UserId:  userId,
+			Message: message,
+			Type:    "SYSTEM",
+		},
+		&bxtypes.Response[any]{})
+	return err
+}
+
+func (b *bxWrapper) GetUsers(ids []bxtypes.Id) ([]bxtypes.User, error) {
+	return getUsers(b.client, b.logger, ids)
+}
+
+func (b *bxWrapper) GetDepartments(ids []bxtypes.Id) ([]bxtypes.Department, error) {
+	cmd := map[string]string{}
+	for _, id := range ids {
+		cmd[id.String()] = "department.get?" + url.Values{"ID": {id.String()}}.Encode()
+	}
+	results, err := batchAll[[]bxtypes.Department](b.client, b.logger, cmd)
+	if err != nil {
+		return nil, err
+	}
+	departments := []bxtypes.Department{}
+	for _, id := range ids {
+		if found := results[id.String()]; len(found) > 0 {
+			departments = append(departments, found[0])
+		}
+	}
+	return departments, nil
+}
+
+func (b *bxWrapper) ListDepartments() ([]bxtypes.Department, error) {
+	return listAll[bxtypes.Department](b.client, "department.get", func(start int) any {
+		return bxtypes.ReqDepartmentGet{Start: start}
+	})
+}
+
+func (b *bxWrapper) ListTasksDueBetween(userIds []bxtypes.Id, from, to time.Time) (map[bxtypes.Id][]bxtypes.Task, error) {
+	cmd := map[string]string{}
+	for _, id := range userIds {
+		cmd[id.String()] = "tasks.task.list?" + url.Values{
+			"select[]":               {"ID", "TITLE", "STATUS", "DEADLINE", "UF_CRM_TASK"},
+			"filter[<REAL_STATUS]":   {"5"},
+			"filter[RESPONSIBLE_ID]": {id.String()},
+			"filter[>=DEADLINE]":     {from.Format(time.RFC3339)},
+			"filter[<=DEADLINE]":     {to.Format(time.RFC3339)},
+			"order[DEADLINE]":        {"ASC"},
+		}.Encode()
+	}
+	results, err := batchAll[bxtypes.ResTasksTaskList](b.client, b.logger, cmd)
+	if err != nil {
+		return nil, err
+	}
+	tasks := map[bxtypes.Id][]bxtypes.Task{}
+	for _, id := range userIds {
+		if res, ok := results[id.String()]; ok {
+			tasks[id] = res.Tasks
+		}
+	}
+	return tasks, nil
+}
+
+func (b *bxWrapper) GetDeal(dealId bxtypes.Id) (bxtypes.Deal, error) {
+	return getDeal(b.client, dealId)
+}
+
+func (b *bxWrapper) GetTask(taskId bxtypes.Id) (bxtypes.Task, error) {
+	return getTask(b.client, taskId)
+}
+
+func (b *bxWrapper) GetTimelineComment(commentId bxtypes.Id) (bxtypes.TimelineComment, error) {
+	return getTimelineComment(b.client, commentId)
+}
+
+func (b *bxWrapper) HookUserId() bxtypes.Id {
+	return b.hookUserId
+}
+
 func (b *bxWrapper) Close() error {
 	return b.client.Close()
 }
@@ -0,0 +1,70 @@
+package bx
+
+import (
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxclient"
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+// Requests of single entities by id
+// Are shared by wrapper(events come without user) and users
+
+func getDeal(client bxclient.BxClient, dealId bxtypes.Id) (bxtypes.Deal, error) {
+	// Make request
+	resp, err := client.Do(
+		"crm.deal.get",
+		bxtypes.ReqCrmDealGet{Id: dealId},
+		&bxtypes.Response[bxtypes.Deal]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return bxtypes.NilDeal, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[bxtypes.Deal])
+	if !ok {
+		return bxtypes.NilDeal, api.ErrorParseResponse
+	}
+
+	return res.Result, nil
+}
+
+func getTask(client bxclient.BxClient, taskId bxtypes.Id) (bxtypes.Task, error) {
+	// Make request
+	resp, err := client.Do(
+		"tasks.task.get",
+		bxtypes.ReqTasksTaskGet{
+			TaskId: taskId,
+			Select: []string{"ID", "TITLE", "STATUS", "RESPONSIBLE_ID", "CREATED_BY", "CHANGED_BY", "DEADLINE", "UF_CRM_TASK"},
+		},
+		&bxtypes.Response[bxtypes.ResTasksTaskGet]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return bxtypes.NilTask, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[bxtypes.ResTasksTaskGet])
+	if !ok {
+		return bxtypes.NilTask, api.ErrorParseResponse
+	}
+
+	return res.Result.Task, nil
+}
+
+func getTimelineComment(client bxclient.BxClient, commentId bxtypes.Id) (bxtypes.TimelineComment, error) {
+	// Make request
+	resp, err := client.Do(
+		"crm.timeline.comment.get",
+		bxtypes.ReqCrmTimelineCommentGet{Id: commentId},
+		&bxtypes.Response[bxtypes.TimelineComment]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return bxtypes.TimelineComment{}, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[bxtypes.TimelineComment])
+	if !ok {
+		return bxtypes.TimelineComment{}, api.ErrorParseResponse
+	}
+
+	return res.Result, nil
+}
@@ -0,0 +1,64 @@
+package bx
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Registry of tenants' portals
+// Name of portal is used in deep links(/start <name>) and stored accounts, so it should not be changed
+// Logs of every portal have portal attr - telegram logs of admins are scoped by it
+
+type PortalDescriptor struct {
+	Name string `validate:"omitempty,alphanum,lowercase,max=32"` // Empty is the default portal
+	BxDescriptor
+}
+
+type portals struct {
+	wrappers map[string]api.BxWrapper
+	names    []string
+}
+
+func NewPortals(logger *slog.Logger, descrs []PortalDescriptor) (api.BxPortals, error) {
+	if len(descrs) == 0 {
+		return nil, fmt.Errorf("no bitrix portals")
+	}
+	p := &portals{wrappers: map[string]api.BxWrapper{}}
+	for _, descr := range descrs {
+		if err := validate.Struct(descr); err != nil {
+			return nil, fmt.Errorf("portal %q descriptor validation: %w", descr.Name, err)
+		}
+		if _, ok := p.wrappers[descr.Name]; ok {
+			return nil, fmt.Errorf("portal %q is duplicated", descr.Name)
+		}
+		bx, err := New(logger.With("portal", descr.Name), descr.BxDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("portal %q: %w", descr.Name, err)
+		}
+		p.wrappers[descr.Name] = bx
+		p.names = append(p.names, descr.Name)
+	}
+	slices.Sort(p.names) // Default one is empty so it goes first
+	return p, nil
+}
+
+func (p *portals) Get(portal string) (api.BxWrapper, bool) {
+	bx, ok := p.wrappers[portal]
+	return bx, ok
+}
+
+func (p *portals) Names() []string {
+	return slices.Clone(p.names)
+}
+
+func (p *portals) Close() error {
+	errs := []error{}
+	for _, bx := range p.wrappers {
+		errs = append(errs, bx.Close())
+	}
+	return errors.Join(errs...)
+}
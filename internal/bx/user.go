@@ -1,27 +1,66 @@
 package bx
 
 import (
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/CGSG-2021-AE4/tomestobot/api"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxclient"
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
 )
 
 type bxUser struct {
-	bx   bxclient.BxClient
-	user bxtypes.User
+	logger *slog.Logger
+	bx     bxclient.BxClient
+	user   bxtypes.User
 }
 
-func (u *bxUser) ListDeals() ([]bxtypes.Deal, error) {
+func (u *bxUser) ListDeals(query api.DealQuery) ([]bxtypes.Deal, error) {
+	// Build filter
+	assignedId := u.user.Id
+	if query.AssignedId != 0 {
+		assignedId = query.AssignedId
+	}
+	filter := map[string]string{
+		"ASSIGNED_BY_ID": assignedId.String(),
+	}
+	if query.StageId != "" {
+		filter["STAGE_ID"] = query.StageId
+	}
+	if query.CategoryId != "" {
+		filter["CATEGORY_ID"] = query.CategoryId
+	}
+	if query.Title != "" {
+		filter["%TITLE"] = query.Title // Substring search
+	}
+	if !query.CreatedFrom.IsZero() {
+		filter[">=DATE_CREATE"] = query.CreatedFrom.Format(time.RFC3339)
+	}
+	if !query.CreatedTo.IsZero() {
+		filter["<=DATE_CREATE"] = query.CreatedTo.Format(time.RFC3339)
+	}
+
+	// Build order
+	order := map[string]string{}
+	if query.Sort != api.DealSortDefault {
+		order[string(query.Sort)] = "ASC"
+		if query.SortDesc {
+			order[string(query.Sort)] = "DESC"
+		}
+	}
+
 	// Make request
 	resp, err := u.bx.Do(
 		"crm.deal.list",
 		bxtypes.ReqCrmDealList{
 			ReqArrayParams: bxtypes.ReqArrayParams{
-				Select: []string{"ID", "TITLE", "TYPE_ID", "CATEGORY_ID", "STAGE_ID"},
-				Filter: map[string]string{
-					// What is here TODO
-					"ASSIGNED_BY_ID": u.user.Id.String(),
-				},
+				Select: []string{"ID", "TITLE", "TYPE_ID", "CATEGORY_ID", "STAGE_ID", "ASSIGNED_BY_ID"},
+				Filter: filter,
+				Order:  order,
 			},
 		},
 		&bxtypes.ArrayResponse[bxtypes.Deal]{})
@@ -38,7 +77,77 @@ func (u *bxUser) ListDeals() ([]bxtypes.Deal, error) {
 	return res.Result, nil
 }
 
-func (u *bxUser) AddCommentToDeal(dealId bxtypes.Id, comment string) (bxtypes.Id, error) {
+func (u *bxUser) GetDeal(dealId bxtypes.Id) (bxtypes.Deal, error) {
+	return getDeal(u.bx, dealId)
+}
+
+func (u *bxUser) GetTask(taskId bxtypes.Id) (bxtypes.Task, error) {
+	return getTask(u.bx, taskId)
+}
+
+func (u *bxUser) ListDealContacts(dealId bxtypes.Id) ([]bxtypes.Contact, error) {
+	// Request links
+	resp, err := u.bx.Do(
+		"crm.deal.contact.items.get",
+		bxtypes.ReqCrmDealContactItemsGet{Id: dealId},
+		&bxtypes.Response[[]bxtypes.DealContactItem]{})
+	if err != nil {
+		return nil, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[[]bxtypes.DealContactItem])
+	if !ok {
+		return nil, api.ErrorParseResponse
+	}
+	items := res.Result
+	slices.SortStableFunc(items, func(a, b bxtypes.DealContactItem) int {
+		if a.IsPrimary != b.IsPrimary { // Primary first
+			if a.IsPrimary == "Y" {
+				return -1
+			}
+			return 1
+		}
+		return a.Sort - b.Sort
+	})
+
+	// Request contacts in batch
+	cmd := map[string]string{}
+	for _, item := range items {
+		cmd[item.ContactId.String()] = "crm.contact.get?" + url.Values{"id": {item.ContactId.String()}}.Encode()
+	}
+	results, err := batchAll[bxtypes.Contact](u.bx, u.logger, cmd)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]bxtypes.Contact, 0, len(items))
+	for _, item := range items { // Order of items is kept
+		if contact, ok := results[item.ContactId.String()]; ok {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	return contacts, nil
+}
+
+func (u *bxUser) GetCompany(companyId bxtypes.Id) (bxtypes.Company, error) {
+	// Make request
+	resp, err := u.bx.Do(
+		"crm.company.get",
+		bxtypes.ReqCrmCompanyGet{Id: companyId},
+		&bxtypes.Response[bxtypes.Company]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return bxtypes.Company{}, err
+	}
+	res, ok := resp.Result().(*bxtypes.Response[bxtypes.Company])
+	if !ok {
+		return bxtypes.Company{}, api.ErrorParseResponse
+	}
+
+	return res.Result, nil
+}
+
+func (u *bxUser) AddCommentToDeal(dealId bxtypes.Id, comment string, files ...bxtypes.File) (bxtypes.Id, error) {
 	// Make request
 	resp, err := u.bx.Do(
 		"crm.timeline.comment.add",
@@ -48,6 +157,7 @@ func (u *bxUser) AddCommentToDeal(dealId bxtypes.Id, comment string) (bxtypes.Id
 				EntityType: "deal",
 				AuthorId:   u.user.Id,
 				Comment:    comment,
+				Files:      files,
 			},
 		},
 		&bxtypes.Response[bxtypes.ResCrmTimelineCommentAdd]{})
@@ -64,7 +174,10 @@ func (u *bxUser) AddCommentToDeal(dealId bxtypes.Id, comment string) (bxtypes.Id
 	return bxtypes.Id(res.Result), nil
 }
 
-func (u *bxUser) ListDealTasks(dealId bxtypes.Id) ([]bxtypes.Task, error) {
+func (u *bxUser) ListDealTasks(dealId, responsibleId bxtypes.Id) ([]bxtypes.Task, error) {
+	if responsibleId == 0 {
+		responsibleId = u.user.Id
+	}
 	// Make request
 	resp, err := u.bx.Do(
 		"tasks.task.list",
@@ -72,7 +185,7 @@ func (u *bxUser) ListDealTasks(dealId bxtypes.Id) ([]bxtypes.Task, error) {
 			Select: []string{"ID", "TITLE", "STATUS", "UF_CRM_TASK"},
 			Filter: map[string]string{
 				"<REAL_STATUS":   "5", // Now there are only incomplete ones TODO
-				"RESPONSIBLE_ID": u.user.Id.String(),
+				"RESPONSIBLE_ID": responsibleId.String(),
 				"UF_CRM_TASK":    "D_" + dealId.String(),
 			},
 			Order: map[string]string{},
@@ -91,6 +204,204 @@ func (u *bxUser) ListDealTasks(dealId bxtypes.Id) ([]bxtypes.Task, error) {
 	return res.Result.Tasks, nil
 }
 
+func (u *bxUser) ListTasksDueBy(deadline time.Time) ([]bxtypes.Task, error) {
+	// Tasks without deadline do not match the filter
+	return listTasks(u.bx, func(start int) bxtypes.ReqTasksTaskList {
+		return bxtypes.ReqTasksTaskList{
+			Select: []string{"ID", "TITLE", "STATUS", "DEADLINE", "UF_CRM_TASK"},
+			Filter: map[string]string{
+				"<REAL_STATUS":   "5",
+				"RESPONSIBLE_ID": u.user.Id.String(),
+				"<=DEADLINE":     deadline.Format(time.RFC3339),
+			},
+			Order: map[string]string{"DEADLINE": "ASC"},
+			Start: start,
+		}
+	})
+}
+
+func (u *bxUser) ListDealTimeline(dealId bxtypes.Id) ([]bxtypes.TimelineEntry, error) {
+	// Request comments
+	comments, err := listAll[bxtypes.TimelineComment](u.bx, "crm.timeline.comment.list", func(start int) any {
+		return bxtypes.ReqCrmTimelineCommentList{
+			Select: []string{"ID", "CREATED", "AUTHOR_ID", "COMMENT"},
+			Filter: map[string]string{
+				"ENTITY_ID":   dealId.String(),
+				"ENTITY_TYPE": "deal",
+			},
+			Order: map[string]string{"CREATED": "DESC"},
+			Start: start,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Request activities
+	activities, err := listAll[bxtypes.Activity](u.bx, "crm.activity.list", func(start int) any {
+		return bxtypes.ReqCrmActivityList{
+			Select: []string{"ID", "CREATED", "AUTHOR_ID", "SUBJECT", "DESCRIPTION"},
+			Filter: map[string]string{
+				"OWNER_TYPE_ID": strconv.Itoa(bxtypes.OwnerTypeDeal),
+				"OWNER_ID":      dealId.String(),
+			},
+			Order: map[string]string{"CREATED": "DESC"},
+			Start: start,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge into one feed
+	entries := make([]bxtypes.TimelineEntry, 0, len(comments)+len(activities))
+	for _, c := range comments {
+		entries = append(entries, bxtypes.TimelineEntry{
+			Kind:     bxtypes.TimelineEntryComment,
+			Id:       c.Id,
+			Created:  c.Created.Time,
+			AuthorId: c.AuthorId,
+			Text:     c.Comment,
+		})
+	}
+	for _, a := range activities {
+		text := a.Subject
+		if a.Description != "" {
+			text += "\n" + a.Description
+		}
+		entries = append(entries, bxtypes.TimelineEntry{
+			Kind:     bxtypes.TimelineEntryActivity,
+			Id:       a.Id,
+			Created:  a.Created.Time,
+			AuthorId: a.AuthorId,
+			Text:     text,
+		})
+	}
+	slices.SortStableFunc(entries, func(a, b bxtypes.TimelineEntry) int {
+		return b.Created.Compare(a.Created) // Newest first
+	})
+
+	// Resolve authors in one batch
+	names := map[bxtypes.Id]string{u.user.Id: fullName(u.user)} // No need to request the user himself
+	ids := []bxtypes.Id{}
+	for _, e := range entries {
+		if _, ok := names[e.AuthorId]; !ok && !slices.Contains(ids, e.AuthorId) {
+			ids = append(ids, e.AuthorId)
+		}
+	}
+	authors, err := getUsers(u.bx, u.logger, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range authors {
+		names[a.Id] = fullName(a)
+	}
+	for i, e := range entries {
+		name, ok := names[e.AuthorId]
+		if !ok { // Deleted user for example
+			name = e.AuthorId.String()
+		}
+		entries[i].AuthorName = name
+	}
+
+	return entries, nil
+}
+
+func fullName(u bxtypes.User) string {
+	return strings.TrimSpace(u.Name + " " + u.LastName)
+}
+
+func (u *bxUser) ListSubordinates() ([]bxtypes.User, error) {
+	// All departments are got at once and the tree is walked here - not a request per department
+	all, err := listAll[bxtypes.Department](u.bx, "department.get", func(start int) any {
+		return bxtypes.ReqDepartmentGet{Start: start}
+	})
+	if err != nil {
+		return nil, err
+	}
+	departments := managedDepartments(all, u.user.Id)
+	if len(departments) == 0 {
+		return []bxtypes.User{}, nil
+	}
+
+	// Employees of departments - the first pages by one batch, the rest of big departments page by page
+	filter := func(d bxtypes.Department) map[string]string {
+		return map[string]string{"UF_DEPARTMENT": d.Id.String(), "ACTIVE": "Y"}
+	}
+	cmd := map[string]string{}
+	for _, d := range departments {
+		query := url.Values{}
+		for key, value := range filter(d) {
+			query.Set("FILTER["+key+"]", value)
+		}
+		cmd[d.Id.String()] = "user.get?" + query.Encode()
+	}
+	pages, err := batchAll[[]bxtypes.User](u.bx, u.logger, cmd)
+	if err != nil {
+		return nil, err
+	}
+	users := []bxtypes.User{}
+	seen := map[bxtypes.Id]bool{u.user.Id: true}
+	for _, d := range departments {
+		employees := pages[d.Id.String()]
+		if len(employees) >= listPageSize {
+			employees, err = listAll[bxtypes.User](u.bx, "user.get", func(start int) any {
+				return bxtypes.ReqUserGet{Filter: filter(d), Start: start}
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, user := range employees {
+			if !seen[user.Id] {
+				seen[user.Id] = true
+				users = append(users, user)
+			}
+		}
+	}
+	slices.SortFunc(users, func(a, b bxtypes.User) int {
+		return strings.Compare(a.LastName+" "+a.Name, b.LastName+" "+b.Name)
+	})
+
+	return users, nil
+}
+
+func (u *bxUser) GetUser(userId bxtypes.Id) (bxtypes.User, error) {
+	users, err := getUsers(u.bx, u.logger, []bxtypes.Id{userId})
+	if err != nil {
+		return bxtypes.User{}, err
+	}
+	if len(users) == 0 {
+		return bxtypes.User{}, api.ErrorUserNotFound
+	}
+	return users[0], nil
+}
+
+// Departments headed by the user and all nested ones
+func managedDepartments(all []bxtypes.Department, headId bxtypes.Id) []bxtypes.Department {
+	children := map[bxtypes.Id][]bxtypes.Department{}
+	managed := []bxtypes.Department{}
+	for _, d := range all {
+		children[d.Parent] = append(children[d.Parent], d)
+		if d.HeadId == headId {
+			managed = append(managed, d)
+		}
+	}
+	added := map[bxtypes.Id]bool{}
+	for _, d := range managed {
+		added[d.Id] = true
+	}
+	for i := 0; i < len(managed); i++ {
+		for _, child := range children[managed[i].Id] {
+			if !added[child.Id] {
+				added[child.Id] = true
+				managed = append(managed, child)
+			}
+		}
+	}
+	return managed
+}
+
 func (u *bxUser) CompleteTask(taskId bxtypes.Id) error {
 	// Make request
 	_, err := u.bx.Do(
@@ -107,6 +418,40 @@ func (u *bxUser) CompleteTask(taskId bxtypes.Id) error {
 	return nil
 }
 
+func (u *bxUser) RenewTask(taskId bxtypes.Id) error {
+	// Make request
+	_, err := u.bx.Do(
+		"tasks.task.renew",
+		bxtypes.ReqTasksTaskRenew{
+			TaskId: taskId,
+		},
+		&bxtypes.Response[any]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *bxUser) DeleteDealComment(dealId, commentId bxtypes.Id) error {
+	// Make request
+	_, err := u.bx.Do(
+		"crm.timeline.comment.delete",
+		bxtypes.ReqCrmTimelineCommentDelete{
+			Id:          commentId,
+			OwnerTypeId: bxtypes.OwnerTypeDeal,
+			OwnerId:     dealId,
+		},
+		&bxtypes.Response[any]{})
+
+	// Check for result to be valid
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (u *bxUser) Get() bxtypes.User {
 	return u.user
 }
@@ -0,0 +1,38 @@
+package bx
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
+)
+
+func TestManagedDepartments(t *testing.T) {
+	all := []bxtypes.Department{
+		{Id: 1, HeadId: 100},
+		{Id: 2, Parent: 1, HeadId: 200},
+		{Id: 3, Parent: 2},
+		{Id: 4, Parent: 3, HeadId: 100}, // Headed by the same user - is not added twice
+		{Id: 5},
+		{Id: 6, Parent: 5, HeadId: 300},
+	}
+	tests := []struct {
+		head bxtypes.Id
+		want []bxtypes.Id
+	}{
+		{100, []bxtypes.Id{1, 2, 3, 4}},
+		{200, []bxtypes.Id{2, 3, 4}},
+		{300, []bxtypes.Id{6}},
+		{400, []bxtypes.Id{}},
+	}
+	for _, tt := range tests {
+		ids := []bxtypes.Id{}
+		for _, d := range managedDepartments(all, tt.head) {
+			ids = append(ids, d.Id)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("managedDepartments(head %d) = %v, want %v", tt.head, ids, tt.want)
+		}
+	}
+}
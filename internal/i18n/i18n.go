@@ -0,0 +1,226 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Message catalogs
+// Every language is a json file in locales dir: key -> template string or plural forms object
+// Templates are text/template ones with args that are passed as key-value pairs
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// Language that is used when user's one is not supported
+// Its catalog is also the reference one - other catalogs must have the same keys
+const DefaultLang = "ru"
+
+type message struct {
+	simple *template.Template            // Nil for plural messages
+	forms  map[string]*template.Template // Plural form -> template
+}
+
+type locale struct {
+	lang     string
+	plural   pluralRule
+	messages map[string]message
+}
+
+type Catalog struct {
+	logger  *slog.Logger
+	locales map[string]*locale
+}
+
+// Loads and validates all embedded catalogs
+// Returns error if some catalog is broken or does not have a key of the reference catalog
+func Load(logger *slog.Logger) (*Catalog, error) {
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		return nil, fmt.Errorf("read locales dir: %w", err)
+	}
+
+	c := &Catalog{
+		logger:  logger,
+		locales: map[string]*locale{},
+	}
+	for _, f := range files {
+		lang := strings.TrimSuffix(f.Name(), path.Ext(f.Name()))
+		data, err := localesFS.ReadFile("locales/" + f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read %s catalog: %w", lang, err)
+		}
+		l, err := parseLocale(lang, data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s catalog: %w", lang, err)
+		}
+		c.locales[lang] = l
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseLocale(lang string, data []byte) (*locale, error) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	l := &locale{
+		lang:     lang,
+		plural:   pluralRuleFor(lang),
+		messages: map[string]message{},
+	}
+	for key, value := range raw {
+		// Simple message
+		var str string
+		if err := json.Unmarshal(value, &str); err == nil {
+			t, err := template.New(key).Option("missingkey=zero").Parse(str)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", key, err)
+			}
+			l.messages[key] = message{simple: t}
+			continue
+		}
+
+		// Plural message
+		forms := map[string]string{}
+		if err := json.Unmarshal(value, &forms); err != nil {
+			return nil, fmt.Errorf("key %s: value is neither string nor plural forms", key)
+		}
+		msg := message{forms: map[string]*template.Template{}}
+		for form, str := range forms {
+			t, err := template.New(key + "." + form).Option("missingkey=zero").Parse(str)
+			if err != nil {
+				return nil, fmt.Errorf("key %s form %s: %w", key, form, err)
+			}
+			msg.forms[form] = t
+		}
+		for _, form := range l.plural.forms {
+			if msg.forms[form] == nil {
+				return nil, fmt.Errorf("key %s: missing plural form %s", key, form)
+			}
+		}
+		l.messages[key] = msg
+	}
+	return l, nil
+}
+
+// Checks that all catalogs have the same keys of the same kind
+func (c *Catalog) validate() error {
+	ref, ok := c.locales[DefaultLang]
+	if !ok {
+		return fmt.Errorf("no catalog for default language %s", DefaultLang)
+	}
+	for lang, l := range c.locales {
+		for key, refMsg := range ref.messages {
+			msg, ok := l.messages[key]
+			if !ok {
+				return fmt.Errorf("catalog %s: missing key %s", lang, key)
+			}
+			if (msg.simple == nil) != (refMsg.simple == nil) {
+				return fmt.Errorf("catalog %s: key %s differs in plurality from %s catalog", lang, key, DefaultLang)
+			}
+		}
+		for key := range l.messages {
+			if _, ok := ref.messages[key]; !ok {
+				return fmt.Errorf("catalog %s: unknown key %s", lang, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns sorted list of supported languages
+func (c *Catalog) Langs() []string {
+	langs := []string{}
+	for lang := range c.locales {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+func (c *Catalog) Supports(lang string) bool {
+	_, ok := c.locales[lang]
+	return ok
+}
+
+// Returns localizer for language or default one if language is not supported
+func (c *Catalog) Localizer(lang string) api.Localizer {
+	l, ok := c.locales[lang]
+	if !ok { // Try base language of tags like en-US
+		base, _, _ := strings.Cut(lang, "-")
+		if l, ok = c.locales[base]; !ok {
+			l = c.locales[DefaultLang]
+		}
+	}
+	return &localizer{
+		logger: c.logger,
+		locale: l,
+	}
+}
+
+// Localizer implementation
+
+type localizer struct {
+	logger *slog.Logger
+	locale *locale
+}
+
+func (l *localizer) Lang() string {
+	return l.locale.lang
+}
+
+func (l *localizer) Has(key string) bool {
+	_, ok := l.locale.messages[key]
+	return ok
+}
+
+func (l *localizer) Tr(key string, args ...any) string {
+	msg, ok := l.locale.messages[key]
+	if !ok || msg.simple == nil {
+		l.logger.Error("missing message", "lang", l.locale.lang, "key", key)
+		return key
+	}
+	return l.execute(msg.simple, argsToMap(args))
+}
+
+func (l *localizer) Plural(key string, n int, args ...any) string {
+	msg, ok := l.locale.messages[key]
+	if !ok || msg.forms == nil {
+		l.logger.Error("missing plural message", "lang", l.locale.lang, "key", key)
+		return key
+	}
+	data := argsToMap(args)
+	data["n"] = n
+	return l.execute(msg.forms[l.locale.plural.form(n)], data)
+}
+
+func (l *localizer) execute(t *template.Template, data map[string]any) string {
+	str := strings.Builder{}
+	if err := t.Execute(&str, data); err != nil {
+		l.logger.Error("execute message template", "lang", l.locale.lang, "key", t.Name(), "err", err.Error())
+		return t.Name()
+	}
+	return str.String()
+}
+
+// Converts key-value pairs to map
+func argsToMap(args []any) map[string]any {
+	data := map[string]any{}
+	for i := 0; i+1 < len(args); i += 2 {
+		data[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return data
+}
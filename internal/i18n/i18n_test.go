@@ -0,0 +1,124 @@
+package i18n
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// Keys that are passed to localizer as literals in code and screen templates
+var (
+	codeKeyRe     = regexp.MustCompile(`\.(Tr|Plural)\(\s*"([^"]+)"`)
+	templateKeyRe = regexp.MustCompile(`\b(tr|plural) "([^"]+)"`)
+)
+
+const moduleRoot = "../.."
+
+type keyUse struct {
+	key    string
+	plural bool
+	where  string
+}
+
+// Collects literal keys of all non test go files and templates of the module
+func collectKeys(t *testing.T) []keyUse {
+	t.Helper()
+	uses := []keyUse{}
+	err := filepath.WalkDir(moduleRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && path != moduleRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var re *regexp.Regexp
+		switch {
+		case strings.HasSuffix(path, "_test.go"):
+			return nil
+		case strings.HasSuffix(path, ".go"):
+			re = codeKeyRe
+		case strings.HasSuffix(path, ".html"):
+			re = templateKeyRe
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+			if strings.HasSuffix(m[2], ".") { // Prefix of key that is built at runtime
+				continue
+			}
+			uses = append(uses, keyUse{key: m[2], plural: strings.EqualFold(m[1], "plural"), where: path})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk module: %s", err)
+	}
+	return uses
+}
+
+func TestCatalogsLoad(t *testing.T) {
+	if _, err := Load(slog.Default()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsedKeysExist(t *testing.T) {
+	c, err := Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := c.locales[DefaultLang]
+
+	uses := collectKeys(t)
+	if len(uses) == 0 {
+		t.Fatal("no keys found - check module root")
+	}
+	for _, r := range api.Roles { // Role names are built from role value
+		uses = append(uses, keyUse{key: "role." + string(r), where: "api.RoleText"})
+	}
+	for _, u := range uses {
+		msg, ok := ref.messages[u.key]
+		switch {
+		case !ok:
+			t.Errorf("%s: key %s is not in catalog", u.where, u.key)
+		case u.plural && msg.forms == nil:
+			t.Errorf("%s: key %s is used as plural but is simple", u.where, u.key)
+		case !u.plural && msg.simple == nil:
+			t.Errorf("%s: key %s is used as simple but is plural", u.where, u.key)
+		}
+	}
+}
+
+func TestLocalizerFallback(t *testing.T) {
+	c, err := Load(slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"en", "en"},
+		{"en-US", "en"},
+		{"xx", DefaultLang},
+		{"", DefaultLang},
+	}
+	for _, tt := range tests {
+		if got := c.Localizer(tt.lang).Lang(); got != tt.want {
+			t.Errorf("Localizer(%q).Lang() = %s, want %s", tt.lang, got, tt.want)
+		}
+	}
+}
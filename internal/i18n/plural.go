@@ -0,0 +1,47 @@
+package i18n
+
+// Plural rules for supported languages(only integer numbers)
+// Based on CLDR plural rules
+
+type pluralRule struct {
+	forms []string // Forms that every plural message must have
+	form  func(n int) string
+}
+
+var pluralRules = map[string]pluralRule{
+	"ru": {
+		forms: []string{"one", "few", "many"},
+		form: func(n int) string {
+			n10, n100 := n%10, n%100
+			switch {
+			case n10 == 1 && n100 != 11:
+				return "one"
+			case n10 >= 2 && n10 <= 4 && (n100 < 12 || n100 > 14):
+				return "few"
+			}
+			return "many"
+		},
+	},
+	"en": {
+		forms: []string{"one", "other"},
+		form: func(n int) string {
+			if n == 1 {
+				return "one"
+			}
+			return "other"
+		},
+	},
+}
+
+// Languages without rule have only other form
+var defaultPluralRule = pluralRule{
+	forms: []string{"other"},
+	form:  func(n int) string { return "other" },
+}
+
+func pluralRuleFor(lang string) pluralRule {
+	if r, ok := pluralRules[lang]; ok {
+		return r
+	}
+	return defaultPluralRule
+}
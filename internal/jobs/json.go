@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+// JSON file implementation of job queue
+// The whole queue is rewritten on every change - there are not many jobs
+// File is replaced atomically(write to temp file and rename) so crash does not leave half written queue
+// Ids of taken jobs are kept for doneTTL so the same job could not be scheduled and run again
+
+const doneTTL = 7 * 24 * time.Hour
+
+type jsonQueue struct {
+	logger *slog.Logger
+
+	filename string
+
+	mutex sync.Mutex
+	state queueState
+}
+
+// Stored data
+type queueState struct {
+	Jobs map[string]api.Job   `json:"jobs"`
+	Done map[string]time.Time `json:"done"` // Taken jobs ids with time they were taken
+}
+
+func NewJsonQueue(logger *slog.Logger, filename string) (api.JobQueue, error) {
+	state := queueState{}
+	data, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info("jobs file does not exist - will create a new one", "file", filename)
+	case err != nil:
+		return nil, fmt.Errorf("read jobs file: %w", err)
+	default:
+		// Unlike other stores broken queue is an error - jobs could be lost or run twice
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("parse jobs file: %w", err)
+		}
+	}
+	if state.Jobs == nil {
+		state.Jobs = map[string]api.Job{}
+	}
+	if state.Done == nil {
+		state.Done = map[string]time.Time{}
+	}
+	return &jsonQueue{
+		logger:   logger,
+		filename: filename,
+		state:    state,
+	}, nil
+}
+
+func (q *jsonQueue) Schedule(job api.Job) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, done := q.state.Done[job.Id]; done {
+		return false, nil
+	}
+	if old, ok := q.state.Jobs[job.Id]; ok && old.RunAt.Equal(job.RunAt) && bytes.Equal(old.Payload, job.Payload) {
+		return true, nil // Nothing to save
+	}
+	q.state.Jobs[job.Id] = job
+	return true, q.save()
+}
+
+func (q *jsonQueue) Take(now time.Time) ([]api.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	due := []api.Job{}
+	for id, job := range q.state.Jobs {
+		if !job.RunAt.After(now) {
+			due = append(due, job)
+			delete(q.state.Jobs, id)
+			q.state.Done[id] = now
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	for id, taken := range q.state.Done {
+		if now.Sub(taken) > doneTTL {
+			delete(q.state.Done, id)
+		}
+	}
+
+	// Jobs are not run if they could not be marked as taken
+	if err := q.save(); err != nil {
+		for _, job := range due {
+			q.state.Jobs[job.Id] = job
+			delete(q.state.Done, job.Id)
+		}
+		return nil, err
+	}
+	slices.SortFunc(due, func(a, b api.Job) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+	return due, nil
+}
+
+func (q *jsonQueue) Drop(tgId int64, portal string) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	dropped := 0
+	for id, job := range q.state.Jobs {
+		if job.TgId == tgId && job.Portal == portal {
+			delete(q.state.Jobs, id)
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, q.save()
+}
+
+// Writes queue to file, mutex must be locked
+func (q *jsonQueue) save() error {
+	data, err := json.Marshal(q.state)
+	if err != nil {
+		return fmt.Errorf("marshal jobs: %w", err)
+	}
+	tmp := q.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		q.logger.Warn("write jobs file: " + err.Error())
+		return fmt.Errorf("write jobs file: %w", err)
+	}
+	if err := os.Rename(tmp, q.filename); err != nil {
+		q.logger.Warn("replace jobs file: " + err.Error())
+		return fmt.Errorf("replace jobs file: %w", err)
+	}
+	return nil
+}
+
+func (q *jsonQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.save()
+}
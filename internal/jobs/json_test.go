@@ -0,0 +1,158 @@
+package jobs
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CGSG-2021-AE4/tomestobot/api"
+)
+
+func openQueue(t *testing.T, filename string) api.JobQueue {
+	t.Helper()
+	q, err := NewJsonQueue(slog.Default(), filename)
+	if err != nil {
+		t.Fatalf("open queue: %s", err)
+	}
+	return q
+}
+
+func jobIds(jobs []api.Job) []string {
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.Id)
+	}
+	return ids
+}
+
+func TestTakeDueJobsInOrder(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	q := openQueue(t, filepath.Join(t.TempDir(), "jobs.json"))
+	for _, j := range []api.Job{
+		{Id: "late", RunAt: now.Add(-time.Minute)},
+		{Id: "later", RunAt: now},
+		{Id: "early", RunAt: now.Add(-time.Hour)},
+		{Id: "future", RunAt: now.Add(time.Minute)},
+	} {
+		if ok, err := q.Schedule(j); !ok || err != nil {
+			t.Fatalf("schedule %s: %v %v", j.Id, ok, err)
+		}
+	}
+
+	due, err := q.Take(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jobIds(due), []string{"early", "late", "later"}; !slices.Equal(got, want) {
+		t.Errorf("Take() = %v, want %v", got, want)
+	}
+	if due, _ := q.Take(now); len(due) != 0 {
+		t.Errorf("jobs are taken twice: %v", jobIds(due))
+	}
+	if due, _ := q.Take(now.Add(time.Minute)); !slices.Equal(jobIds(due), []string{"future"}) {
+		t.Errorf("future job is not taken: %v", jobIds(due))
+	}
+}
+
+func TestTakenJobIsNotScheduledAgain(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	q := openQueue(t, filepath.Join(t.TempDir(), "jobs.json"))
+	q.Schedule(api.Job{Id: "a", RunAt: now})
+	q.Take(now)
+	if ok, err := q.Schedule(api.Job{Id: "a", RunAt: now.Add(time.Hour)}); ok || err != nil {
+		t.Errorf("taken job is scheduled again: %v %v", ok, err)
+	}
+	// Done mark expires
+	q.Schedule(api.Job{Id: "b", RunAt: now.Add(doneTTL + time.Hour)})
+	q.Take(now.Add(doneTTL + time.Hour))
+	if ok, _ := q.Schedule(api.Job{Id: "a", RunAt: now.Add(2 * doneTTL)}); !ok {
+		t.Error("job is not scheduled after done mark expired")
+	}
+}
+
+func TestScheduleReplacesJob(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	q := openQueue(t, filepath.Join(t.TempDir(), "jobs.json"))
+	q.Schedule(api.Job{Id: "a", RunAt: now})
+	q.Schedule(api.Job{Id: "a", RunAt: now.Add(time.Hour)})
+	if due, _ := q.Take(now); len(due) != 0 {
+		t.Errorf("replaced job is taken: %v", jobIds(due))
+	}
+	if due, _ := q.Take(now.Add(time.Hour)); len(due) != 1 {
+		t.Errorf("Take() = %v, want one job", jobIds(due))
+	}
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	filename := filepath.Join(t.TempDir(), "jobs.json")
+	q := openQueue(t, filename)
+	q.Schedule(api.Job{Id: "taken", RunAt: now, Payload: []byte(`{"x":1}`)})
+	q.Take(now)
+	q.Schedule(api.Job{Id: "pending", RunAt: now.Add(time.Minute), TgId: 5, Payload: []byte(`{"x":2}`)})
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openQueue(t, filename)
+	if ok, _ := q.Schedule(api.Job{Id: "taken", RunAt: now}); ok {
+		t.Error("taken job is scheduled again after restart")
+	}
+	due, err := q.Take(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Id != "pending" || due[0].TgId != 5 || string(due[0].Payload) != `{"x":2}` {
+		t.Errorf("Take() after restart = %+v", due)
+	}
+}
+
+func TestBrokenFileIsError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "jobs.json")
+	if err := os.WriteFile(filename, []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJsonQueue(slog.Default(), filename); err == nil {
+		t.Error("broken queue is opened")
+	}
+}
+
+func TestFailedSaveKeepsJobs(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	dir := filepath.Join(t.TempDir(), "jobs")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	q := openQueue(t, filepath.Join(dir, "jobs.json"))
+	q.Schedule(api.Job{Id: "a", RunAt: now})
+	if err := os.RemoveAll(dir); err != nil { // Queue could not be saved anymore
+		t.Fatal(err)
+	}
+	if due, err := q.Take(now); err == nil || len(due) != 0 {
+		t.Fatalf("Take() with failed save = %v, %v", jobIds(due), err)
+	}
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := q.Take(now); err != nil || len(due) != 1 {
+		t.Errorf("job is lost after failed save: %v, %v", jobIds(due), err)
+	}
+}
+
+func TestDropAccountJobs(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	q := openQueue(t, filepath.Join(t.TempDir(), "jobs.json"))
+	q.Schedule(api.Job{Id: "north", RunAt: now, TgId: 1, Portal: "north"})
+	q.Schedule(api.Job{Id: "default", RunAt: now, TgId: 1})
+	q.Schedule(api.Job{Id: "other user", RunAt: now, TgId: 2, Portal: "north"})
+	if n, err := q.Drop(1, "north"); n != 1 || err != nil {
+		t.Errorf("Drop() = %d, %v, want 1", n, err)
+	}
+	due, _ := q.Take(now)
+	if got, want := jobIds(due), []string{"default", "other user"}; !slices.Equal(got, want) && !slices.Equal(got, []string{"other user", "default"}) {
+		t.Errorf("Take() after drop = %v, want %v", got, want)
+	}
+}
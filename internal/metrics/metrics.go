@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics of the bot and bitrix client
+// Collectors are global like prometheus default registry, but registry is own so only these metrics and go runtime ones are exposed
+
+const namespace = "tomestobot"
+
+// Auth methods
+const (
+	AuthById    = "id" // Known telegram user
+	AuthByPhone = "phone"
+	AuthByCode  = "code"
+	AuthAny     = "any" // Attempts rejected before auth by rate limits and bans
+)
+
+// Auth results
+const (
+	AuthSuccess  = "success"
+	AuthFailure  = "failure"
+	AuthRejected = "rejected"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	handlerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_requests_total",
+		Help:      "Telegram updates handled by endpoint and result(ok or error).",
+	}, []string{"endpoint", "result"})
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of telegram update handling by endpoint, includes waiting for previous updates of the user.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+
+	bxRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bx_requests_total",
+		Help:      "Bitrix REST calls by method and code(ok, bitrix error code, http status or transport).",
+	}, []string{"method", "code"})
+	bxDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bx_request_duration_seconds",
+		Help:      "Duration of bitrix REST calls by method.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method"})
+
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Auth attempts by method(id, phone, code, any) and result(success, failure, rejected).",
+	}, []string{"method", "result"})
+
+	tgErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_errors_total",
+		Help:      "Failed telegram bot API requests by method and code(http status or transport).",
+	}, []string{"method", "code"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		handlerRequests, handlerDuration,
+		bxRequests, bxDuration,
+		authAttempts,
+		tgErrors,
+	)
+}
+
+// Counts handled telegram update
+func ObserveHandler(endpoint string, duration time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	handlerRequests.WithLabelValues(endpoint, result).Inc()
+	handlerDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+// Counts bitrix call - has signature of bxclient observer
+func ObserveBx(method string, duration time.Duration, code string) {
+	bxRequests.WithLabelValues(method, code).Inc()
+	bxDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+func Auth(method, result string) {
+	authAttempts.WithLabelValues(method, result).Inc()
+}
+
+func TelegramError(method, code string) {
+	tgErrors.WithLabelValues(method, code).Inc()
+}
+
+// Registers gauge of active sessions, fn is called on every scrape
+func RegisterSessions(fn func() int) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions of authorized users.",
+	}, func() float64 {
+		return float64(fn())
+	}))
+}
+
+// Starts http server with /metrics endpoint, returned server should be shut down on exit
+func Serve(logger *slog.Logger, addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Info("metrics server started", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server: " + err.Error())
+		}
+	}()
+	return srv
+}
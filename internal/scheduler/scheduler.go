@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Runs periodic jobs of the bot in background
+// Jobs are called on every tick and decide themselves if it is time to act(e.g. digest checks users' local time)
+// so missed ticks(while bot was down) are caught up by the first tick after start
+
+type job struct {
+	name     string
+	interval time.Duration
+	fn       func(now time.Time)
+}
+
+type Scheduler struct {
+	logger *slog.Logger
+
+	jobs []job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Adds job - should be called before start
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(now time.Time)) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, fn: fn})
+}
+
+// Starts all jobs, every job is called right away and then on every tick
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.run(j)
+	}
+}
+
+// Stops jobs and waits for running ones
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(j job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	s.call(j, time.Now())
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.call(j, now)
+		}
+	}
+}
+
+// Calls job - panic of one job must not stop others
+func (s *Scheduler) call(j job, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("scheduled job panic", "job", j.name, "panic", r)
+		}
+	}()
+	started := time.Now()
+	j.fn(now)
+	s.logger.Debug("scheduled job done", "job", j.name, "took", time.Since(started))
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobIsCalledOnStartAndOnTicks(t *testing.T) {
+	s := New(slog.Default())
+	calls := atomic.Int32{}
+	done := make(chan struct{})
+	s.Every("count", 10*time.Millisecond, func(now time.Time) {
+		if calls.Add(1) == 3 {
+			close(done)
+		}
+	})
+	s.Start()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("job is called %d times", calls.Load())
+	}
+	s.Stop()
+
+	stopped := calls.Load()
+	time.Sleep(30 * time.Millisecond)
+	if calls.Load() != stopped {
+		t.Error("job is called after stop")
+	}
+}
+
+func TestPanicDoesNotStopJobs(t *testing.T) {
+	s := New(slog.Default())
+	panics := atomic.Int32{}
+	calls := atomic.Int32{}
+	s.Every("panic", 10*time.Millisecond, func(now time.Time) {
+		panics.Add(1)
+		panic("broken job")
+	})
+	s.Every("count", 10*time.Millisecond, func(now time.Time) {
+		calls.Add(1)
+	})
+	s.Start()
+	deadline := time.Now().Add(time.Second)
+	for (panics.Load() < 2 || calls.Load() < 2) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Stop()
+	if panics.Load() < 2 || calls.Load() < 2 {
+		t.Errorf("panicking job is called %d times, other one %d times", panics.Load(), calls.Load())
+	}
+}
+
+func TestStopWaitsForRunningJob(t *testing.T) {
+	s := New(slog.Default())
+	started := make(chan struct{})
+	finished := atomic.Bool{}
+	s.Every("slow", time.Hour, func(now time.Time) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	})
+	s.Start()
+	<-started
+	s.Stop()
+	if !finished.Load() {
+		t.Error("Stop() returns before running job is finished")
+	}
+}
@@ -3,21 +3,29 @@ package bxclient
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/CGSG-2021-AE4/tomestobot/pkg/gobx/bxtypes"
 
 	"resty.dev/v3"
 )
 
+// Is called after every request with REST method, duration and result code:
+// "ok", bitrix error code, HTTP status if response has no error code, or "resty" on transport error
+type Observer func(method string, duration time.Duration, code string)
+
 type BxClient interface {
 	SetDebug(b bool)
+	SetObserver(o Observer)
 	Do(method string, bodyData any, respData any) (*resty.Response, error)
 	io.Closer
 }
 
 type bxClient struct {
-	client *resty.Client
-	apiUrl string // URL to the API (includes user id and hook)
+	client   *resty.Client
+	apiUrl   string // URL to the API (includes user id and hook)
+	observer Observer
 }
 
 func New(hostUrl string, userId int, secret string) BxClient {
@@ -31,6 +39,10 @@ func (c *bxClient) SetDebug(b bool) {
 	c.client.SetDebug(b)
 }
 
+func (c *bxClient) SetObserver(o Observer) {
+	c.observer = o
+}
+
 // From Do functions all errors already wrapped!!! so I do not have to wrap them later to mark error's level
 func (c *bxClient) Do(method string, bodyData any, respData any) (*resty.Response, error) {
 	// Setup request
@@ -45,19 +57,33 @@ func (c *bxClient) Do(method string, bodyData any, respData any) (*resty.Respons
 	}
 
 	// Make request
+	start := time.Now()
 	resp, err := req.Post(c.apiUrl + method)
 
 	// Handling errors
 	if err != nil { // Resty internal error
+		c.observe(method, start, "resty")
 		return nil, bxtypes.ErrorResty{Err: err}
 	}
 	if resp.IsError() { // HTTP status code >= 400
+		code := strconv.Itoa(resp.StatusCode())
+		if respErr, ok := resp.Error().(*bxtypes.ResponseError); ok && respErr.Code != "" {
+			code = respErr.Code
+		}
+		c.observe(method, start, code)
 		return resp, bxtypes.ErrorStatusCode(resp.StatusCode())
 	}
 
+	c.observe(method, start, "ok")
 	return resp, nil
 }
 
+func (c *bxClient) observe(method string, start time.Time, code string) {
+	if c.observer != nil {
+		c.observer(method, time.Since(start), code)
+	}
+}
+
 func (c *bxClient) Close() error {
 	return c.client.Close()
 }
@@ -1,7 +1,11 @@
 package bxtypes
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Now in this package there are only the types I need
@@ -13,9 +17,19 @@ import (
 // User
 
 type User struct {
-	Id       Id     `json:"ID"`
-	Name     string `json:"NAME"`
-	LastName string `json:"LAST_NAME"`
+	Id          Id     `json:"ID"`
+	Name        string `json:"NAME"`
+	LastName    string `json:"LAST_NAME"`
+	Departments IdList `json:"UF_DEPARTMENT"`
+	UserType    string `json:"USER_TYPE"` // employee, extranet or email
+
+	Login string `json:"LOGIN"` // Is not sent by some portals
+	Email string `json:"EMAIL"`
+
+	// Phones are free text - the same number could be written in different ways
+	PersonalMobile string `json:"PERSONAL_MOBILE"`
+	PersonalPhone  string `json:"PERSONAL_PHONE"`
+	WorkPhone      string `json:"WORK_PHONE"`
 }
 
 var NilUser = User{
@@ -24,6 +38,15 @@ var NilUser = User{
 	LastName: "",
 }
 
+// Department of company structure
+
+type Department struct {
+	Id     Id     `json:"ID"`
+	Name   string `json:"NAME"`
+	Parent Id     `json:"PARENT"`
+	HeadId Id     `json:"UF_HEAD"`
+}
+
 // Deal
 
 type Deal struct {
@@ -32,6 +55,16 @@ type Deal struct {
 	TypeId     string `json:"TYPE_ID"`
 	CategoryId string `json:"CATEGORY_ID"`
 	StageId    string `json:"STAGE_ID"`
+	AssignedId Id     `json:"ASSIGNED_BY_ID"`
+	ModifiedBy Id     `json:"MODIFY_BY_ID"`
+
+	// Detail fields - are filled only by crm.deal.get
+	Opportunity string `json:"OPPORTUNITY"` // Bitrix sends money as string
+	CurrencyId  string `json:"CURRENCY_ID"`
+	CloseDate   Time   `json:"CLOSEDATE"`
+	SourceId    string `json:"SOURCE_ID"`
+	Comments    string `json:"COMMENTS"`
+	CompanyId   Id     `json:"COMPANY_ID"`
 }
 
 var NilDeal = Deal{
@@ -42,6 +75,38 @@ var NilDeal = Deal{
 	StageId:    "",
 }
 
+// Contact and company
+
+// Multi value field like phone or email
+type Multifield struct {
+	Value     string `json:"VALUE"`
+	ValueType string `json:"VALUE_TYPE"`
+}
+
+type Contact struct {
+	Id         Id           `json:"ID"`
+	Name       string       `json:"NAME"`
+	SecondName string       `json:"SECOND_NAME"`
+	LastName   string       `json:"LAST_NAME"`
+	Post       string       `json:"POST"`
+	Phone      []Multifield `json:"PHONE"`
+	Email      []Multifield `json:"EMAIL"`
+}
+
+type Company struct {
+	Id    Id           `json:"ID"`
+	Title string       `json:"TITLE"`
+	Phone []Multifield `json:"PHONE"`
+	Email []Multifield `json:"EMAIL"`
+}
+
+// Link between deal and contact
+type DealContactItem struct {
+	ContactId Id     `json:"CONTACT_ID"`
+	IsPrimary string `json:"IS_PRIMARY"` // Y/N
+	Sort      int    `json:"SORT"`
+}
+
 // Deal stages
 // Now only constants
 const (
@@ -52,6 +117,15 @@ const (
 	DealStageExecuting         = "C1:EXECUTING"
 )
 
+// All known stages in pipeline order
+var DealStages = []string{
+	DealStageNew,
+	DealStagePreparation,
+	DealStageGetDecision,
+	DealStagePrepaymentInvoice,
+	DealStageExecuting,
+}
+
 func DealStageText(str string) string {
 	switch str {
 	case DealStageNew:
@@ -99,6 +173,25 @@ type Task struct {
 	Id     Id        `json:"ID"`
 	Title  string    `json:"TITLE"`
 	Status TaskState `json:"STATUS"`
+
+	// Fields of tasks.task.get - it returns keys in camel case
+	ResponsibleId Id      `json:"responsibleId"`
+	CreatedBy     Id      `json:"createdBy"`
+	ChangedBy     Id      `json:"changedBy"`
+	Deadline      Time    `json:"deadline"`
+	Crm           StrList `json:"ufCrmTask"` // Linked crm entities like D_12
+}
+
+// Returns id of the deal the task is linked to, zero if there is no one
+func (t Task) DealId() Id {
+	for _, e := range t.Crm {
+		if str, ok := strings.CutPrefix(e, "D_"); ok {
+			if id, err := strconv.Atoi(str); err == nil {
+				return Id(id)
+			}
+		}
+	}
+	return 0
 }
 
 var NilTask = Task{
@@ -107,6 +200,79 @@ var NilTask = Task{
 	Status: 0,
 }
 
+// Timeline
+
+type TimelineComment struct {
+	Id         Id     `json:"ID"`
+	Created    Time   `json:"CREATED"`
+	AuthorId   Id     `json:"AUTHOR_ID"`
+	Comment    string `json:"COMMENT"`
+	EntityId   Id     `json:"ENTITY_ID"`
+	EntityType string `json:"ENTITY_TYPE"` // deal, lead, contact...
+}
+
+type Activity struct {
+	Id          Id     `json:"ID"`
+	Created     Time   `json:"CREATED"`
+	AuthorId    Id     `json:"AUTHOR_ID"`
+	Subject     string `json:"SUBJECT"`
+	Description string `json:"DESCRIPTION"`
+}
+
+// Owner type ids for activities
+const (
+	OwnerTypeDeal = 2
+)
+
+// Combined timeline record - is not a bitrix type, built from comments and activities
+type TimelineEntryKind int
+
+const (
+	TimelineEntryComment = TimelineEntryKind(iota)
+	TimelineEntryActivity
+)
+
+type TimelineEntry struct {
+	Kind       TimelineEntryKind
+	Id         Id
+	Created    time.Time
+	AuthorId   Id
+	AuthorName string
+	Text       string
+}
+
+// File that is uploaded inline with request fields
+// Bitrix expects it as pair of file name and base64 encoded content
+
+type File struct {
+	Name    string
+	Content []byte
+}
+
+func (f File) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]string{f.Name, base64.StdEncoding.EncodeToString(f.Content)})
+}
+
+// Time type - bitrix sends dates in ISO 8601 format but sometimes it is empty string
+
+type Time struct {
+	time.Time
+}
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	if str == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, str)
+	t.Time = parsed
+	return err
+}
+
 // Resource id type
 
 type Id int
@@ -116,10 +282,51 @@ func (id Id) String() string {
 }
 
 func (id *Id) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" { // Empty links like COMPANY_ID
+		*id = 0
+		return nil
+	}
 	if len(b) > 0 && b[0] == '"' { // Because in Bitrix' responses id is sometimes number sometimes string...!?
 		b = b[1 : len(b)-1]
 	}
+	if len(b) == 0 { // Empty links like UF_HEAD of department without head
+		*id = 0
+		return nil
+	}
 	i, err := strconv.Atoi(string(b))
 	*id = Id(i)
 	return err
 }
+
+// List of ids - Bitrix sends false instead of empty array in some fields like UF_DEPARTMENT
+type IdList []Id
+
+func (l *IdList) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '[' {
+		*l = nil
+		return nil
+	}
+	ids := []Id{}
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return err
+	}
+	*l = ids
+	return nil
+}
+
+// List of strings - bitrix sends false instead of empty list
+
+type StrList []string
+
+func (l *StrList) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '[' {
+		*l = nil
+		return nil
+	}
+	strs := []string{}
+	if err := json.Unmarshal(b, &strs); err != nil {
+		return err
+	}
+	*l = strs
+	return nil
+}
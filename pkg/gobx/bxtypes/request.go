@@ -16,6 +16,24 @@ type ReqUserGet struct { // Do not use ReqArrayParams because there is no select
 	Start     int               `json:"START"`
 }
 
+// Several requests in one call - commands are like "user.get?FILTER[ID]=1"
+type ReqBatch struct {
+	Halt bool              `json:"halt"` // Stop on the first error
+	Cmd  map[string]string `json:"cmd"`
+}
+
+type ReqImNotify struct {
+	UserId  Id     `json:"USER_ID"`
+	Message string `json:"MESSAGE"`
+	Type    string `json:"TYPE"` // SYSTEM - without sender
+}
+
+type ReqDepartmentGet struct { // Filter fields are passed at the top level
+	HeadId Id  `json:"UF_HEAD,omitempty"`
+	Parent Id  `json:"PARENT,omitempty"`
+	Start  int `json:"START"`
+}
+
 type ReqCrmDealList struct {
 	ReqArrayParams
 	Start int `json:"START"`
@@ -26,7 +44,7 @@ type ReqCrmTimelineCommentAddFields struct {
 	EntityType string `json:"ENTITY_TYPE"`
 	Comment    string `json:"COMMENT"`
 	AuthorId   Id     `json:"AUTHOR_ID"`
-	// There are also files but I don't use them now
+	Files      []File `json:"FILES,omitempty"`
 }
 
 type ReqCrmTimelineCommentAdd struct {
@@ -44,3 +62,41 @@ type ReqTasksTaskList struct {
 type ReqTasksTaskComplete struct {
 	TaskId Id `json:"taskId"`
 }
+
+type ReqTasksTaskRenew = ReqTasksTaskComplete
+
+type ReqTasksTaskGet struct {
+	TaskId Id       `json:"taskId"`
+	Select []string `json:"select"`
+}
+
+type ReqCrmTimelineCommentDelete struct {
+	Id          Id  `json:"id"`
+	OwnerTypeId int `json:"ownerTypeId"`
+	OwnerId     Id  `json:"ownerId"`
+}
+
+type ReqCrmTimelineCommentList struct {
+	Select []string          `json:"select"`
+	Order  map[string]string `json:"order"`
+	Filter map[string]string `json:"filter"`
+	Start  int               `json:"start"`
+}
+
+type ReqCrmActivityList struct {
+	Select []string          `json:"select"`
+	Order  map[string]string `json:"order"`
+	Filter map[string]string `json:"filter"`
+	Start  int               `json:"start"`
+}
+
+// Requests of single entities by id
+type ReqGetById struct {
+	Id Id `json:"id"`
+}
+
+type ReqCrmDealGet = ReqGetById
+type ReqCrmDealContactItemsGet = ReqGetById
+type ReqCrmContactGet = ReqGetById
+type ReqCrmCompanyGet = ReqGetById
+type ReqCrmTimelineCommentGet = ReqGetById
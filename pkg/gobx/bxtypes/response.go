@@ -1,6 +1,7 @@
 package bxtypes
 
 import (
+	"encoding/json"
 	"fmt"
 )
 
@@ -12,6 +13,7 @@ type Response[T any] struct {
 type ArrayResponse[T any] struct {
 	Result []T `json:"result"`
 	Total  int `json:"total"` // For array results
+	Next   int `json:"next"`  // Start of the next page, zero on the last one
 	// Time   Time `json:"time"` // Do not need now
 }
 
@@ -30,4 +32,32 @@ type ResTasksTaskList struct {
 	Tasks []Task `json:"tasks"`
 }
 
+// Task list is an object, so it is paged separately from array responses
+type TasksListResponse struct {
+	Result ResTasksTaskList `json:"result"`
+	Total  int              `json:"total"`
+	Next   int              `json:"next"` // Start of the next page, zero on the last one
+}
+
+type ResTasksTaskGet struct {
+	Task Task `json:"task"`
+}
+
+// Results of batch commands by their names - raw because every command has its own result type
+// Bitrix sends empty maps as [] so these fields should be decoded with BatchMap
+type ResBatch struct {
+	Result json.RawMessage `json:"result"`
+	Errors json.RawMessage `json:"result_error"`
+}
+
+// Decodes batch map that could be sent as empty array
+func BatchMap[T any](data json.RawMessage) (map[string]T, error) {
+	m := map[string]T{}
+	if len(data) == 0 || data[0] == '[' {
+		return m, nil
+	}
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
 type ResCrmTimelineCommentAdd Id // Id of added comment
@@ -3,6 +3,7 @@ package log
 import (
 	"context"
 	"log/slog"
+	"slices"
 )
 
 // Type that implements handler interface and logging itself and storing messages
@@ -31,7 +32,7 @@ func (h *logsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 		level:  h.level,
 
 		groups: h.groups,
-		attrs:  attrs,
+		attrs:  append(slices.Clip(h.attrs), attrs...), // Attrs of parent logger are kept
 	}
 }
 
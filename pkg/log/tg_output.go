@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 
 	tele "gopkg.in/telebot.v4"
 )
@@ -13,17 +14,57 @@ import (
 
 type TgOutput struct {
 	bot        *tele.Bot
-	recipients []tele.Recipient
+	mutex      sync.Mutex // Recipients are added by handlers while others log
+	recipients []tgRecipient
 }
 
+// Scoped recipient gets only records that have scope attr with the same value
+// Records without the attr(e.g. of the whole bot) go only to recipients of all records
+type tgRecipient struct {
+	recipient  tele.Recipient
+	scopeKey   string // Empty means all records
+	scopeValue string
+}
+
+func (r tgRecipient) match(record slog.Record) bool {
+	if r.scopeKey == "" {
+		return true
+	}
+	match := false
+	record.Attrs(func(a slog.Attr) bool {
+		if a.Key == r.scopeKey {
+			match = a.Value.String() == r.scopeValue
+			return false
+		}
+		return true
+	})
+	return match
+}
+
+// Adds recipient of all records
 func (out *TgOutput) Add(r tele.Recipient) {
-	out.recipients = append(out.recipients, r)
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	out.recipients = append(out.recipients, tgRecipient{recipient: r})
+}
+
+// Adds recipient of records with attr key equal to value
+func (out *TgOutput) AddScoped(r tele.Recipient, key, value string) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	out.recipients = append(out.recipients, tgRecipient{recipient: r, scopeKey: key, scopeValue: value})
 }
 
 // Output interface implementation
 func (out *TgOutput) Handle(ctx context.Context, groups []string, record slog.Record) error {
-	for _, r := range out.recipients {
-		if _, err := out.bot.Send(r, formatTgError(groups, record)); err != nil {
+	out.mutex.Lock()
+	recipients := out.recipients
+	out.mutex.Unlock()
+	for _, r := range recipients {
+		if !r.match(record) {
+			continue
+		}
+		if _, err := out.bot.Send(r.recipient, formatTgError(groups, record)); err != nil {
 			return err
 		}
 	}
@@ -45,6 +86,6 @@ func formatTgError(groups []string, record slog.Record) string {
 func NewTgOutput(bot *tele.Bot) *TgOutput {
 	return &TgOutput{
 		bot:        bot,
-		recipients: []tele.Recipient{},
+		recipients: []tgRecipient{},
 	}
 }
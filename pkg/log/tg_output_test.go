@@ -0,0 +1,35 @@
+package log
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRecipientMatch(t *testing.T) {
+	record := func(attrs ...any) slog.Record {
+		r := slog.NewRecord(time.Now(), slog.LevelWarn, "msg", 0)
+		r.Add(attrs...)
+		return r
+	}
+	global := tgRecipient{}
+	north := tgRecipient{scopeKey: "portal", scopeValue: "north"}
+	tests := []struct {
+		name   string
+		r      tgRecipient
+		record slog.Record
+		want   bool
+	}{
+		{"global gets scoped record", global, record("portal", "north"), true},
+		{"global gets record without scope", global, record("tgId", 1), true},
+		{"scoped gets its portal", north, record("tgId", 1, "portal", "north"), true},
+		{"scoped does not get other portal", north, record("portal", "south"), false},
+		{"scoped does not get default portal", north, record("portal", ""), false},
+		{"scoped does not get record without scope", north, record("tgId", 1), false},
+	}
+	for _, tt := range tests {
+		if got := tt.r.match(tt.record); got != tt.want {
+			t.Errorf("%s: match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
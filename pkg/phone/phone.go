@@ -0,0 +1,114 @@
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// E.164 normalization of phone numbers
+// Numbers without country code are treated as numbers of the default region
+// Only regions with fixed national number length are supported - it is enough to tell national numbers from international ones
+
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// Numbering plan of a region
+type Region struct {
+	Code        string // ISO 3166 code like RU
+	CallingCode string // Country calling code without +
+	Trunk       string // National prefix like 8 in Russia
+	Intl        string // National international call prefix besides 00, like 810 in Russia
+	NationalLen int    // Length of national significant number
+}
+
+var regions = map[string]Region{
+	"RU": {Code: "RU", CallingCode: "7", Trunk: "8", Intl: "810", NationalLen: 10},
+	"KZ": {Code: "KZ", CallingCode: "7", Trunk: "8", Intl: "810", NationalLen: 10},
+	"BY": {Code: "BY", CallingCode: "375", Trunk: "80", Intl: "810", NationalLen: 9},
+	"UA": {Code: "UA", CallingCode: "380", Trunk: "0", NationalLen: 9},
+	"US": {Code: "US", CallingCode: "1", Trunk: "1", Intl: "011", NationalLen: 10},
+	"CA": {Code: "CA", CallingCode: "1", Trunk: "1", Intl: "011", NationalLen: 10},
+	"GB": {Code: "GB", CallingCode: "44", Trunk: "0", NationalLen: 10},
+}
+
+// Returns numbering plan by ISO code(case insensitive)
+func LookupRegion(code string) (Region, bool) {
+	r, ok := regions[strings.ToUpper(code)]
+	return r, ok
+}
+
+// Returns number in E.164 format(+79991234567)
+// Number could contain any separators: spaces, dashes, dots and brackets
+func Normalize(raw string, region Region) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if i := strings.IndexAny(raw, ",;"); i >= 0 { // Several numbers in one field - the first one is used
+		raw = raw[:i]
+	}
+	plus := strings.HasPrefix(raw, "+")
+	digits := strings.Builder{}
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.()/+ ", r):
+		default:
+			return "", fmt.Errorf("%w: unexpected symbol %q", ErrInvalidNumber, r)
+		}
+	}
+	number := digits.String()
+
+	switch {
+	case plus:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case region.Intl != "" && strings.HasPrefix(number, region.Intl) && len(number) > len(region.Intl)+region.NationalLen:
+		number = number[len(region.Intl):]
+	case len(number) == region.NationalLen:
+		number = region.CallingCode + number
+	case len(number) == len(region.Trunk)+region.NationalLen && strings.HasPrefix(number, region.Trunk):
+		number = region.CallingCode + number[len(region.Trunk):]
+	}
+	// Otherwise it is international number without plus - telegram sends them so
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", fmt.Errorf("%w: %s", ErrInvalidNumber, raw)
+	}
+	return "+" + number, nil
+}
+
+// Returns spellings of E.164 number that people use - for exact match search
+// National ones are added only for numbers of the region
+func Variants(e164 string, region Region) []string {
+	digits := strings.TrimPrefix(e164, "+")
+	variants := []string{e164, digits}
+
+	national, ok := strings.CutPrefix(digits, region.CallingCode)
+	if !ok || len(national) != region.NationalLen {
+		return variants
+	}
+	intl := "+" + region.CallingCode
+	variants = append(variants, region.Trunk+national, national)
+	if region.NationalLen == 10 { // Like 999 123-45-67
+		code, a, b, c := national[:3], national[3:6], national[6:8], national[8:]
+		for _, prefix := range []string{intl, region.Trunk} {
+			variants = append(variants,
+				prefix+" ("+code+") "+a+"-"+b+"-"+c,
+				prefix+"("+code+")"+a+"-"+b+"-"+c,
+				prefix+" "+code+" "+a+"-"+b+"-"+c,
+				prefix+" "+code+" "+a+" "+b+" "+c,
+				prefix+"-"+code+"-"+a+"-"+b+"-"+c,
+			)
+		}
+	} else { // Like 29 123-45-67
+		code, rest := national[:len(national)-7], national[len(national)-7:]
+		a, b, c := rest[:3], rest[3:5], rest[5:]
+		for _, prefix := range []string{intl, region.Trunk} {
+			variants = append(variants,
+				prefix+" ("+code+") "+a+"-"+b+"-"+c,
+				prefix+" "+code+" "+a+"-"+b+"-"+c,
+				prefix+" "+code+" "+a+" "+b+" "+c,
+			)
+		}
+	}
+	return variants
+}
@@ -0,0 +1,85 @@
+package phone
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	ru, _ := LookupRegion("ru")
+	by, _ := LookupRegion("BY")
+	tests := []struct {
+		raw    string
+		region Region
+		want   string
+	}{
+		{"+7 (999) 123-45-67", ru, "+79991234567"},
+		{"8 999 123 45 67", ru, "+79991234567"},
+		{"89991234567", ru, "+79991234567"},
+		{"79991234567", ru, "+79991234567"}, // Telegram sends numbers without plus
+		{"9991234567", ru, "+79991234567"},  // National number
+		{"  +7 999 123-45-67  ", ru, "+79991234567"},
+		{"+7\u00a0999\u00a0123\u00a045\u00a067", ru, "+79991234567"}, // Non-breaking spaces
+		{"8.999.123.45.67", ru, "+79991234567"},
+		{"+7 999 123-45-67, 8 800 555-35-35", ru, "+79991234567"}, // The first of several numbers
+		{"0079991234567", ru, "+79991234567"},
+		{"81079991234567", ru, "+79991234567"},
+		{"+375 29 123-45-67", ru, "+375291234567"},
+		{"80 29 123-45-67", by, "+375291234567"},
+		{"291234567", by, "+375291234567"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.region)
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q, %s) = %q, %v, want %q", tt.raw, tt.region.Code, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	ru, _ := LookupRegion("RU")
+	for _, raw := range []string{"", "   ", "123", "+7 999 abc-45-67", "ext. 123", "+0 999 123 45 67", "+7 999 123 45 67 89 01 23"} {
+		if got, err := Normalize(raw, ru); !errors.Is(err, ErrInvalidNumber) {
+			t.Errorf("Normalize(%q) = %q, %v, want invalid number", raw, got, err)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	ru, _ := LookupRegion("RU")
+	by, _ := LookupRegion("BY")
+	tests := []struct {
+		name    string
+		e164    string
+		region  Region
+		include []string
+		count   int
+	}{
+		{"national number", "+79991234567", ru, []string{
+			"+79991234567", "79991234567", "89991234567", "9991234567",
+			"+7 (999) 123-45-67", "8 (999) 123-45-67", "+7 999 123 45 67", "8-999-123-45-67",
+		}, 14},
+		{"number of other region", "+375291234567", ru, []string{"+375291234567", "375291234567"}, 2},
+		{"short national number", "+375291234567", by, []string{
+			"+375291234567", "80291234567", "291234567", "+375 (29) 123-45-67", "80 29 123 45 67",
+		}, 10},
+	}
+	for _, tt := range tests {
+		got := Variants(tt.e164, tt.region)
+		if len(got) != tt.count {
+			t.Errorf("%s: %d variants, want %d: %q", tt.name, len(got), tt.count, got)
+		}
+		for _, v := range tt.include {
+			if !slices.Contains(got, v) {
+				t.Errorf("%s: %q is not in variants %q", tt.name, v, got)
+			}
+		}
+		// Every variant is the same number
+		for _, v := range got {
+			if n, err := Normalize(v, tt.region); err != nil || n != tt.e164 {
+				t.Errorf("%s: variant %q is normalized to %q, %v", tt.name, v, n, err)
+			}
+		}
+	}
+}